@@ -4,7 +4,6 @@ import (
 	"context"
 	"log"
 	"os"
-	"time"
 
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -18,29 +17,39 @@ func init() {
 	ConnectDB()
 }
 
+// ConnectDB creates the MongoDB client. The driver connects on first use,
+// so the server checks the connection with PingDB when it starts.
 func ConnectDB() {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
+	// The settings may also come from the environment, as in tests
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file loaded, using the environment")
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
 
-	clientOptions := options.Client().ApplyURI(os.Getenv("MONGODB_URI"))
-	client, err := mongo.Connect(ctx, clientOptions)
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		uri = "mongodb://localhost:27017"
+	}
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uri))
 	if err != nil {
 		log.Fatal(err)
 	}
+	DB = client
+}
 
-	err = client.Ping(ctx, nil)
-	if err != nil {
-		log.Fatal("MongoDB connection error:", err)
-	}
+// PingDB checks that MongoDB can be reached
+func PingDB(ctx context.Context) error {
+	return DB.Ping(ctx, nil)
+}
 
-	log.Println("MongoDB connected ✅")
-	DB = client
+// DatabaseName is the database of the app's collections: MONGODB_DATABASE,
+// or "diary_app". Tests set it to keep their data apart.
+func DatabaseName() string {
+	if name := os.Getenv("MONGODB_DATABASE"); name != "" {
+		return name
+	}
+	return "diary_app"
 }
 
 func GetCollection(collectionName string) *mongo.Collection {
-	return DB.Database("diary_app").Collection(collectionName)
+	return DB.Database(DatabaseName()).Collection(collectionName)
 }
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"log"
+	"personal-diary/services"
+)
+
+var (
+	// chatModel is the chat model selected by LLM_PROVIDER / LLM_MODEL as
+	// is, for callers that meter and redact their calls themselves
+	chatModel services.LLMProvider
+
+	// llmProvider is the chat model selected by LLM_PROVIDER / LLM_MODEL,
+	// metered against the calling user's AI quota. Personal information is
+	// redacted from what it is sent.
+	llmProvider services.LLMProvider
+
+	// textRefiner is what RefineTextHandler uses; any provider can refine text
+	textRefiner services.TextRefiner
+
+	// embedder is the embeddings model selected by EMBEDDING_PROVIDER /
+	// EMBEDDING_MODEL, metered and redacted like llmProvider
+	embedder services.Embedder
+
+	// transcriber is the speech-to-text model selected by
+	// TRANSCRIPTION_PROVIDER / TRANSCRIPTION_MODEL, metered like
+	// llmProvider. Audio cannot be redacted.
+	transcriber services.Transcriber
+)
+
+func init() {
+	configureAIProviders()
+}
+
+// configureAIProviders builds the AI models from the environment
+func configureAIProviders() {
+	chatModel = newLLMProvider()
+	llmProvider = services.NewRedactingProvider(
+		services.NewMeteredProvider(chatModel, usageMeter), redactionStore)
+	textRefiner = llmProvider
+	streamingRefiner = llmProvider
+	embedder = services.NewRedactingEmbedder(
+		services.NewMeteredEmbedder(newEmbedder(), usageMeter), redactionStore)
+	transcriber = services.NewMeteredTranscriber(newTranscriber(), usageMeter)
+}
+
+func newLLMProvider() services.LLMProvider {
+	provider, err := services.NewLLMProviderFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure LLM provider: %v", err)
+	}
+	log.Printf("LLM provider: %s (model %s)", provider.Name(), provider.Model())
+	return provider
+}
+
+func newEmbedder() services.Embedder {
+	embedder, err := services.NewEmbedderFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure embedder: %v", err)
+	}
+	log.Printf("Embedder: %s (model %s)", embedder.Name(), embedder.Model())
+	return embedder
+}
+
+func newTranscriber() services.Transcriber {
+	transcriber, err := services.NewTranscriberFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure transcriber: %v", err)
+	}
+	log.Printf("Transcriber: %s (model %s)", transcriber.Name(), transcriber.Model())
+	return transcriber
+}
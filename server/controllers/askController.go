@@ -0,0 +1,249 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"personal-diary/config"
+	"personal-diary/models"
+	"personal-diary/services"
+	"personal-diary/utils"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var entryChunkCollection *mongo.Collection = config.GetCollection("entry_chunks")
+
+const (
+	// askTopChunks is how many passages are put into the prompt
+	askTopChunks      = 6
+	maxQuestionLength = 1000
+	// askIndexLimit bounds how many unindexed entries an ask request embeds
+	// before answering; the rest are indexed in the background
+	askIndexLimit = 20
+)
+
+// indexWorkers bounds how many entries are embedded in the background at once
+var indexWorkers = make(chan struct{}, 2)
+
+// indexEntryAsync embeds the entry in the background for "ask my diary"
+func indexEntryAsync(entry models.DiaryEntry) {
+	go func() {
+		indexWorkers <- struct{}{}
+		defer func() { <-indexWorkers }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+
+		if err := indexEntry(ctx, entry); err != nil {
+			log.Printf("Failed to index entry %s: %v", entry.ID, err)
+		}
+	}()
+}
+
+// indexEntry splits the entry into passages, embeds them and replaces the
+// entry's stored chunks. Entries whose chunks are current are skipped.
+func indexEntry(ctx context.Context, entry models.DiaryEntry) error {
+	ctx = utils.WithUserEmail(ctx, entry.Email)
+	hash := services.EntryIndexHash(entry)
+	current, err := entryChunkCollection.CountDocuments(ctx, bson.M{
+		"entryId": entry.ID, "email": entry.Email, "contentHash": hash, "model": embedder.Model(),
+	})
+	if err != nil {
+		return err
+	}
+	if current > 0 {
+		return nil
+	}
+
+	texts := services.ChunkEntry(entry)
+	inputs := make([]string, len(texts))
+	for i, text := range texts {
+		inputs[i] = services.ChunkEmbeddingText(entry.Title, entry.CreatedAt, text)
+	}
+	vectors, err := embedder.Embed(ctx, inputs)
+	if err != nil {
+		return err
+	}
+
+	// Skip the write if the entry was edited or deleted while embedding
+	unchanged, err := diaryCollection.CountDocuments(ctx, bson.M{
+		"_id": entry.ID, "email": entry.Email, "title": entry.Title, "content": entry.Content,
+	})
+	if err != nil || unchanged == 0 {
+		return err
+	}
+
+	var writes []mongo.WriteModel
+	for i, text := range texts {
+		chunk := models.EntryChunk{
+			ID:             fmt.Sprintf("%s:%d", entry.ID, i),
+			EntryID:        entry.ID,
+			Email:          entry.Email,
+			Index:          i,
+			Text:           text,
+			Vector:         vectors[i],
+			Model:          embedder.Model(),
+			ContentHash:    hash,
+			EntryTitle:     entry.Title,
+			EntryCreatedAt: entry.CreatedAt,
+		}
+		writes = append(writes, mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": chunk.ID}).SetReplacement(chunk).SetUpsert(true))
+	}
+	writes = append(writes, mongo.NewDeleteManyModel().SetFilter(bson.M{
+		"entryId": entry.ID, "email": entry.Email, "index": bson.M{"$gte": len(texts)},
+	}))
+	if _, err := entryChunkCollection.BulkWrite(ctx, writes); err != nil {
+		return err
+	}
+	vectorIndex.Upsert(entry.Email, entry.ID, services.EntryVector(vectors), entry.CreatedAt)
+	return nil
+}
+
+// removeEntryChunks drops the passages of a deleted entry
+func removeEntryChunks(ctx context.Context, email, entryID string) {
+	if _, err := entryChunkCollection.DeleteMany(ctx, bson.M{"entryId": entryID, "email": email}); err != nil {
+		log.Printf("Failed to remove chunks of %s: %v", entryID, err)
+	}
+	vectorIndex.Remove(email, entryID)
+}
+
+// staleEntries returns the user's entries that have no current chunks, such
+// as entries written before indexing existed or under another model
+func staleEntries(ctx context.Context, email string) ([]models.DiaryEntry, error) {
+	cursor, err := entryChunkCollection.Find(ctx,
+		bson.M{"email": email, "index": 0, "model": embedder.Model()},
+		options.Find().SetProjection(bson.M{"entryId": 1, "contentHash": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var indexed []models.EntryChunk
+	if err := cursor.All(ctx, &indexed); err != nil {
+		return nil, err
+	}
+	hashes := make(map[string]string, len(indexed))
+	for _, chunk := range indexed {
+		hashes[chunk.EntryID] = chunk.ContentHash
+	}
+
+	cursor, err = diaryCollection.Find(ctx, bson.M{"email": email},
+		options.Find().SetProjection(bson.M{"title": 1, "content": 1, "createdAt": 1, "email": 1}).
+			SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	var entries []models.DiaryEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	var stale []models.DiaryEntry
+	for _, entry := range entries {
+		if hashes[entry.ID] != services.EntryIndexHash(entry) {
+			stale = append(stale, entry)
+		}
+	}
+	return stale, nil
+}
+
+// indexStaleEntries embeds up to askIndexLimit stale entries of the user
+// and leaves the rest to the background
+func indexStaleEntries(ctx context.Context, email string) error {
+	stale, err := staleEntries(ctx, email)
+	if err != nil {
+		return err
+	}
+	for i, entry := range stale {
+		if i >= askIndexLimit {
+			indexEntryAsync(entry)
+			continue
+		}
+		if err := indexEntry(ctx, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AskDiary answers a question about the user's diary from the most relevant
+// passages and returns the entries the answer cites
+func AskDiary(w http.ResponseWriter, r *http.Request) {
+	var req models.AskRequest
+	payload := models.NewPayload()
+	result := models.NewResponse()
+	if err := payload.DecodePayload(r, &req); err != nil {
+		result.ErrorResponse(w, "Invalid request payload")
+		return
+	}
+	question := strings.TrimSpace(req.Question)
+	if question == "" {
+		result.ErrorResponse(w, "Question is required")
+		return
+	}
+	if len(question) > maxQuestionLength {
+		result.ErrorResponse(w, "Question is too long (max 1000 characters)")
+		return
+	}
+
+	email := getEmailFromHeader(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+	defer cancel()
+
+	if err := indexStaleEntries(ctx, email); err != nil {
+		log.Printf("Indexing entries of %s failed: %v", email, err)
+		aiErrorResponse(w, result, err, "Failed to search diary")
+		return
+	}
+
+	vectors, err := embedder.Embed(ctx, []string{question})
+	if err != nil {
+		log.Printf("Embedding question failed: %v", err)
+		aiErrorResponse(w, result, err, "Failed to search diary")
+		return
+	}
+
+	cursor, err := entryChunkCollection.Find(ctx, bson.M{"email": email, "model": embedder.Model()})
+	if err != nil {
+		result.ErrorResponse(w, "Failed to search diary")
+		return
+	}
+	var chunks []models.EntryChunk
+	if err := cursor.All(ctx, &chunks); err != nil {
+		result.ErrorResponse(w, "Failed to search diary")
+		return
+	}
+
+	var relevant []services.ScoredChunk
+	for _, scored := range services.RankChunks(vectors[0], chunks, askTopChunks) {
+		if scored.Score > 0 {
+			relevant = append(relevant, scored)
+		}
+	}
+	if len(relevant) == 0 {
+		result.SetData(models.AskResponse{
+			Answer:  "I couldn't find anything in your diary about that.",
+			Sources: []models.AskSource{},
+		})
+		result.SuccessResponse(w, "Question answered successfully")
+		return
+	}
+
+	answer, cited, err := services.AnswerQuestion(ctx, llmProvider, question, relevant, time.Now())
+	if err != nil {
+		log.Printf("Answering question failed: %v", err)
+		aiErrorResponse(w, result, err, "Failed to answer question")
+		return
+	}
+
+	result.SetData(models.AskResponse{
+		Answer:        answer,
+		Sources:       services.CitedSources(cited),
+		PromptVersion: services.PromptVersion(ctx, services.PromptAsk),
+	})
+	result.SuccessResponse(w, "Question answered successfully")
+}
@@ -0,0 +1,34 @@
+package controllers
+
+import "log"
+
+// backgroundQueue runs tasks on a fixed number of workers. Its buffer is
+// bounded too: when it is full, tasks are dropped instead of piling up
+// goroutines while the provider is slow.
+type backgroundQueue struct {
+	name  string
+	tasks chan func()
+}
+
+func newBackgroundQueue(name string, workers, size int) *backgroundQueue {
+	q := &backgroundQueue{name: name, tasks: make(chan func(), size)}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for task := range q.tasks {
+				task()
+			}
+		}()
+	}
+	return q
+}
+
+// Submit queues task and reports whether there was room for it
+func (q *backgroundQueue) Submit(task func()) bool {
+	select {
+	case q.tasks <- task:
+		return true
+	default:
+		log.Printf("The %s queue is full, dropping a task", q.name)
+		return false
+	}
+}
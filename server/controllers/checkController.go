@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"personal-diary/models"
+	"personal-diary/services"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// CheckTextHandler lists spelling, grammar and clarity issues in a text as
+// annotations with offsets, leaving the text itself unchanged
+func CheckTextHandler(w http.ResponseWriter, r *http.Request) {
+	payload := models.NewPayload()
+	result := models.NewResponse()
+	var req models.CheckRequest
+
+	if err := payload.DecodePayload(r, &req); err != nil {
+		result.ErrorResponse(w, "Invalid JSON format")
+		return
+	}
+	text := strings.TrimSpace(req.Text)
+	if message := validateRefineText(text, maxCheckChars); message != "" {
+		result.ErrorResponse(w, message)
+		return
+	}
+
+	check, err := services.CheckText(r.Context(), llmProvider, text)
+	if err != nil {
+		log.Printf("Checking text failed: %v", err)
+		aiErrorResponse(w, result, err, "Failed to check text")
+		return
+	}
+	// Offsets refer to the text as sent, before the leading space was trimmed
+	lead := utf8.RuneCountInString(req.Text[:len(req.Text)-len(strings.TrimLeftFunc(req.Text, unicode.IsSpace))])
+	for i := range check.Annotations {
+		check.Annotations[i].Start += lead
+		check.Annotations[i].End += lead
+	}
+
+	result.SetData(check)
+	result.SuccessResponse(w, "Text checked successfully")
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"personal-diary/models"
+	"personal-diary/services"
+	"strings"
+	"testing"
+)
+
+func TestCheckTextHandlerTrimsOnce(t *testing.T) {
+	var sent string
+	saved := llmProvider
+	llmProvider = &services.FakeProvider{Reply: func(req services.CompletionRequest) string {
+		sent = req.Messages[len(req.Messages)-1].Content
+		return `{"issues": [{"start": 4, "end": 7, "text": "teh", "category": "spelling", "replacements": ["the"]}]}`
+	}}
+	t.Cleanup(func() { llmProvider = saved })
+
+	check := func(t *testing.T, text string) testResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		CheckTextHandler(rec, httptest.NewRequest("POST", "/diary/check", encryptedBody(t, models.CheckRequest{Text: text})))
+		return decodeResponse(t, rec)
+	}
+
+	t.Run("padding is not sent", func(t *testing.T) {
+		text := "\n  é Saw teh lake.  \n"
+		resp := check(t, text)
+		if resp.Status != "success" {
+			t.Fatalf("status %q: %s", resp.Status, resp.Message)
+		}
+		if sent != "é Saw teh lake." {
+			t.Errorf("model was sent %q, want the trimmed text", sent)
+		}
+		var result models.CheckResponse
+		if err := json.Unmarshal(resp.Data, &result); err != nil {
+			t.Fatalf("unmarshal check response: %v", err)
+		}
+		if len(result.Annotations) != 1 {
+			t.Fatalf("got %d annotations, want 1", len(result.Annotations))
+		}
+		// Offsets count characters of the text as the client sent it
+		a := result.Annotations[0]
+		if got := string([]rune(text)[a.Start:a.End]); got != "teh" {
+			t.Errorf("annotation %d-%d covers %q, want %q", a.Start, a.End, got, "teh")
+		}
+	})
+
+	t.Run("padding does not hide the limit", func(t *testing.T) {
+		sent = ""
+		padding := strings.Repeat(" ", maxCheckChars)
+		resp := check(t, padding+"ok"+padding)
+		if resp.Status != "success" || sent != "ok" {
+			t.Errorf("got %s %q, model was sent %d characters", resp.Status, resp.Message, len(sent))
+		}
+		if resp := check(t, strings.Repeat("a", maxCheckChars+1)); resp.Status != "error" {
+			t.Error("text over the limit was accepted")
+		}
+	})
+}
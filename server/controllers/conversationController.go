@@ -0,0 +1,319 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"personal-diary/config"
+	"personal-diary/models"
+	"personal-diary/services"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var conversationCollection *mongo.Collection = config.GetCollection("conversations")
+
+const (
+	maxConversationMessageChars = 4000
+	// maxConversationMessages bounds a thread, counting both sides
+	maxConversationMessages = 200
+)
+
+var errConversationNotFound = errors.New("conversation not found")
+
+// StartConversation opens a thread with the journaling companion about an
+// entry. The user may start it with a message; otherwise the companion
+// opens with a question about the entry.
+func StartConversation(w http.ResponseWriter, r *http.Request) {
+	var req models.ConversationRequest
+	payload := models.NewPayload()
+	result := models.NewResponse()
+	if err := payload.DecodePayload(r, &req); err != nil {
+		result.ErrorResponse(w, "Invalid request payload")
+		return
+	}
+	message := strings.TrimSpace(req.Message)
+	if utf8.RuneCountInString(message) > maxConversationMessageChars {
+		result.ErrorResponse(w, fmt.Sprintf("Message is too long. Maximum %d characters allowed.", maxConversationMessageChars))
+		return
+	}
+
+	email := getEmailFromHeader(r)
+	entry, err := findConversationEntry(email, mux.Vars(r)["id"])
+	if err != nil {
+		conversationErrorResponse(w, result, err)
+		return
+	}
+
+	now := time.Now()
+	conversation := models.Conversation{
+		ID:        primitive.NewObjectID().Hex(),
+		Email:     email,
+		EntryID:   entry.ID,
+		Messages:  []models.ConversationMessage{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if message != "" {
+		conversation.Messages = append(conversation.Messages, userConversationMessage(message, now))
+	}
+
+	reply, err := services.ContinueConversation(r.Context(), llmProvider, entry, conversationHistory(conversation.Messages))
+	if err != nil {
+		log.Printf("Companion reply for entry %s failed: %v", entry.ID, err)
+		aiErrorResponse(w, result, err, "Failed to start conversation")
+		return
+	}
+	conversation.Messages = append(conversation.Messages, companionMessage(reply))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := conversationCollection.InsertOne(ctx, conversation); err != nil {
+		result.ErrorResponse(w, "Failed to start conversation")
+		return
+	}
+
+	result.SetData(models.ConversationResponse{Conversation: &conversation, Trimmed: reply.Trimmed})
+	result.SuccessResponse(w, "Conversation started successfully")
+}
+
+// GetConversations lists the threads about an entry, most recent first
+func GetConversations(w http.ResponseWriter, r *http.Request) {
+	result := models.NewResponse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"entryId": mux.Vars(r)["id"], "email": getEmailFromHeader(r)}
+	opts := options.Find().SetSort(bson.D{{Key: "updatedAt", Value: -1}})
+	cursor, err := conversationCollection.Find(ctx, filter, opts)
+	if err != nil {
+		result.ErrorResponse(w, "Failed to fetch conversations")
+		return
+	}
+	conversations := []models.Conversation{}
+	if err := cursor.All(ctx, &conversations); err != nil {
+		result.ErrorResponse(w, "Failed to fetch conversations")
+		return
+	}
+
+	result.SetData(conversations)
+	result.SuccessResponse(w, "Conversations fetched successfully")
+}
+
+// GetConversation returns a thread with its message history
+func GetConversation(w http.ResponseWriter, r *http.Request) {
+	result := models.NewResponse()
+	vars := mux.Vars(r)
+
+	conversation, err := findConversation(getEmailFromHeader(r), vars["id"], vars["conversationId"])
+	if err != nil {
+		conversationErrorResponse(w, result, err)
+		return
+	}
+
+	result.SetData(conversation)
+	result.SuccessResponse(w, "Conversation fetched successfully")
+}
+
+// PostConversationMessage adds the user's message to a thread and the
+// companion's reply. History that does not fit the model's context window is
+// left out of the prompt but kept in the thread.
+func PostConversationMessage(w http.ResponseWriter, r *http.Request) {
+	var req models.ConversationRequest
+	payload := models.NewPayload()
+	result := models.NewResponse()
+	if err := payload.DecodePayload(r, &req); err != nil {
+		result.ErrorResponse(w, "Invalid request payload")
+		return
+	}
+	message := strings.TrimSpace(req.Message)
+	if message == "" {
+		result.ErrorResponse(w, "Message cannot be empty")
+		return
+	}
+	if utf8.RuneCountInString(message) > maxConversationMessageChars {
+		result.ErrorResponse(w, fmt.Sprintf("Message is too long. Maximum %d characters allowed.", maxConversationMessageChars))
+		return
+	}
+
+	email := getEmailFromHeader(r)
+	vars := mux.Vars(r)
+	conversation, err := findConversation(email, vars["id"], vars["conversationId"])
+	if err != nil {
+		conversationErrorResponse(w, result, err)
+		return
+	}
+	if len(conversation.Messages)+2 > maxConversationMessages {
+		result.ErrorResponse(w, "This conversation is full. Save it and start a new one.")
+		return
+	}
+	entry, err := findConversationEntry(email, conversation.EntryID)
+	if err != nil {
+		conversationErrorResponse(w, result, err)
+		return
+	}
+
+	// The message is only stored with a reply, so a failed turn can be retried
+	userMessage := userConversationMessage(message, time.Now())
+	history := conversationHistory(append(conversation.Messages, userMessage))
+	reply, err := services.ContinueConversation(r.Context(), llmProvider, entry, history)
+	if err != nil {
+		log.Printf("Companion reply in conversation %s failed: %v", conversation.ID, err)
+		aiErrorResponse(w, result, err, "Failed to reply")
+		return
+	}
+	companion := companionMessage(reply)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$push": bson.M{"messages": bson.M{"$each": []models.ConversationMessage{userMessage, companion}}},
+		"$set":  bson.M{"updatedAt": companion.CreatedAt},
+	}
+	var updated models.Conversation
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = conversationCollection.FindOneAndUpdate(ctx, bson.M{"_id": conversation.ID, "email": email}, update, opts).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		conversationErrorResponse(w, result, errConversationNotFound)
+		return
+	} else if err != nil {
+		result.ErrorResponse(w, "Failed to save message")
+		return
+	}
+
+	result.SetData(models.ConversationResponse{Conversation: &updated, Trimmed: reply.Trimmed})
+	result.SuccessResponse(w, "Message sent successfully")
+}
+
+// SaveConversation stores a thread's transcript as a new entry linked to
+// the entry it discusses
+func SaveConversation(w http.ResponseWriter, r *http.Request) {
+	var req models.SaveConversationRequest
+	payload := models.NewPayload()
+	result := models.NewResponse()
+	if err := payload.DecodePayload(r, &req); err != nil {
+		result.ErrorResponse(w, "Invalid request payload")
+		return
+	}
+
+	email := getEmailFromHeader(r)
+	vars := mux.Vars(r)
+	conversation, err := findConversation(email, vars["id"], vars["conversationId"])
+	if err != nil {
+		conversationErrorResponse(w, result, err)
+		return
+	}
+	original, err := findConversationEntry(email, conversation.EntryID)
+	if err != nil {
+		conversationErrorResponse(w, result, err)
+		return
+	}
+
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		title = "Reflection: " + original.Title
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	entry := models.DiaryEntry{
+		ID:           primitive.NewObjectID().Hex(),
+		Title:        title,
+		Content:      services.ConversationTranscript(conversation.Messages),
+		CreatedAt:    time.Now(),
+		Email:        email,
+		Version:      1,
+		Tags:         original.Tags,
+		ReflectionOf: original.ID,
+	}
+	entry.UpdatedAt = entry.CreatedAt
+
+	seq, err := nextSyncSeq(ctx, email)
+	if err != nil {
+		result.ErrorResponse(w, "Failed to save conversation")
+		return
+	}
+	defer finishSyncWrite(email, seq)
+	entry.SyncSeq = seq
+	if _, err := diaryCollection.InsertOne(ctx, entry); err != nil {
+		result.ErrorResponse(w, "Failed to save conversation")
+		return
+	}
+	publishEntryEvent(email, models.EventEntryCreated, entry.ID, &entry)
+	onEntrySaved(entry)
+
+	update := bson.M{"$set": bson.M{"savedEntryId": entry.ID}}
+	if _, err := conversationCollection.UpdateOne(ctx, bson.M{"_id": conversation.ID, "email": email}, update); err != nil {
+		log.Printf("Failed to link conversation %s to entry %s: %v", conversation.ID, entry.ID, err)
+	}
+
+	result.SetData(entry)
+	result.SuccessResponse(w, "Conversation saved as a diary entry")
+}
+
+func findConversationEntry(email, id string) (models.DiaryEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var entry models.DiaryEntry
+	err := diaryCollection.FindOne(ctx, bson.M{"_id": id, "email": email}).Decode(&entry)
+	return entry, err
+}
+
+func findConversation(email, entryID, id string) (models.Conversation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var conversation models.Conversation
+	err := conversationCollection.FindOne(ctx, bson.M{"_id": id, "entryId": entryID, "email": email}).Decode(&conversation)
+	if err == mongo.ErrNoDocuments {
+		return conversation, errConversationNotFound
+	}
+	return conversation, err
+}
+
+// conversationErrorResponse reports a failed lookup of a thread or its entry
+func conversationErrorResponse(w http.ResponseWriter, result *models.Response, err error) {
+	switch {
+	case errors.Is(err, errConversationNotFound):
+		result.ErrorResponseWithStatus(w, "Conversation not found", http.StatusNotFound)
+	case errors.Is(err, mongo.ErrNoDocuments):
+		result.ErrorResponseWithStatus(w, "Diary entry not found", http.StatusNotFound)
+	default:
+		result.ErrorResponse(w, "Failed to fetch conversation")
+	}
+}
+
+// conversationHistory is the thread as chat messages for the companion
+func conversationHistory(messages []models.ConversationMessage) []models.Message {
+	history := make([]models.Message, len(messages))
+	for i, msg := range messages {
+		history[i] = msg.Message
+	}
+	return history
+}
+
+func userConversationMessage(content string, at time.Time) models.ConversationMessage {
+	return models.ConversationMessage{Message: models.Message{Role: "user", Content: content}, CreatedAt: at}
+}
+
+func companionMessage(reply *services.CompanionReply) models.ConversationMessage {
+	return models.ConversationMessage{
+		Message:       models.Message{Role: "assistant", Content: reply.Content},
+		PromptVersion: reply.PromptVersion,
+		CreatedAt:     time.Now(),
+	}
+}
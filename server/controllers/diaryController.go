@@ -2,13 +2,16 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"personal-diary/config"
 	"personal-diary/models"
 	"personal-diary/services"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
@@ -42,18 +45,36 @@ func CreateDiary(w http.ResponseWriter, r *http.Request) {
 
 	entry.ID = primitive.NewObjectID().Hex()
 	entry.CreatedAt = time.Now()
+	entry.UpdatedAt = entry.CreatedAt
 	entry.Email = getEmailFromHeader(r)
+	entry.Version = 1
+	entry.Summary = nil
+	entry.Mood = nil
+	entry.Attachments = nil
+	entry.Tags = services.NormalizeTags(entry.Tags)
+	entry.SuggestedTags = nil
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	_, err := diaryCollection.InsertOne(ctx, entry)
+	seq, err := nextSyncSeq(ctx, entry.Email)
+	if err != nil {
+		result.ErrorResponse(w, "Failed to create diary entry")
+		return
+	}
+	defer finishSyncWrite(entry.Email, seq)
+	entry.SyncSeq = seq
+
+	_, err = diaryCollection.InsertOne(ctx, entry)
 	if err != nil {
 		result.ErrorResponse(w, "Failed to create diary entry")
 		// http.Error(w, "Failed to create diary entry", http.StatusInternalServerError)
 		return
 	}
+	publishEntryEvent(entry.Email, models.EventEntryCreated, entry.ID, &entry)
+	onEntrySaved(entry)
 
+	result.SetData(entry)
 	result.SuccessResponse(w, "Diary entry created successfully")
 	// json.NewEncoder(w).Encode(entry)
 
@@ -65,7 +86,7 @@ func GetAllDiaries(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	cursor, err := diaryCollection.Find(ctx, bson.M{"email": email}, options.Find().SetSort(bson.D{{"createdAt", -1}}))
+	cursor, err := diaryCollection.Find(ctx, bson.M{"email": email}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
 	if err != nil {
 		http.Error(w, "Failed to fetch diary entries", http.StatusInternalServerError)
 		return
@@ -88,6 +109,29 @@ func GetAllDiaries(w http.ResponseWriter, r *http.Request) {
 	// json.NewEncoder(w).Encode(entries)
 }
 
+// GetDiary returns a single entry of the user
+func GetDiary(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	result := models.NewResponse()
+	email := getEmailFromHeader(r)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var entry models.DiaryEntry
+	err := diaryCollection.FindOne(ctx, bson.M{"_id": id, "email": email}).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		result.ErrorResponse(w, "Diary entry not found")
+		return
+	} else if err != nil {
+		result.ErrorResponse(w, "Failed to fetch diary entry")
+		return
+	}
+
+	result.SetData(entry)
+	result.SuccessResponse(w, "Diary entry fetched successfully")
+}
+
 func UpdateDiary(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
 	var updateData models.DiaryEntry
@@ -109,20 +153,42 @@ func UpdateDiary(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	filter := bson.M{"_id": id, "email": getEmailFromHeader(r)}
+	email := getEmailFromHeader(r)
+	seq, err := nextSyncSeq(ctx, email)
+	if err != nil {
+		result.ErrorResponse(w, "Failed to update diary entry")
+		return
+	}
+	defer finishSyncWrite(email, seq)
+
+	filter := bson.M{"_id": id, "email": email}
 	update := bson.M{
 		"$set": bson.M{
-			"title":   updateData.Title,
-			"content": updateData.Content,
+			"title":     updateData.Title,
+			"content":   updateData.Content,
+			"updatedAt": time.Now(),
+			"syncSeq":   seq,
 		},
+		"$inc": bson.M{"version": 1},
+	}
+	if updateData.Tags != nil {
+		update["$set"].(bson.M)["tags"] = services.NormalizeTags(updateData.Tags)
 	}
 
-	_, err := diaryCollection.UpdateOne(ctx, filter, update)
-	if err != nil {
+	var updated models.DiaryEntry
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = diaryCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		result.ErrorResponse(w, "Diary entry not found")
+		return
+	} else if err != nil {
 		result.ErrorResponse(w, "Failed to update diary entry")
 		// http.Error(w, "Failed to update diary entry", http.StatusInternalServerError)
 		return
 	}
+	invalidateStaleSummary(ctx, &updated)
+	publishEntryEvent(email, models.EventEntryUpdated, updated.ID, &updated)
+	onEntrySaved(updated)
 
 	// json.NewEncoder(w).Encode(entry)
 	result.SuccessResponse(w, "Diary entry updated successfully")
@@ -142,8 +208,18 @@ func DeleteDiary(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	_, err := diaryCollection.DeleteOne(ctx, bson.M{"_id": id})
-	if err != nil {
+	// Only the caller's own entries can be deleted; other ids are reported
+	// as not found, so they cannot be told apart from missing ones
+	email := getEmailFromHeader(r)
+	err := deleteEntry(ctx, email, bson.M{"_id": id})
+	if err == mongo.ErrNoDocuments {
+		result.ErrorResponseWithStatus(w, "Diary entry not found", http.StatusNotFound)
+		return
+	} else if errors.Is(err, errEntryChanged) {
+		result.ErrorResponseWithStatus(w, "Diary entry was changed meanwhile, please try again", http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Printf("Failed to delete diary entry %s: %v", id, err)
 		result.ErrorResponse(w, "Failed to delete diary entry")
 		// http.Error(w, "Failed to delete diary entry", http.StatusInternalServerError)
 		return
@@ -153,6 +229,14 @@ func DeleteDiary(w http.ResponseWriter, r *http.Request) {
 	// json.NewEncoder(w).Encode(map[string]string{"message": "Deleted"})
 }
 
+// onEntrySaved starts the background processing that follows every
+// create or update of an entry
+func onEntrySaved(entry models.DiaryEntry) {
+	analyzeMoodAsync(entry)
+	suggestTagsAsync(entry)
+	indexEntryAsync(entry)
+}
+
 // RefineTextHandler handles text refinement requests
 func RefineTextHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -173,21 +257,33 @@ func RefineTextHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Validate
 	text := strings.TrimSpace(req.Text)
-	if len(text) == 0 {
-		result.ErrorResponse(w, "Text cannot be empty")
-		// http.Error(w, `{"status":"error","message":"Text cannot be empty"}`, http.StatusBadRequest)
+	if message := validateRefineText(text, services.MaxRefineChars); message != "" {
+		result.ErrorResponse(w, message)
+		return
+	}
+	if message := validateRefineMode(req.Mode); message != "" {
+		result.ErrorResponse(w, message)
+		return
+	}
+
+	email := getEmailFromHeader(r)
+	setup, err := refineSetupFor(r.Context(), email, req)
+	if err != nil {
+		presetErrorResponse(w, result, err)
 		return
 	}
-	if len(text) > 5000 {
-		result.ErrorResponse(w, "Text is too long. Maximum 5000 characters allowed.")
-		// http.Error(w, `{"status":"error","message":"Text is too long. Maximum 5000 characters allowed."}`, http.StatusBadRequest)
+
+	// Cached results are only served within the quota, like fresh ones
+	if err := usageMeter.CheckQuota(r.Context(), email); err != nil {
+		aiErrorResponse(w, result, err, "Failed to refine text")
 		return
 	}
 
 	// Process
-	refinedText, err := services.RefineTextWithChatGPT(text, req.Context, req.Tone)
+	refinedText, cached, err := services.RefineWithCache(r.Context(), setup.refiner, refineCache, email, llmProvider.Model(),
+		setup.promptVersion, text, setup.writingContext, req.Tone)
 	if err != nil {
-		result.ErrorResponse(w, fmt.Sprintf(`Failed to refine text,error:"%s"`, err.Error()))
+		aiErrorResponse(w, result, err, fmt.Sprintf(`Failed to refine text,error:"%s"`, err.Error()))
 		// http.Error(w, fmt.Sprintf(`{"status":"error","message":"Failed to refine text","error":"%s"}`, err.Error()), http.StatusInternalServerError)
 		return
 	}
@@ -201,6 +297,29 @@ func RefineTextHandler(w http.ResponseWriter, r *http.Request) {
 	// 	},
 	// })
 
-	result.SetData(models.RefineResponse{RefinedText: refinedText, Message: "Text refined successfully"})
+	result.SetData(refineResponse(req, setup, text, refinedText, cached))
 	result.SuccessResponse(w, "Text refined successfully")
 }
+
+// maxCheckChars bounds POST /diary/check, whose offsets come from one reply
+const maxCheckChars = 5000
+
+// validateRefineText returns an error message when text is empty or longer
+// than maxChars characters
+func validateRefineText(text string, maxChars int) string {
+	if len(text) == 0 {
+		return "Text cannot be empty"
+	}
+	if utf8.RuneCountInString(text) > maxChars {
+		return fmt.Sprintf("Text is too long. Maximum %d characters allowed.", maxChars)
+	}
+	return ""
+}
+
+// validateRefineMode returns an error message for an unknown refine mode
+func validateRefineMode(mode string) string {
+	if mode != "" && mode != models.RefineModeEdits {
+		return "Mode must be empty or \"edits\""
+	}
+	return ""
+}
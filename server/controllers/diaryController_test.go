@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"personal-diary/middleware"
+	"personal-diary/models"
+	"testing"
+)
+
+// TestRefineTextHandler refines with LLM_PROVIDER=fake, set by TestMain,
+// which tidies spacing, capitalisation and final punctuation
+func TestRefineTextHandler(t *testing.T) {
+	email := testUser(t)
+	handler := middleware.JwtVerify(http.HandlerFunc(RefineTextHandler))
+
+	refine := func(t *testing.T, req models.RefineRequest) (testResponse, models.RefineResponse) {
+		t.Helper()
+		httpReq := httptest.NewRequest("POST", "/diary/refine", encryptedBody(t, req))
+		authorize(t, httpReq, email)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httpReq)
+
+		resp := decodeResponse(t, rec)
+		var refined models.RefineResponse
+		if resp.Status == "success" {
+			if err := json.Unmarshal(resp.Data, &refined); err != nil {
+				t.Fatalf("unmarshal refine response: %v", err)
+			}
+		}
+		return resp, refined
+	}
+
+	tests := []struct {
+		name      string
+		req       models.RefineRequest
+		want      string
+		wantEdits bool
+		wantError string
+	}{
+		{name: "tidies text", req: models.RefineRequest{Text: "  walked   to the lake  "}, want: "Walked to the lake."},
+		{name: "keeps paragraphs", req: models.RefineRequest{Text: "first day\nsecond day!"}, want: "First day.\nSecond day!"},
+		{name: "lists edits", req: models.RefineRequest{Text: "met anna for  coffee", Mode: models.RefineModeEdits}, want: "Met anna for coffee.", wantEdits: true},
+		{name: "empty text", req: models.RefineRequest{Text: "   "}, wantError: "Text cannot be empty"},
+		{name: "unknown mode", req: models.RefineRequest{Text: "hello", Mode: "rewrite"}, wantError: `Mode must be empty or "edits"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, refined := refine(t, tt.req)
+			if tt.wantError != "" {
+				if resp.Status != "error" || resp.Message != tt.wantError {
+					t.Errorf("got %s %q, want error %q", resp.Status, resp.Message, tt.wantError)
+				}
+				return
+			}
+			if resp.Status != "success" {
+				t.Fatalf("status %q: %s", resp.Status, resp.Message)
+			}
+			if refined.RefinedText != tt.want {
+				t.Errorf("refined = %q, want %q", refined.RefinedText, tt.want)
+			}
+			if hasEdits := len(refined.Edits) > 0; hasEdits != tt.wantEdits {
+				t.Errorf("edits = %+v, want edits: %v", refined.Edits, tt.wantEdits)
+			}
+		})
+	}
+
+	t.Run("served from cache", func(t *testing.T) {
+		req := models.RefineRequest{Text: "the same text twice"}
+		if _, first := refine(t, req); first.Cached {
+			t.Fatal("first request was served from the cache")
+		}
+		if _, second := refine(t, req); !second.Cached || second.RefinedText != "The same text twice." {
+			t.Errorf("second request = %+v, want the cached refinement", second)
+		}
+	})
+}
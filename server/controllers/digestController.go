@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"personal-diary/config"
+	"personal-diary/models"
+	"personal-diary/services"
+	"personal-diary/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var digestSettingsCollection *mongo.Collection = config.GetCollection("digest_settings")
+
+var weekdays = map[string]bool{
+	"sunday": true, "monday": true, "tuesday": true, "wednesday": true,
+	"thursday": true, "friday": true, "saturday": true,
+}
+
+const defaultDigestDay = "sunday"
+
+const (
+	// digestRetryDelay is how long a failed digest waits before it is retried
+	digestRetryDelay = 6 * time.Hour
+	// maxDigestAttempts bounds the runs of a digest on its delivery day
+	maxDigestAttempts = 3
+)
+
+func GetDigestSettings(w http.ResponseWriter, r *http.Request) {
+	result := models.NewResponse()
+	email := getEmailFromHeader(r)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var settings models.DigestSettings
+	err := digestSettingsCollection.FindOne(ctx, bson.M{"_id": email}).Decode(&settings)
+	if err == mongo.ErrNoDocuments {
+		settings = models.DigestSettings{Email: email, DeliveryDay: defaultDigestDay}
+	} else if err != nil {
+		log.Printf("Database error: %v", err)
+		result.ErrorResponse(w, "Failed to fetch digest settings")
+		return
+	}
+
+	result.SetData(settings)
+	result.SuccessResponse(w, "Digest settings fetched successfully")
+}
+
+func UpdateDigestSettings(w http.ResponseWriter, r *http.Request) {
+	var req models.DigestSettingsRequest
+	payload := models.NewPayload()
+	result := models.NewResponse()
+	if err := payload.DecodePayload(r, &req); err != nil {
+		result.ErrorResponse(w, "Invalid request payload")
+		return
+	}
+
+	day := strings.ToLower(strings.TrimSpace(req.DeliveryDay))
+	if day == "" {
+		day = defaultDigestDay
+	}
+	if !weekdays[day] {
+		result.ErrorResponse(w, "Delivery day must be a day of the week")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	email := getEmailFromHeader(r)
+	update := bson.M{
+		"$set": bson.M{
+			"enabled":     req.Enabled,
+			"deliveryDay": day,
+			"updatedAt":   time.Now(),
+		},
+	}
+	opts := options.Update().SetUpsert(true)
+	if _, err := digestSettingsCollection.UpdateOne(ctx, bson.M{"_id": email}, update, opts); err != nil {
+		log.Printf("Error updating digest settings: %v", err)
+		result.ErrorResponse(w, "Failed to update digest settings")
+		return
+	}
+
+	result.SetData(models.DigestSettings{Email: email, Enabled: req.Enabled, DeliveryDay: day})
+	result.SuccessResponse(w, "Digest settings updated successfully")
+}
+
+// StartWeeklyDigestScheduler checks every hour for users whose delivery day
+// has come and sends them the digest of the past seven days.
+func StartWeeklyDigestScheduler() {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		runWeeklyDigests(time.Now())
+		for now := range ticker.C {
+			runWeeklyDigests(now)
+		}
+	}()
+	log.Printf("Weekly digest scheduler started (send hour %02d:00)", digestSendHour())
+}
+
+// digestSendHour is the local hour from which digests go out, DIGEST_SEND_HOUR (default 8)
+func digestSendHour() int {
+	if hour, err := strconv.Atoi(os.Getenv("DIGEST_SEND_HOUR")); err == nil && hour >= 0 && hour < 24 {
+		return hour
+	}
+	return 8
+}
+
+func runWeeklyDigests(now time.Time) {
+	if now.Hour() < digestSendHour() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// lastRunAt guards against sending twice on the same delivery day, and
+	// lastAttemptAt against retrying a failing digest every hour
+	dayAgo := now.Add(-24 * time.Hour)
+	filter := bson.M{
+		"enabled":     true,
+		"deliveryDay": strings.ToLower(now.Weekday().String()),
+		"$and": []bson.M{
+			{"$or": []bson.M{
+				{"lastRunAt": bson.M{"$exists": false}},
+				{"lastRunAt": bson.M{"$lt": dayAgo}},
+			}},
+			{"$or": []bson.M{
+				{"lastAttemptAt": bson.M{"$exists": false}},
+				{"lastAttemptAt": bson.M{"$lt": dayAgo}},
+				{"lastAttemptAt": bson.M{"$lte": now.Add(-digestRetryDelay)}, "failedAttempts": bson.M{"$lt": maxDigestAttempts}},
+			}},
+		},
+	}
+	cursor, err := digestSettingsCollection.Find(ctx, filter)
+	if err != nil {
+		log.Printf("Weekly digest: failed to load settings: %v", err)
+		return
+	}
+	var due []models.DigestSettings
+	if err := cursor.All(ctx, &due); err != nil {
+		log.Printf("Weekly digest: failed to decode settings: %v", err)
+		return
+	}
+
+	sender := services.NewEmailSender()
+	for _, settings := range due {
+		if err := sendWeeklyDigest(sender, settings.Email, now); err != nil {
+			log.Printf("Weekly digest for %s failed: %v", settings.Email, err)
+			recordDigestFailure(settings, now)
+		}
+	}
+}
+
+// recordDigestFailure notes a failed run so the digest is retried after
+// digestRetryDelay, at most maxDigestAttempts times on the delivery day
+func recordDigestFailure(settings models.DigestSettings, now time.Time) {
+	attempts := 1
+	if now.Sub(settings.LastAttemptAt) < 24*time.Hour {
+		attempts = settings.FailedAttempts + 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"lastAttemptAt": now, "failedAttempts": attempts}}
+	if _, err := digestSettingsCollection.UpdateOne(ctx, bson.M{"_id": settings.Email}, update); err != nil {
+		log.Printf("Weekly digest: failed to record the attempt for %s: %v", settings.Email, err)
+	}
+}
+
+func sendWeeklyDigest(sender *services.EmailSender, email string, now time.Time) error {
+	start := now.AddDate(0, 0, -7)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"email": email, "createdAt": bson.M{"$gte": start, "$lt": now}}
+	cursor, err := diaryCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		return err
+	}
+	var entries []models.DiaryEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return err
+	}
+
+	// Digests count towards the user's AI quota
+	genCtx, genCancel := context.WithTimeout(utils.WithUserEmail(context.Background(), email), 2*time.Minute)
+	defer genCancel()
+
+	update := bson.M{"lastRunAt": now}
+	digest, err := services.GenerateWeeklyDigest(genCtx, llmProvider, entries, start, now)
+	switch {
+	case errors.Is(err, services.ErrNoDigestEntries):
+		log.Printf("Weekly digest for %s skipped: no entries this week", email)
+	case err != nil:
+		return err
+	default:
+		if err := sender.SendWeeklyDigest(email, digest); err != nil {
+			return err
+		}
+		update["lastSentAt"] = now
+	}
+
+	// Generation can outlive the query timeout, so the bookkeeping gets its own context
+	updateCtx, updateCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer updateCancel()
+
+	_, err = digestSettingsCollection.UpdateOne(updateCtx, bson.M{"_id": email},
+		bson.M{"$set": update, "$unset": bson.M{"lastAttemptAt": "", "failedAttempts": ""}})
+	return err
+}
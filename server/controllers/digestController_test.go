@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"context"
+	"personal-diary/models"
+	"personal-diary/services"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRunWeeklyDigestsBacksOffAfterFailure(t *testing.T) {
+	email := testUser(t)
+	t.Setenv("DIGEST_SEND_HOUR", "8")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Date(2024, 3, 5, 8, 30, 0, 0, time.Local)
+	// An entry in this week and in the next, so both digests have one
+	for _, days := range []int{-1, 6} {
+		entry := models.DiaryEntry{ID: primitive.NewObjectID().Hex(), Email: email, Title: "Monday", Content: "A long day.", CreatedAt: start.AddDate(0, 0, days)}
+		if _, err := diaryCollection.InsertOne(ctx, entry); err != nil {
+			t.Fatalf("insert entry: %v", err)
+		}
+	}
+	settings := models.DigestSettings{Email: email, Enabled: true, DeliveryDay: strings.ToLower(start.Weekday().String())}
+	if _, err := digestSettingsCollection.InsertOne(ctx, settings); err != nil {
+		t.Fatalf("insert digest settings: %v", err)
+	}
+	t.Cleanup(func() { digestSettingsCollection.DeleteOne(context.Background(), bson.M{"_id": email}) })
+
+	chat := &failingChat{FakeProvider: services.NewFakeProvider(), err: services.ErrProviderUnavailable}
+	saved := llmProvider
+	llmProvider = chat
+	t.Cleanup(func() { llmProvider = saved })
+
+	// Hourly ticks through the delivery day
+	tests := []struct {
+		after time.Duration
+		calls int
+	}{
+		{0, 1},
+		{time.Hour, 1},
+		{digestRetryDelay - time.Minute, 1},
+		{digestRetryDelay, 2},
+		{digestRetryDelay + time.Hour, 2},
+		{2 * digestRetryDelay, 3},
+		{2*digestRetryDelay + time.Hour, 3},
+		{3 * digestRetryDelay, 3}, // out of attempts for the day
+	}
+	for _, tt := range tests {
+		runWeeklyDigests(start.Add(tt.after))
+		if chat.calls != tt.calls {
+			t.Fatalf("after %s: digest generated %d times, want %d", tt.after, chat.calls, tt.calls)
+		}
+	}
+
+	// A week later the digest is tried again
+	runWeeklyDigests(start.AddDate(0, 0, 7))
+	if chat.calls != 4 {
+		t.Errorf("next delivery day: digest generated %d times in all, want 4", chat.calls)
+	}
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"personal-diary/middleware"
+	"personal-diary/models"
+	"personal-diary/services"
+	"strconv"
+	"time"
+)
+
+// eventBroker delivers entry changes to the user's other devices
+var eventBroker = services.NewEventBroker(200, 15*time.Minute)
+
+const (
+	eventKeepAlive = 25 * time.Second
+	// eventsLinkTTL is how long a link to the stream can be opened; an open
+	// stream is not closed when it expires
+	eventsLinkTTL = time.Minute
+)
+
+// EventsLinkPurpose is the link token purpose that opens the event stream
+const EventsLinkPurpose = "events"
+
+// publishEntryEvent notifies the owner's open /events streams about a change
+func publishEntryEvent(email, eventType, entryID string, entry *models.DiaryEntry) {
+	eventBroker.Publish(email, models.DiaryEvent{
+		Type:    eventType,
+		EntryID: entryID,
+		Entry:   entry,
+	})
+}
+
+// CreateEventsLink returns a URL of the event stream that works without the
+// Authorization header, for EventSource. Clients get a new link to reconnect.
+func CreateEventsLink(w http.ResponseWriter, r *http.Request) {
+	result := models.NewResponse()
+
+	token, expires, err := middleware.NewLinkToken(getEmailFromHeader(r), EventsLinkPurpose, eventsLinkTTL)
+	if err != nil {
+		result.ErrorResponse(w, "Failed to create events link")
+		return
+	}
+
+	result.SetData(models.SignedLink{URL: "/events?token=" + url.QueryEscape(token), ExpiresAt: expires})
+	result.SuccessResponse(w, "Events link created successfully")
+}
+
+// StreamEvents streams the current user's entry events as Server-Sent Events.
+// Reconnecting clients send Last-Event-ID (or ?lastEventId=) to receive the
+// events they missed; a "resync" event is sent when that is no longer possible.
+func StreamEvents(w http.ResponseWriter, r *http.Request) {
+	email := getEmailFromHeader(r)
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	var lastID uint64
+	if lastEventID != "" {
+		parsed, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		lastID = parsed
+	}
+
+	stream, err := newSSEStream(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	events, replay, complete, unsubscribe := eventBroker.Subscribe(email, lastID)
+	defer unsubscribe()
+
+	stream.Retry(3000)
+	if !complete {
+		if err := stream.Send("", "resync", map[string]string{"reason": "missed events are no longer available"}); err != nil {
+			return
+		}
+	}
+	for _, event := range replay {
+		if err := stream.Send(strconv.FormatUint(event.ID, 10), event.Type, event); err != nil {
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				// Dropped for falling behind; the client reconnects and replays
+				log.Printf("Event stream for %s closed: client too slow", email)
+				return
+			}
+			if err := stream.Send(strconv.FormatUint(event.ID, 10), event.Type, event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if err := stream.Ping(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -6,6 +6,7 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
@@ -13,6 +14,11 @@ import (
 
 	"personal-diary/models"
 	"personal-diary/services"
+	"personal-diary/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type BackgroundImageController struct {
@@ -20,15 +26,29 @@ type BackgroundImageController struct {
 }
 
 func NewBackgroundImageController(imageService *services.ImageGenerationService) *BackgroundImageController {
-	return &BackgroundImageController{
+	// Images count towards the user's AI quota, except those rendered offline
+	imageService.Provider = meterImageProvider(imageService.Provider)
+	if imageService.Fallback != nil {
+		imageService.Fallback = meterImageProvider(imageService.Fallback)
+	}
+	c := &BackgroundImageController{
 		ImageService: imageService,
 	}
+	registerJobKind(models.JobBackground, jobKind{validate: validateBackgroundJob, run: c.runBackgroundJob})
+	return c
+}
+
+func meterImageProvider(provider services.ImageProvider) services.ImageProvider {
+	if _, offline := provider.(*services.ProceduralImageProvider); offline {
+		return provider
+	}
+	return services.NewMeteredImageProvider(provider, usageMeter)
 }
 
 func (c *BackgroundImageController) GenerateBackground(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Received background generation request")
 
-	ctx := context.Background()
+	ctx := r.Context()
 
 	payload := models.NewPayload()
 	result := models.NewResponse()
@@ -52,47 +72,170 @@ func (c *BackgroundImageController) GenerateBackground(w http.ResponseWriter, r
 		req.Title = "Diary Entry"
 	}
 
-	// Generate image prompt
-	log.Printf("Generating image prompt...")
-	prompt, err := c.ImageService.GenerateImagePrompt(ctx, req.Content, req.Title)
+	data, err := c.generateBackground(ctx, req, func(int) {})
 	if err != nil {
-		log.Printf("Error generating prompt: %v", err)
-		c.sendErrorResponse(w, "Failed to generate image prompt", http.StatusInternalServerError)
+		message := "Failed to generate background image"
+		var stageErr *backgroundStageError
+		if errors.As(err, &stageErr) {
+			message = stageErr.message
+		}
+		c.sendAIErrorResponse(w, err, message)
 		return
 	}
 
-	log.Printf("Generated prompt: %s", prompt)
+	response := models.BackgroundImageResponse{
+		Status: "success",
+		Data:   data,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+
+	log.Printf("Successfully sent response with both paths")
+}
+
+// backgroundStageError tells which step of generating a background failed
+type backgroundStageError struct {
+	message string
+	err     error
+}
+
+func (e *backgroundStageError) Error() string { return e.message + ": " + e.err.Error() }
+func (e *backgroundStageError) Unwrap() error { return e.err }
+
+// generateBackground writes an image prompt for the entry and generates the
+// image, reporting progress in percent. When no prompt can be written, over
+// the AI quota or with the chat model unconfigured or unavailable, the image
+// is rendered offline without one, if the renderer is configured.
+func (c *BackgroundImageController) generateBackground(ctx context.Context, req models.BackgroundImageRequest, progress func(int)) (*models.BackgroundImageData, error) {
+	// Generate image prompt
+	log.Printf("Generating image prompt...")
+	var redactedPrompt, promptVersion string
+	promptCall := services.UsageCall{
+		Kind:     models.AIUsageChat,
+		Provider: chatModel.Name(),
+		Model:    chatModel.Model(),
+	}
+	// The image provider must be sent the prompt as redacted, so redaction
+	// happens here rather than in llmProvider, which restores replies
+	redactor := services.RedactorFor(ctx, redactionStore)
+	err := usageMeter.Track(ctx, promptCall, func(ctx context.Context) error {
+		var err error
+		redactedPrompt, promptVersion, err = c.ImageService.GenerateImagePrompt(ctx, chatModel, redactor.Redact(req.Content), redactor.Redact(req.Title))
+		return err
+	})
+	services.RecordRedactions(ctx, redactionStore, redactor, promptCall.Provider, promptCall.Model)
+	prompt := redactor.Restore(redactedPrompt)
+	offline := false
+	if err != nil {
+		if !promptUnavailable(err) || !c.ImageService.CanRenderOffline() {
+			log.Printf("Error generating prompt: %v", err)
+			return nil, &backgroundStageError{message: "Failed to generate image prompt", err: err}
+		}
+		log.Printf("Rendering background offline: %v", err)
+		offline = true
+	} else {
+		log.Printf("Generated prompt: %s", redactedPrompt)
+	}
+	progress(50)
 
 	// Generate image with both paths
 	log.Printf("Generating image...")
-	imageResult, err := c.ImageService.GenerateImageWithPaths(ctx, prompt)
+	// The image provider is sent the prompt as redacted; the rest stays local
+	imageReq := services.ImageRequest{
+		Prompt:   redactedPrompt,
+		Text:     req.Title + "\n" + req.Content,
+		LoadMood: func() *models.MoodAnalysis { return backgroundMood(ctx, req) },
+		Seed:     req.Seed,
+	}
+	if imageReq.Seed == 0 {
+		imageReq.Seed = services.ImageSeed(req.Title, req.Content)
+	}
+	var imageResult *services.ImageResult
+	if offline {
+		imageResult, err = c.ImageService.GenerateOfflineImage(ctx, imageReq)
+	} else {
+		imageResult, err = c.ImageService.GenerateImageWithPaths(ctx, imageReq)
+	}
 	if err != nil {
 		log.Printf("Error generating image: %v", err)
-		c.sendErrorResponse(w, "Failed to generate background image", http.StatusInternalServerError)
-		return
+		return nil, &backgroundStageError{message: "Failed to generate background image", err: err}
 	}
 
 	log.Printf("Generated image file path: %s", imageResult.FilePath)
 	log.Printf("Generated image URL: %s", imageResult.URL)
 
-	response := models.BackgroundImageResponse{
-		Status: "success",
-		Data: &models.BackgroundImageData{
-			ImageURL:    imageResult.URL,      // Web-accessible URL
-			ImagePath:   imageResult.FilePath, // Full file system path
-			Prompt:      prompt,
-			GeneratedAt: time.Now().Format(time.RFC3339),
-		},
-	}
+	return &models.BackgroundImageData{
+		ImageURL:      imageResult.URL,      // Web-accessible URL
+		ImagePath:     imageResult.FilePath, // Full file system path
+		Prompt:        prompt,
+		PromptVersion: promptVersion,
+		ImageModel:    imageResult.Model,
+		Seed:          imageReq.Seed,
+		GeneratedAt:   time.Now().Format(time.RFC3339),
+	}, nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+// promptUnavailable reports whether err means the chat model cannot write
+// image prompts for now, rather than that this request failed
+func promptUnavailable(err error) bool {
+	return errors.Is(err, services.ErrQuotaExceeded) ||
+		errors.Is(err, services.ErrLLMNotConfigured) ||
+		errors.Is(err, services.ErrProviderUnavailable)
+}
 
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Error encoding response: %v", err)
+// backgroundMood is the mood of the request's entry, as analysed when it
+// was saved, or else read from its text offline. Only the procedural
+// renderer asks for it.
+func backgroundMood(ctx context.Context, req models.BackgroundImageRequest) *models.MoodAnalysis {
+	if req.EntryID != "" {
+		findCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		var entry models.DiaryEntry
+		filter := bson.M{"_id": req.EntryID, "email": utils.UserEmailFromContext(ctx)}
+		err := diaryCollection.FindOne(findCtx, filter, options.FindOne().SetProjection(bson.M{"mood": 1})).Decode(&entry)
+		if err == nil && entry.Mood != nil {
+			return entry.Mood
+		}
+		if err != nil && err != mongo.ErrNoDocuments {
+			log.Printf("Failed to load mood of entry %s: %v", req.EntryID, err)
+		}
 	}
+	return services.AnalyzeMoodLexicon(req.Title + "\n" + req.Content)
+}
 
-	log.Printf("Successfully sent response with both paths")
+// validateBackgroundJob checks a background job's input like
+// GenerateBackground checks its request
+func validateBackgroundJob(input json.RawMessage) (json.RawMessage, string) {
+	var req models.BackgroundImageRequest
+	if err := json.Unmarshal(input, &req); err != nil {
+		return nil, "Invalid background request"
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		return nil, "Content is required"
+	}
+	if req.Title == "" {
+		req.Title = "Diary Entry"
+	}
+	encoded, err := json.Marshal(req)
+	if err != nil {
+		return nil, "Invalid background request"
+	}
+	return encoded, ""
+}
+
+// runBackgroundJob generates the background of a background job
+func (c *BackgroundImageController) runBackgroundJob(ctx context.Context, job models.Job, progress func(int)) (any, error) {
+	var req models.BackgroundImageRequest
+	if err := json.Unmarshal(job.Input, &req); err != nil {
+		return nil, services.PermanentJobError(err)
+	}
+	return c.generateBackground(ctx, req, progress)
 }
 
 func (c *BackgroundImageController) sendErrorResponse(w http.ResponseWriter, message string, statusCode int) {
@@ -107,3 +250,18 @@ func (c *BackgroundImageController) sendErrorResponse(w http.ResponseWriter, mes
 	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(response)
 }
+
+// sendAIErrorResponse answers an exceeded AI quota with 429, an unavailable
+// provider with 503 and other failures with 500
+func (c *BackgroundImageController) sendAIErrorResponse(w http.ResponseWriter, err error, message string) {
+	var quotaErr *services.QuotaExceededError
+	if errors.As(err, &quotaErr) {
+		c.sendErrorResponse(w, quotaExceededMessage(quotaErr), http.StatusTooManyRequests)
+		return
+	}
+	if errors.Is(err, services.ErrProviderUnavailable) {
+		c.sendErrorResponse(w, providerUnavailableMessage, http.StatusServiceUnavailable)
+		return
+	}
+	c.sendErrorResponse(w, message, http.StatusInternalServerError)
+}
@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"context"
+	"personal-diary/models"
+	"personal-diary/services"
+	"testing"
+)
+
+func TestGenerateBackgroundRendersOfflineWithoutPrompt(t *testing.T) {
+	requireDB(t)
+	t.Setenv("OPENAI_API_KEY", "")
+	t.Setenv("LLM_PROVIDER", "openai")
+	unconfigured, err := services.NewLLMProviderFromEnv()
+	if err != nil {
+		t.Fatalf("NewLLMProviderFromEnv: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		chat services.LLMProvider
+	}{
+		{"chat model not configured", unconfigured},
+		{"chat model unavailable", &failingChat{FakeProvider: services.NewFakeProvider(), err: services.ErrProviderUnavailable}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := chatModel
+			chatModel = tt.chat
+			t.Cleanup(func() { chatModel = saved })
+
+			c := &BackgroundImageController{ImageService: &services.ImageGenerationService{
+				UploadDir: t.TempDir(),
+				Provider:  services.NewProceduralImageProvider(),
+			}}
+			req := models.BackgroundImageRequest{Title: "Lake", Content: "A calm swim at dawn.", Seed: 3}
+			data, err := c.generateBackground(context.Background(), req, func(int) {})
+			if err != nil {
+				t.Fatalf("generateBackground: %v", err)
+			}
+			if data.ImageModel != "procedural" || data.Prompt != "" || data.Seed != 3 {
+				t.Errorf("got model %q, prompt %q, seed %d", data.ImageModel, data.Prompt, data.Seed)
+			}
+		})
+	}
+}
+
+func TestGenerateBackgroundNeedsOfflineRenderer(t *testing.T) {
+	requireDB(t)
+	saved := chatModel
+	chatModel = &failingChat{FakeProvider: services.NewFakeProvider(), err: services.ErrProviderUnavailable}
+	t.Cleanup(func() { chatModel = saved })
+
+	c := &BackgroundImageController{ImageService: &services.ImageGenerationService{UploadDir: t.TempDir()}}
+	req := models.BackgroundImageRequest{Title: "Lake", Content: "A calm swim at dawn."}
+	if _, err := c.generateBackground(context.Background(), req, func(int) {}); err == nil {
+		t.Error("generateBackground succeeded with no prompt and no offline renderer")
+	}
+}
@@ -0,0 +1,573 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"personal-diary/config"
+	"personal-diary/models"
+	"personal-diary/services"
+	"personal-diary/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var jobCollection *mongo.Collection = config.GetCollection("ai_jobs")
+
+const (
+	// jobLease is how long a worker holds a job without renewing its claim;
+	// jobs of a server that stopped are picked up again once it runs out
+	jobLease = time.Minute
+	// jobTimeout bounds one attempt of a job
+	jobTimeout = 5 * time.Minute
+	// jobPollInterval is how often idle workers look for due jobs
+	jobPollInterval = 2 * time.Second
+	jobMaxAttempts  = 3
+	// maxActiveJobs bounds the queued and running jobs of one user
+	maxActiveJobs = 10
+	// finishedJobTTL is how long finished jobs can still be polled
+	finishedJobTTL = 7 * 24 * time.Hour
+)
+
+var (
+	errJobCancelled   = errors.New("job cancelled")
+	errJobLeaseLost   = errors.New("job lease lost")
+	errJobInterrupted = errors.New("job was interrupted too many times")
+	errUnknownJobKind = errors.New("unknown job kind")
+)
+
+// jobKind validates and runs the jobs of one kind. validate checks a
+// submitted input and returns it as it is stored, or a message for the
+// user; run returns the job's result and reports progress in percent.
+type jobKind struct {
+	validate func(input json.RawMessage) (json.RawMessage, string)
+	run      func(ctx context.Context, job models.Job, progress func(int)) (any, error)
+}
+
+var jobKinds = map[string]jobKind{
+	models.JobRefine: {validate: validateRefineJob, run: runRefineJob},
+}
+
+var jobKindsMu sync.RWMutex
+
+// registerJobKind adds a kind whose runner is only known at startup
+func registerJobKind(kind string, handler jobKind) {
+	jobKindsMu.Lock()
+	defer jobKindsMu.Unlock()
+	jobKinds[kind] = handler
+}
+
+func lookupJobKind(kind string) (jobKind, bool) {
+	jobKindsMu.RLock()
+	defer jobKindsMu.RUnlock()
+	handler, ok := jobKinds[kind]
+	return handler, ok
+}
+
+// jobWorkerID tells this server's claims apart from other instances'
+var jobWorkerID = primitive.NewObjectID().Hex()
+
+// jobWakeup lets a new job start without waiting for the next poll
+var jobWakeup = make(chan struct{}, 1)
+
+// wakeJobWorker wakes one idle worker, if none is waking already
+func wakeJobWorker() {
+	select {
+	case jobWakeup <- struct{}{}:
+	default:
+	}
+}
+
+// runningJobs holds the cancel functions of the jobs running here, so a
+// cancellation on this server takes effect at once
+var runningJobs = struct {
+	sync.Mutex
+	cancels map[string]context.CancelCauseFunc
+}{cancels: make(map[string]context.CancelCauseFunc)}
+
+// StartJobWorkers starts JOB_WORKERS (default 2) workers processing queued
+// AI jobs. Jobs left running by a previous run are resumed once their lease
+// runs out.
+func StartJobWorkers() {
+	ensureJobIndexes()
+	workers := 2
+	if parsed, err := strconv.Atoi(os.Getenv("JOB_WORKERS")); err == nil && parsed > 0 {
+		workers = parsed
+	}
+	for i := 0; i < workers; i++ {
+		go jobWorker()
+	}
+	log.Printf("AI job workers started (%d workers)", workers)
+}
+
+func ensureJobIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := jobCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "runAfter", Value: 1}}},
+		{Keys: bson.D{{Key: "email", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "finishedAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(finishedJobTTL.Seconds()))},
+	})
+	if err != nil {
+		log.Printf("Failed to create job indexes: %v", err)
+	}
+}
+
+func jobWorker() {
+	for {
+		job, err := claimJob()
+		if err != nil {
+			log.Printf("Failed to claim job: %v", err)
+		}
+		if job == nil {
+			select {
+			case <-jobWakeup:
+			case <-time.After(jobPollInterval):
+			}
+			continue
+		}
+		// Pass the wakeup on while jobs are found, so jobs queued together
+		// start together on the idle workers
+		wakeJobWorker()
+		runJob(*job)
+	}
+}
+
+// claimJob takes the oldest due job, or a running job whose worker stopped
+// renewing its lease. It returns nil when there is none.
+func claimJob() (*models.Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{"$or": []bson.M{
+		{"status": models.JobQueued, "runAfter": bson.M{"$lte": now}},
+		{"status": models.JobRunning, "leaseUntil": bson.M{"$lt": now}},
+	}}
+	update := bson.M{
+		"$set": bson.M{
+			"status":     models.JobRunning,
+			"worker":     jobWorkerID,
+			"leaseUntil": now.Add(jobLease),
+			"startedAt":  now,
+			"updatedAt":  now,
+		},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "runAfter", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var job models.Job
+	err := jobCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// runJob runs one attempt of a claimed job and records its outcome
+func runJob(job models.Job) {
+	handler, ok := lookupJobKind(job.Kind)
+	switch {
+	case job.CancelRequested:
+		finishJob(job, nil, errJobCancelled)
+		return
+	case !ok:
+		finishJob(job, nil, services.PermanentJobError(fmt.Errorf("%w %s", errUnknownJobKind, job.Kind)))
+		return
+	case job.Attempts > job.MaxAttempts:
+		// Each restart while the job ran used up an attempt
+		finishJob(job, nil, services.PermanentJobError(errJobInterrupted))
+		return
+	}
+
+	timeoutCtx, cancelTimeout := context.WithTimeout(utils.WithUserEmail(context.Background(), job.Email), jobTimeout)
+	defer cancelTimeout()
+	ctx, cancel := context.WithCancelCause(timeoutCtx)
+	defer cancel(nil)
+
+	runningJobs.Lock()
+	runningJobs.cancels[job.ID] = cancel
+	runningJobs.Unlock()
+	defer func() {
+		runningJobs.Lock()
+		delete(runningJobs.cancels, job.ID)
+		runningJobs.Unlock()
+	}()
+
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		renewJobLease(ctx, job, cancel)
+	}()
+
+	reported := 0
+	progress := func(percent int) {
+		// Small steps are not worth a write
+		if percent < reported+5 || percent > 100 {
+			return
+		}
+		reported = percent
+		if _, err := jobCollection.UpdateOne(ctx, jobClaim(job), bson.M{"$set": bson.M{"progress": percent, "updatedAt": time.Now()}}); err != nil {
+			log.Printf("Failed to record progress of job %s: %v", job.ID, err)
+		}
+	}
+
+	result, err := handler.run(ctx, job, progress)
+	if cause := context.Cause(ctx); errors.Is(cause, errJobCancelled) || errors.Is(cause, errJobLeaseLost) {
+		err = cause
+	}
+	cancel(nil)
+	<-heartbeatDone
+	finishJob(job, result, err)
+}
+
+// renewJobLease extends the job's lease while it runs. It cancels the job
+// when the user asked to cancel it on another server, or when the lease was
+// lost to another worker.
+func renewJobLease(ctx context.Context, job models.Job, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(jobLease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var current models.Job
+		update := bson.M{"$set": bson.M{"leaseUntil": time.Now().Add(jobLease)}}
+		err := jobCollection.FindOneAndUpdate(ctx, jobClaim(job), update).Decode(&current)
+		switch {
+		case err == mongo.ErrNoDocuments:
+			cancel(errJobLeaseLost)
+			return
+		case err != nil:
+			if ctx.Err() == nil {
+				log.Printf("Failed to renew lease of job %s: %v", job.ID, err)
+			}
+		case current.CancelRequested:
+			cancel(errJobCancelled)
+			return
+		}
+	}
+}
+
+// jobClaim matches the job only while this attempt still holds it
+func jobClaim(job models.Job) bson.M {
+	return bson.M{"_id": job.ID, "status": models.JobRunning, "worker": jobWorkerID, "attempts": job.Attempts}
+}
+
+// finishJob records the outcome of an attempt: the result, a cancellation,
+// another try after a backoff or the final failure
+func finishJob(job models.Job, result any, runErr error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if errors.Is(runErr, errJobLeaseLost) {
+		log.Printf("Job %s was taken over by another worker", job.ID)
+		return
+	}
+
+	now := time.Now()
+	set := bson.M{"updatedAt": now}
+	switch {
+	case errors.Is(runErr, errJobCancelled):
+		set["status"] = models.JobCancelled
+		set["finishedAt"] = now
+	case runErr == nil:
+		encoded, err := json.Marshal(result)
+		if err != nil {
+			runErr = services.PermanentJobError(err)
+			set["status"] = models.JobFailed
+			set["error"] = "Failed to store the job result"
+			set["finishedAt"] = now
+			break
+		}
+		set["status"] = models.JobSucceeded
+		set["progress"] = 100
+		set["result"] = json.RawMessage(encoded)
+		set["error"] = ""
+		set["finishedAt"] = now
+	case services.JobRetryable(runErr) && job.Attempts < job.MaxAttempts:
+		set["status"] = models.JobQueued
+		set["progress"] = 0
+		set["error"] = jobErrorMessage(runErr)
+		set["runAfter"] = now.Add(services.JobRetryDelay(job.Attempts))
+	default:
+		set["status"] = models.JobFailed
+		set["error"] = jobErrorMessage(runErr)
+		set["finishedAt"] = now
+	}
+	if runErr != nil && !errors.Is(runErr, errJobCancelled) {
+		log.Printf("Job %s (%s) attempt %d failed: %v", job.ID, job.Kind, job.Attempts, runErr)
+	}
+
+	update := bson.M{"$set": set, "$unset": bson.M{"worker": "", "leaseUntil": ""}}
+	if _, err := jobCollection.UpdateOne(ctx, jobClaim(job), update); err != nil {
+		log.Printf("Failed to record outcome of job %s: %v", job.ID, err)
+	}
+}
+
+// jobErrorMessage is the error shown to the user polling a failed job.
+// Errors are stored with the job, so only known ones are described; the
+// others, which may carry provider replies, are only logged.
+func jobErrorMessage(err error) string {
+	var quotaErr *services.QuotaExceededError
+	switch {
+	case errors.As(err, &quotaErr):
+		return quotaExceededMessage(quotaErr)
+	case errors.Is(err, services.ErrProviderUnavailable):
+		return providerUnavailableMessage
+	case errors.Is(err, services.ErrLLMNotConfigured):
+		return "The AI service is not configured."
+	case errors.Is(err, context.DeadlineExceeded):
+		return "The job took too long and was stopped."
+	case errors.Is(err, errJobInterrupted):
+		return "The job was interrupted too many times."
+	case errors.Is(err, errUnknownJobKind):
+		return "Unknown job kind"
+	case errors.Is(err, errPresetNotFound):
+		return "Refine preset not found"
+	default:
+		return "The job failed."
+	}
+}
+
+// CreateJob queues an AI job and returns it at once; its progress and
+// result are polled with GetJob
+func CreateJob(w http.ResponseWriter, r *http.Request) {
+	payload := models.NewPayload()
+	result := models.NewResponse()
+	var req models.JobRequest
+	if err := payload.DecodePayload(r, &req); err != nil {
+		result.ErrorResponse(w, "Invalid request payload")
+		return
+	}
+
+	handler, ok := lookupJobKind(req.Kind)
+	if !ok {
+		result.ErrorResponse(w, "Unknown job kind")
+		return
+	}
+	input, message := handler.validate(req.Input)
+	if message != "" {
+		result.ErrorResponse(w, message)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	email := getEmailFromHeader(r)
+	if err := usageMeter.CheckQuota(ctx, email); err != nil {
+		aiErrorResponse(w, result, err, "Failed to create job")
+		return
+	}
+	active, err := jobCollection.CountDocuments(ctx, bson.M{
+		"email":  email,
+		"status": bson.M{"$in": []string{models.JobQueued, models.JobRunning}},
+	})
+	if err != nil {
+		result.ErrorResponse(w, "Failed to create job")
+		return
+	}
+	if active >= maxActiveJobs {
+		result.ErrorResponseWithStatus(w, "Too many jobs in progress, wait for some to finish", http.StatusTooManyRequests)
+		return
+	}
+
+	now := time.Now()
+	job := models.Job{
+		ID:          primitive.NewObjectID().Hex(),
+		Email:       email,
+		Kind:        req.Kind,
+		Status:      models.JobQueued,
+		Input:       input,
+		MaxAttempts: jobMaxAttempts,
+		RunAfter:    now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if _, err := jobCollection.InsertOne(ctx, job); err != nil {
+		result.ErrorResponse(w, "Failed to create job")
+		return
+	}
+	wakeJobWorker()
+
+	result.SetData(job)
+	result.SuccessResponse(w, "Job queued successfully")
+}
+
+// GetJobs lists the user's 20 most recent jobs, optionally with one status
+func GetJobs(w http.ResponseWriter, r *http.Request) {
+	result := models.NewResponse()
+	filter := bson.M{"email": getEmailFromHeader(r)}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter["status"] = status
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(20)
+	cursor, err := jobCollection.Find(ctx, filter, opts)
+	if err != nil {
+		result.ErrorResponse(w, "Failed to fetch jobs")
+		return
+	}
+	jobs := []models.Job{}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		result.ErrorResponse(w, "Failed to fetch jobs")
+		return
+	}
+
+	result.SetData(jobs)
+	result.SuccessResponse(w, "Jobs fetched successfully")
+}
+
+// GetJob reports a job's status, progress and, once it succeeded, result
+func GetJob(w http.ResponseWriter, r *http.Request) {
+	result := models.NewResponse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var job models.Job
+	err := jobCollection.FindOne(ctx, bson.M{"_id": mux.Vars(r)["id"], "email": getEmailFromHeader(r)}).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		result.ErrorResponseWithStatus(w, "Job not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		result.ErrorResponse(w, "Failed to fetch job")
+		return
+	}
+
+	result.SetData(job)
+	result.SuccessResponse(w, "Job fetched successfully")
+}
+
+// CancelJob cancels a queued job at once and asks the worker of a running
+// job to stop it
+func CancelJob(w http.ResponseWriter, r *http.Request) {
+	result := models.NewResponse()
+	id := mux.Vars(r)["id"]
+	email := getEmailFromHeader(r)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	queued := bson.M{"$set": bson.M{"status": models.JobCancelled, "updatedAt": now, "finishedAt": now}}
+	res, err := jobCollection.UpdateOne(ctx, bson.M{"_id": id, "email": email, "status": models.JobQueued}, queued)
+	if err == nil && res.MatchedCount == 0 {
+		running := bson.M{"$set": bson.M{"cancelRequested": true, "updatedAt": now}}
+		res, err = jobCollection.UpdateOne(ctx, bson.M{"_id": id, "email": email, "status": models.JobRunning}, running)
+	}
+	if err != nil {
+		result.ErrorResponse(w, "Failed to cancel job")
+		return
+	}
+
+	runningJobs.Lock()
+	if cancelRun, ok := runningJobs.cancels[id]; ok && res.MatchedCount > 0 {
+		cancelRun(errJobCancelled)
+	}
+	runningJobs.Unlock()
+
+	var job models.Job
+	err = jobCollection.FindOne(ctx, bson.M{"_id": id, "email": email}).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		result.ErrorResponseWithStatus(w, "Job not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		result.ErrorResponse(w, "Failed to fetch job")
+		return
+	}
+	if res.MatchedCount == 0 {
+		result.ErrorResponseWithStatus(w, "Job has already finished", http.StatusConflict)
+		return
+	}
+
+	result.SetData(job)
+	result.SuccessResponse(w, "Job cancellation requested")
+}
+
+// validateRefineJob checks a refine job's input like RefineTextHandler
+// checks its request
+func validateRefineJob(input json.RawMessage) (json.RawMessage, string) {
+	var req models.RefineRequest
+	if err := json.Unmarshal(input, &req); err != nil {
+		return nil, "Invalid refine request"
+	}
+	req.Text = strings.TrimSpace(req.Text)
+	if message := validateRefineText(req.Text, services.MaxRefineChars); message != "" {
+		return nil, message
+	}
+	if message := validateRefineMode(req.Mode); message != "" {
+		return nil, message
+	}
+	encoded, err := json.Marshal(req)
+	if err != nil {
+		return nil, "Invalid refine request"
+	}
+	return encoded, ""
+}
+
+// runRefineJob refines text like RefineStreamHandler, using the refine
+// cache, and reports progress as the refined text is written
+func runRefineJob(ctx context.Context, job models.Job, progress func(int)) (any, error) {
+	var req models.RefineRequest
+	if err := json.Unmarshal(job.Input, &req); err != nil {
+		return nil, services.PermanentJobError(err)
+	}
+
+	setup, err := refineSetupFor(ctx, job.Email, req)
+	if errors.Is(err, errPresetNotFound) {
+		return nil, services.PermanentJobError(err)
+	} else if err != nil {
+		return nil, err
+	}
+
+	cacheKey := services.RefineCacheKey(job.Email, req.Text, setup.writingContext, req.Tone, llmProvider.Model(), setup.promptVersion)
+	cachedText, cached, err := refineCache.Get(ctx, cacheKey)
+	if err != nil {
+		log.Printf("Refine cache lookup failed: %v", err)
+	}
+	if cached {
+		return refineResponse(req, setup, req.Text, cachedText, true), nil
+	}
+
+	total, written := utf8.RuneCountInString(req.Text), 0
+	refinedText, err := setup.streamer.RefineStream(ctx, req.Text, setup.writingContext, req.Tone, func(delta string) error {
+		written += utf8.RuneCountInString(delta)
+		// The refined text is about as long as the original
+		progress(min(95, written*100/total))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := refineCache.Set(ctx, cacheKey, refinedText); err != nil {
+		log.Printf("Refine cache store failed: %v", err)
+	}
+	return refineResponse(req, setup, req.Text, refinedText, false), nil
+}
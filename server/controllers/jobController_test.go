@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"personal-diary/services"
+	"testing"
+	"time"
+)
+
+func TestJobErrorMessage(t *testing.T) {
+	quotaErr := &services.QuotaExceededError{Period: "daily", Limit: 100, Used: 120, ResetsAt: time.Date(2024, 3, 6, 0, 0, 0, 0, time.UTC)}
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"quota", fmt.Errorf("refine: %w", quotaErr), quotaExceededMessage(quotaErr)},
+		{"provider down", fmt.Errorf("send: %w", services.ErrProviderUnavailable), providerUnavailableMessage},
+		{"not configured", fmt.Errorf("OpenAI API key not configured: %w", services.ErrLLMNotConfigured), "The AI service is not configured."},
+		{"timeout", fmt.Errorf("send request error: %w", context.DeadlineExceeded), "The job took too long and was stopped."},
+		{"interrupted", services.PermanentJobError(errJobInterrupted), "The job was interrupted too many times."},
+		{"unknown kind", services.PermanentJobError(fmt.Errorf("%w %s", errUnknownJobKind, "paint")), "Unknown job kind"},
+		{"preset", services.PermanentJobError(errPresetNotFound), "Refine preset not found"},
+		{"provider reply is not shown", &services.LLMStatusError{StatusCode: 500, Body: `{"error": "internal details"}`}, "The job failed."},
+		{"other", errors.New("decode error at offset 12"), "The job failed."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jobErrorMessage(tt.err); got != tt.want {
+				t.Errorf("jobErrorMessage = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"personal-diary/config"
+	"personal-diary/models"
+	"personal-diary/services"
+	"personal-diary/utils"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Handler tests run against the fake AI providers and a MongoDB database of
+// their own, named by MONGODB_DATABASE. Without one they are skipped.
+func TestMain(m *testing.M) {
+	os.Setenv("LLM_PROVIDER", "fake")
+	os.Setenv("EMBEDDING_PROVIDER", "")
+	os.Setenv("TRANSCRIPTION_PROVIDER", "")
+	configureAIProviders()
+
+	os.Exit(m.Run())
+}
+
+var (
+	dbCheck      sync.Once
+	dbSkipReason string // why handler tests cannot run, "" when they can
+)
+
+// requireDB skips the test unless a test database is reachable
+func requireDB(t *testing.T) {
+	t.Helper()
+	dbCheck.Do(func() {
+		if os.Getenv("MONGODB_DATABASE") == "" || config.DatabaseName() == "diary_app" {
+			dbSkipReason = "set MONGODB_DATABASE to a test database to run handler tests"
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := config.PingDB(ctx); err != nil {
+			dbSkipReason = "MongoDB is not reachable: " + err.Error()
+		}
+	})
+	if dbSkipReason != "" {
+		t.Skip(dbSkipReason)
+	}
+}
+
+// testUser returns the email of a new user whose entries are removed when
+// the test ends
+func testUser(t *testing.T) string {
+	t.Helper()
+	requireDB(t)
+	email := "test-" + primitive.NewObjectID().Hex() + "@example.com"
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		diaryCollection.DeleteMany(ctx, bson.M{"email": email})
+		tombstoneCollection.DeleteMany(ctx, bson.M{"email": email})
+		syncCounterCollection.DeleteOne(ctx, bson.M{"_id": email})
+	})
+	return email
+}
+
+// authorize signs req in as email, like a client after login
+func authorize(t *testing.T, req *http.Request, email string) {
+	t.Helper()
+	token, err := utils.GenerateToken(email)
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+}
+
+// encryptedBody encodes v as a request payload
+func encryptedBody(t *testing.T, v any) *bytes.Reader {
+	t.Helper()
+	plain, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal payload: %v", err)
+	}
+	body := string(plain)
+	if models.IsEncrypted {
+		if body, err = utils.EncryptAES(body); err != nil {
+			t.Fatalf("encrypt payload: %v", err)
+		}
+	}
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("marshal payload: %v", err)
+	}
+	return bytes.NewReader(encoded)
+}
+
+type testResponse struct {
+	Status  string          `json:"status"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// decodeResponse reads a response written by models.Response
+func decodeResponse(t *testing.T, rec *httptest.ResponseRecorder) testResponse {
+	t.Helper()
+	body := rec.Body.String()
+	if models.IsEncrypted {
+		decrypted, err := utils.DecryptAES(body)
+		if err != nil {
+			t.Fatalf("decrypt response %q: %v", body, err)
+		}
+		body = decrypted
+	}
+	var resp testResponse
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("unmarshal response %q: %v", body, err)
+	}
+	return resp
+}
+
+// failingChat is a chat model whose calls fail with err
+type failingChat struct {
+	*services.FakeProvider
+	err   error
+	calls int
+}
+
+func (p *failingChat) Complete(ctx context.Context, req services.CompletionRequest) (string, error) {
+	p.calls++
+	return "", p.err
+}
@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"personal-diary/models"
+	"personal-diary/services"
+	"personal-diary/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// moodQueue analyses the moods of saved entries, four at a time. Entries
+// dropped while it is full get a mood when they are next saved.
+var moodQueue = newBackgroundQueue("mood", 4, 256)
+
+// analyzeMoodAsync analyses the entry's mood in the background and stores
+// the result, unless the content changed again in the meantime.
+func analyzeMoodAsync(entry models.DiaryEntry) {
+	if entry.Mood != nil && entry.Mood.ContentHash == services.ContentHash(entry.Content) {
+		return // already analysed for this content
+	}
+
+	moodQueue.Submit(func() {
+		ctx, cancel := context.WithTimeout(utils.WithUserEmail(context.Background(), entry.Email), time.Minute)
+		defer cancel()
+
+		mood := services.AnalyzeMood(ctx, llmProvider, entry.Content)
+
+		filter := bson.M{"_id": entry.ID, "email": entry.Email, "content": entry.Content}
+		if _, err := diaryCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"mood": mood}}); err != nil {
+			log.Printf("Failed to store mood of %s: %v", entry.ID, err)
+		}
+	})
+}
+
+// GetMoodTrends returns daily and weekly mood aggregates for charting.
+// Query parameters: from and to (YYYY-MM-DD, inclusive, default the last
+// 30 days) and tz (IANA time zone used to group days, default UTC).
+func GetMoodTrends(w http.ResponseWriter, r *http.Request) {
+	result := models.NewResponse()
+	query := r.URL.Query()
+
+	loc := time.UTC
+	if tz := query.Get("tz"); tz != "" {
+		parsed, err := time.LoadLocation(tz)
+		if err != nil {
+			result.ErrorResponse(w, "Invalid time zone")
+			return
+		}
+		loc = parsed
+	}
+
+	now := time.Now().In(loc)
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	if raw := query.Get("to"); raw != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", raw, loc)
+		if err != nil {
+			result.ErrorResponse(w, "To must be a date in YYYY-MM-DD format")
+			return
+		}
+		to = parsed
+	}
+	from := to.AddDate(0, 0, -29)
+	if raw := query.Get("from"); raw != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", raw, loc)
+		if err != nil {
+			result.ErrorResponse(w, "From must be a date in YYYY-MM-DD format")
+			return
+		}
+		from = parsed
+	}
+	end := to.AddDate(0, 0, 1)
+	if !end.After(from) {
+		result.ErrorResponse(w, "From must not be after To")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"email":     getEmailFromHeader(r),
+		"createdAt": bson.M{"$gte": from, "$lt": end},
+		"mood":      bson.M{"$exists": true},
+	}
+	opts := options.Find().SetProjection(bson.M{"createdAt": 1, "mood": 1})
+	cursor, err := diaryCollection.Find(ctx, filter, opts)
+	if err != nil {
+		result.ErrorResponse(w, "Failed to fetch diary entries")
+		return
+	}
+	var entries []models.DiaryEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		result.ErrorResponse(w, "Failed to decode diary entries")
+		return
+	}
+
+	daily, weekly := services.BuildMoodTrends(entries, loc)
+	result.SetData(models.MoodTrendsResponse{From: from, To: to, Daily: daily, Weekly: weekly})
+	result.SuccessResponse(w, "Mood trends fetched successfully")
+}
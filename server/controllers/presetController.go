@@ -0,0 +1,276 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"personal-diary/config"
+	"personal-diary/models"
+	"personal-diary/services"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var refinePresetCollection *mongo.Collection = config.GetCollection("refine_presets")
+
+const maxPresetsPerUser = 50
+
+var errPresetNotFound = errors.New("refine preset not found")
+
+// SeedRefinePresets stores the built-in writing contexts as system presets,
+// updating them when their definition changes
+func SeedRefinePresets() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var writes []mongo.WriteModel
+	for _, preset := range services.SystemRefinePresets() {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": preset.ID}).
+			SetUpdate(bson.M{
+				"$set": bson.M{
+					"name":         preset.Name,
+					"instructions": preset.Instructions,
+					"context":      preset.Context,
+					"temperature":  services.DefaultPresetTemperature,
+					"maxLength":    0,
+					"system":       true,
+					"updatedAt":    now,
+				},
+				"$setOnInsert": bson.M{"createdAt": now},
+			}).
+			SetUpsert(true))
+	}
+	if _, err := refinePresetCollection.BulkWrite(ctx, writes); err != nil {
+		log.Printf("Failed to seed refine presets: %v", err)
+	}
+}
+
+// findPreset returns a system preset or one of the user's own
+func findPreset(ctx context.Context, email, id string) (models.RefinePreset, error) {
+	var preset models.RefinePreset
+	filter := bson.M{"_id": id, "$or": []bson.M{{"system": true}, {"email": email}}}
+	err := refinePresetCollection.FindOne(ctx, filter).Decode(&preset)
+	if err == mongo.ErrNoDocuments {
+		return preset, errPresetNotFound
+	}
+	return preset, err
+}
+
+// refineSetup is what refines a request: the named preset, or the provider
+// with the request's context. Long texts are refined in chunks.
+type refineSetup struct {
+	refiner        services.TextRefiner
+	streamer       services.StreamingRefiner
+	writingContext string // the context results are cached under
+	promptVersion  string // label of the prompt the user gets
+}
+
+func refineSetupFor(ctx context.Context, email string, req models.RefineRequest) (refineSetup, error) {
+	if req.PresetID == "" {
+		chunked := services.ChunkedRefiner{Refiner: textRefiner, Streamer: streamingRefiner, Model: llmProvider.Model()}
+		return refineSetup{
+			refiner:        chunked,
+			streamer:       chunked,
+			writingContext: req.Context,
+			promptVersion:  services.PromptVersion(ctx, services.PromptRefine),
+		}, nil
+	}
+	preset, err := findPreset(ctx, email, req.PresetID)
+	if err != nil {
+		return refineSetup{}, err
+	}
+	refiner := services.PresetRefiner{Provider: llmProvider, Preset: preset}
+	chunked := services.ChunkedRefiner{Refiner: refiner, Streamer: refiner, Model: llmProvider.Model()}
+	return refineSetup{
+		refiner:        chunked,
+		streamer:       chunked,
+		writingContext: refiner.CacheContext(),
+		promptVersion:  services.PromptVersion(ctx, refiner.PromptName()),
+	}, nil
+}
+
+// presetErrorResponse reports a failed preset lookup
+func presetErrorResponse(w http.ResponseWriter, result *models.Response, err error) {
+	if errors.Is(err, errPresetNotFound) {
+		result.ErrorResponseWithStatus(w, "Refine preset not found", http.StatusNotFound)
+		return
+	}
+	result.ErrorResponse(w, "Failed to fetch refine preset")
+}
+
+// GetRefinePresets lists the system presets followed by the user's own
+func GetRefinePresets(w http.ResponseWriter, r *http.Request) {
+	result := models.NewResponse()
+	email := getEmailFromHeader(r)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "system", Value: -1}, {Key: "name", Value: 1}})
+	cursor, err := refinePresetCollection.Find(ctx, bson.M{"$or": []bson.M{{"system": true}, {"email": email}}}, opts)
+	if err != nil {
+		result.ErrorResponse(w, "Failed to fetch refine presets")
+		return
+	}
+	presets := []models.RefinePreset{}
+	if err := cursor.All(ctx, &presets); err != nil {
+		result.ErrorResponse(w, "Failed to fetch refine presets")
+		return
+	}
+
+	result.SetData(presets)
+	result.SuccessResponse(w, "Refine presets fetched successfully")
+}
+
+// CreateRefinePreset adds a preset for the user
+func CreateRefinePreset(w http.ResponseWriter, r *http.Request) {
+	var req models.RefinePresetRequest
+	payload := models.NewPayload()
+	result := models.NewResponse()
+	if err := payload.DecodePayload(r, &req); err != nil {
+		result.ErrorResponse(w, "Invalid request payload")
+		return
+	}
+	if message := services.ValidatePreset(req); message != "" {
+		result.ErrorResponse(w, message)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	email := getEmailFromHeader(r)
+	count, err := refinePresetCollection.CountDocuments(ctx, bson.M{"email": email})
+	if err != nil {
+		result.ErrorResponse(w, "Failed to create refine preset")
+		return
+	}
+	if count >= maxPresetsPerUser {
+		result.ErrorResponse(w, "You have reached the maximum number of refine presets")
+		return
+	}
+
+	now := time.Now()
+	preset := presetFromRequest(req)
+	preset.ID = primitive.NewObjectID().Hex()
+	preset.Email = email
+	preset.CreatedAt = now
+	preset.UpdatedAt = now
+	if _, err := refinePresetCollection.InsertOne(ctx, preset); err != nil {
+		result.ErrorResponse(w, "Failed to create refine preset")
+		return
+	}
+
+	result.SetData(preset)
+	result.SuccessResponse(w, "Refine preset created successfully")
+}
+
+// UpdateRefinePreset replaces the settings of one of the user's presets
+func UpdateRefinePreset(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var req models.RefinePresetRequest
+	payload := models.NewPayload()
+	result := models.NewResponse()
+	if err := payload.DecodePayload(r, &req); err != nil {
+		result.ErrorResponse(w, "Invalid request payload")
+		return
+	}
+	if message := services.ValidatePreset(req); message != "" {
+		result.ErrorResponse(w, message)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	email := getEmailFromHeader(r)
+	if !ownPreset(ctx, w, result, email, id) {
+		return
+	}
+
+	preset := presetFromRequest(req)
+	update := bson.M{"$set": bson.M{
+		"name":         preset.Name,
+		"instructions": preset.Instructions,
+		"tone":         preset.Tone,
+		"temperature":  preset.Temperature,
+		"maxLength":    preset.MaxLength,
+		"updatedAt":    time.Now(),
+	}}
+	var updated models.RefinePreset
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := refinePresetCollection.FindOneAndUpdate(ctx, bson.M{"_id": id, "email": email}, update, opts).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		result.ErrorResponseWithStatus(w, "Refine preset not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		result.ErrorResponse(w, "Failed to update refine preset")
+		return
+	}
+
+	result.SetData(updated)
+	result.SuccessResponse(w, "Refine preset updated successfully")
+}
+
+// DeleteRefinePreset removes one of the user's presets
+func DeleteRefinePreset(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	result := models.NewResponse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	email := getEmailFromHeader(r)
+	if !ownPreset(ctx, w, result, email, id) {
+		return
+	}
+	deleted, err := refinePresetCollection.DeleteOne(ctx, bson.M{"_id": id, "email": email})
+	if err != nil {
+		result.ErrorResponse(w, "Failed to delete refine preset")
+		return
+	}
+	if deleted.DeletedCount == 0 {
+		result.ErrorResponseWithStatus(w, "Refine preset not found", http.StatusNotFound)
+		return
+	}
+
+	result.SuccessResponse(w, "Refine preset deleted successfully")
+}
+
+// ownPreset reports whether the user may change the preset, answering the
+// request when not: system presets are read-only
+func ownPreset(ctx context.Context, w http.ResponseWriter, result *models.Response, email, id string) bool {
+	preset, err := findPreset(ctx, email, id)
+	if err != nil {
+		presetErrorResponse(w, result, err)
+		return false
+	}
+	if preset.System {
+		result.ErrorResponseWithStatus(w, "System presets cannot be changed", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func presetFromRequest(req models.RefinePresetRequest) models.RefinePreset {
+	temperature := services.DefaultPresetTemperature
+	if req.Temperature != nil {
+		temperature = *req.Temperature
+	}
+	return models.RefinePreset{
+		Name:         strings.TrimSpace(req.Name),
+		Instructions: strings.TrimSpace(req.Instructions),
+		Tone:         strings.TrimSpace(req.Tone),
+		Temperature:  temperature,
+		MaxLength:    req.MaxLength,
+	}
+}
@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"os"
+	"personal-diary/config"
+	"personal-diary/models"
+	"personal-diary/services"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var promptTemplateCollection *mongo.Collection = config.GetCollection("prompt_templates")
+
+// promptRefreshInterval is how often stored prompt versions are reloaded
+const promptRefreshInterval = 5 * time.Minute
+
+// StartPromptRegistry adds the templates in PROMPT_DIR to the embedded ones,
+// failing startup if any is invalid, then loads the versions and weights
+// stored in MongoDB and reloads them periodically, so a prompt experiment
+// can be started or stopped without redeploying.
+func StartPromptRegistry() {
+	if dir := os.Getenv("PROMPT_DIR"); dir != "" {
+		if err := services.Prompts.LoadDir(dir); err != nil {
+			log.Fatalf("Failed to load prompt templates: %v", err)
+		}
+	}
+	loadStoredPrompts()
+
+	go func() {
+		ticker := time.NewTicker(promptRefreshInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			loadStoredPrompts()
+		}
+	}()
+}
+
+// loadStoredPrompts replaces the registry's stored versions. Invalid ones
+// are logged and left out; when MongoDB cannot be read the previous set stays.
+func loadStoredPrompts() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := promptTemplateCollection.Find(ctx, bson.M{})
+	if err != nil {
+		log.Printf("Failed to load stored prompts: %v", err)
+		return
+	}
+	var templates []models.PromptTemplate
+	if err := cursor.All(ctx, &templates); err != nil {
+		log.Printf("Failed to load stored prompts: %v", err)
+		return
+	}
+	if err := services.Prompts.SetStored(templates); err != nil {
+		log.Printf("Skipped invalid stored prompts: %v", err)
+	}
+}
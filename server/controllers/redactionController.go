@@ -0,0 +1,212 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"personal-diary/config"
+	"personal-diary/models"
+	"personal-diary/services"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var redactionSettingsCollection *mongo.Collection = config.GetCollection("redaction_settings")
+var redactionLogCollection *mongo.Collection = config.GetCollection("redaction_log")
+
+const (
+	// redactionLogTTL is how long the record of redactions is kept
+	redactionLogTTL = 30 * 24 * time.Hour
+	// maxRedactedNames bounds the names a user can have hidden
+	maxRedactedNames = 200
+)
+
+// redactionStore backs the redaction in front of llmProvider and embedder
+var redactionStore = newMongoRedactionStore()
+
+// mongoRedactionStore keeps redaction settings and the record of redactions
+// in MongoDB
+type mongoRedactionStore struct{}
+
+func newMongoRedactionStore() mongoRedactionStore {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := redactionLogCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "email", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "createdAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(redactionLogTTL.Seconds()))},
+	})
+	if err != nil {
+		log.Printf("Failed to create redaction log indexes: %v", err)
+	}
+	// Records used to hold the redacted values themselves
+	_, err = redactionLogCollection.UpdateMany(ctx, bson.M{"items.value": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"items.$[].value": ""}})
+	if err != nil {
+		log.Printf("Failed to remove values from the redaction log: %v", err)
+	}
+	return mongoRedactionStore{}
+}
+
+func (mongoRedactionStore) Settings(ctx context.Context, email string) (models.RedactionSettings, error) {
+	settings := models.RedactionSettings{Email: email, Enabled: true, Names: []string{}}
+	if email == "" {
+		return settings, nil
+	}
+	err := redactionSettingsCollection.FindOne(ctx, bson.M{"_id": email}).Decode(&settings)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return settings, err
+	}
+	if settings.Names == nil {
+		settings.Names = []string{}
+	}
+	return settings, nil
+}
+
+func (mongoRedactionStore) Record(ctx context.Context, record models.RedactionRecord) error {
+	_, err := redactionLogCollection.InsertOne(ctx, record)
+	return err
+}
+
+// GetRedactionSettings returns whether the user's text is redacted before it
+// is sent to AI providers, and the names that are hidden
+func GetRedactionSettings(w http.ResponseWriter, r *http.Request) {
+	result := models.NewResponse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	settings, err := redactionStore.Settings(ctx, getEmailFromHeader(r))
+	if err != nil {
+		result.ErrorResponse(w, "Failed to fetch redaction settings")
+		return
+	}
+
+	result.SetData(settings)
+	result.SuccessResponse(w, "Redaction settings fetched successfully")
+}
+
+// UpdateRedactionSettings turns redaction on or off and replaces the list of
+// names to hide
+func UpdateRedactionSettings(w http.ResponseWriter, r *http.Request) {
+	var req models.RedactionSettingsRequest
+	payload := models.NewPayload()
+	result := models.NewResponse()
+	if err := payload.DecodePayload(r, &req); err != nil {
+		result.ErrorResponse(w, "Invalid request payload")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	email := getEmailFromHeader(r)
+	settings, err := redactionStore.Settings(ctx, email)
+	if err != nil {
+		result.ErrorResponse(w, "Failed to update redaction settings")
+		return
+	}
+	if req.Enabled != nil {
+		settings.Enabled = *req.Enabled
+	}
+	if req.Names != nil {
+		settings.Names = normalizeRedactedNames(req.Names)
+		if len(settings.Names) > maxRedactedNames {
+			result.ErrorResponse(w, "You can hide at most "+strconv.Itoa(maxRedactedNames)+" names")
+			return
+		}
+	}
+	settings.UpdatedAt = time.Now()
+
+	update := bson.M{"$set": bson.M{"enabled": settings.Enabled, "names": settings.Names, "updatedAt": settings.UpdatedAt}}
+	opts := options.Update().SetUpsert(true)
+	if _, err := redactionSettingsCollection.UpdateOne(ctx, bson.M{"_id": email}, update, opts); err != nil {
+		log.Printf("Error updating redaction settings: %v", err)
+		result.ErrorResponse(w, "Failed to update redaction settings")
+		return
+	}
+
+	result.SetData(settings)
+	result.SuccessResponse(w, "Redaction settings updated successfully")
+}
+
+// normalizeRedactedNames trims the names and drops blanks and duplicates
+func normalizeRedactedNames(names []string) []string {
+	normalized := []string{}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.Join(strings.Fields(name), " ")
+		key := strings.ToLower(name)
+		if name == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		normalized = append(normalized, name)
+	}
+	return normalized
+}
+
+// GetRedactions lists what was redacted from the user's recent AI calls,
+// newest first, with each value masked. Query parameter: limit (default 50, at most 200).
+func GetRedactions(w http.ResponseWriter, r *http.Request) {
+	result := models.NewResponse()
+	limit := 50
+	if parsed, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && parsed > 0 {
+		limit = min(parsed, 200)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := redactionLogCollection.Find(ctx, bson.M{"email": getEmailFromHeader(r)}, opts)
+	if err != nil {
+		result.ErrorResponse(w, "Failed to fetch redactions")
+		return
+	}
+	records := []models.RedactionRecord{}
+	if err := cursor.All(ctx, &records); err != nil {
+		result.ErrorResponse(w, "Failed to fetch redactions")
+		return
+	}
+
+	result.SetData(records)
+	result.SuccessResponse(w, "Redactions fetched successfully")
+}
+
+// PreviewRedaction shows how a text would be sent to AI providers with the
+// user's current settings
+func PreviewRedaction(w http.ResponseWriter, r *http.Request) {
+	var req models.RedactionPreviewRequest
+	payload := models.NewPayload()
+	result := models.NewResponse()
+	if err := payload.DecodePayload(r, &req); err != nil {
+		result.ErrorResponse(w, "Invalid request payload")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	settings, err := redactionStore.Settings(ctx, getEmailFromHeader(r))
+	if err != nil {
+		result.ErrorResponse(w, "Failed to fetch redaction settings")
+		return
+	}
+	preview := models.RedactionPreviewResponse{Enabled: settings.Enabled, RedactedText: req.Text, Items: []models.RedactedItem{}}
+	if settings.Enabled {
+		redactor := services.NewRedactor(settings.Names)
+		preview.RedactedText = redactor.Redact(req.Text)
+		if items := redactor.Items(); items != nil {
+			preview.Items = items
+		}
+	}
+
+	result.SetData(preview)
+	result.SuccessResponse(w, "Redaction preview created successfully")
+}
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"os"
+	"personal-diary/config"
+	"personal-diary/services"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var refineCacheCollection *mongo.Collection = config.GetCollection("refine_cache")
+
+// refineCacheTrimInterval is how often the mongo cache is cut back to
+// REFINE_CACHE_SIZE, so it can briefly hold more results between trims
+const refineCacheTrimInterval = 5 * time.Minute
+
+// refineCache holds refinements by services.RefineCacheKey. REFINE_CACHE
+// selects "memory" (default), "mongo" or "off"; REFINE_CACHE_TTL and
+// REFINE_CACHE_SIZE bound how long and how many results are kept.
+var refineCache services.RefineCache = newRefineCache()
+
+func newRefineCache() services.RefineCache {
+	ttl := 24 * time.Hour
+	if parsed, err := time.ParseDuration(os.Getenv("REFINE_CACHE_TTL")); err == nil && parsed > 0 {
+		ttl = parsed
+	}
+	size := 1000
+	if parsed, err := strconv.Atoi(os.Getenv("REFINE_CACHE_SIZE")); err == nil && parsed > 0 {
+		size = parsed
+	}
+
+	switch os.Getenv("REFINE_CACHE") {
+	case "off":
+		log.Printf("Refine cache: off")
+		return services.NoRefineCache{}
+	case "mongo":
+		ensureRefineCacheIndexes()
+		startRefineCacheTrim(int64(size))
+		log.Printf("Refine cache: mongo (ttl %s, %d entries)", ttl, size)
+		return mongoRefineCache{ttl: ttl}
+	default:
+		log.Printf("Refine cache: memory (ttl %s, %d entries)", ttl, size)
+		return services.NewMemoryRefineCache(size, ttl)
+	}
+}
+
+// ensureRefineCacheIndexes lets MongoDB drop expired results and makes the
+// oldest ones cheap to find when trimming
+func ensureRefineCacheIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := refineCacheCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.D{{Key: "createdAt", Value: 1}}},
+	})
+	if err != nil {
+		log.Printf("Failed to create refine cache indexes: %v", err)
+	}
+}
+
+// mongoRefineCache shares cached refinements between server instances
+type mongoRefineCache struct {
+	ttl time.Duration
+}
+
+type refineCacheDocument struct {
+	Key         string    `bson:"_id"`
+	RefinedText string    `bson:"refinedText"`
+	CreatedAt   time.Time `bson:"createdAt"`
+	ExpiresAt   time.Time `bson:"expiresAt"`
+}
+
+func (c mongoRefineCache) Get(ctx context.Context, key string) (string, bool, error) {
+	var doc refineCacheDocument
+	// The TTL monitor runs about once a minute, so expiry is checked here too
+	err := refineCacheCollection.FindOne(ctx, bson.M{"_id": key, "expiresAt": bson.M{"$gt": time.Now()}}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return doc.RefinedText, true, nil
+}
+
+func (c mongoRefineCache) Set(ctx context.Context, key, text string) error {
+	now := time.Now()
+	doc := refineCacheDocument{Key: key, RefinedText: text, CreatedAt: now, ExpiresAt: now.Add(c.ttl)}
+	_, err := refineCacheCollection.ReplaceOne(ctx, bson.M{"_id": key}, doc, options.Replace().SetUpsert(true))
+	return err
+}
+
+// startRefineCacheTrim drops the oldest cached results beyond maxEntries
+// every refineCacheTrimInterval, rather than counting on each Set
+func startRefineCacheTrim(maxEntries int64) {
+	go func() {
+		ticker := time.NewTicker(refineCacheTrimInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := trimRefineCache(maxEntries); err != nil {
+				log.Printf("Failed to trim refine cache: %v", err)
+			}
+		}
+	}()
+}
+
+func trimRefineCache(maxEntries int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	count, err := refineCacheCollection.EstimatedDocumentCount(ctx)
+	if err != nil || count <= maxEntries {
+		return err
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}}).
+		SetLimit(count - maxEntries).
+		SetProjection(bson.M{"_id": 1})
+	cursor, err := refineCacheCollection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return err
+	}
+	var oldest []refineCacheDocument
+	if err := cursor.All(ctx, &oldest); err != nil {
+		return err
+	}
+	keys := make([]string, len(oldest))
+	for i, doc := range oldest {
+		keys[i] = doc.Key
+	}
+	_, err = refineCacheCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": keys}})
+	return err
+}
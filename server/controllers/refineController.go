@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"personal-diary/models"
+	"personal-diary/services"
+	"strings"
+)
+
+// streamingRefiner backs RefineStreamHandler; it is llmProvider
+var streamingRefiner services.StreamingRefiner
+
+// RefineStreamHandler refines text like RefineTextHandler but relays the
+// model output to the browser as Server-Sent Events while it is generated:
+// "token" events carry each delta, a final "done" event the full text and
+// "error" reports a failure. A cached result is sent as a lone "done" event.
+// In "edits" mode the "done" event also lists the changes. Upstream
+// generation stops when the client disconnects, because the request context
+// is passed to the provider.
+func RefineStreamHandler(w http.ResponseWriter, r *http.Request) {
+	payload := models.NewPayload()
+	result := models.NewResponse()
+	var req models.RefineRequest
+
+	if err := payload.DecodePayload(r, &req); err != nil {
+		result.ErrorResponse(w, "Invalid JSON format")
+		return
+	}
+
+	text := strings.TrimSpace(req.Text)
+	if message := validateRefineText(text, services.MaxRefineChars); message != "" {
+		result.ErrorResponse(w, message)
+		return
+	}
+	if message := validateRefineMode(req.Mode); message != "" {
+		result.ErrorResponse(w, message)
+		return
+	}
+
+	email := getEmailFromHeader(r)
+	setup, err := refineSetupFor(r.Context(), email, req)
+	if err != nil {
+		presetErrorResponse(w, result, err)
+		return
+	}
+
+	// Quota errors need a status code, which can only be sent before
+	// streaming. Cached results are only served within the quota too.
+	if err := usageMeter.CheckQuota(r.Context(), email); err != nil {
+		aiErrorResponse(w, result, err, "Failed to refine text")
+		return
+	}
+
+	cacheKey := services.RefineCacheKey(email, text, setup.writingContext, req.Tone, llmProvider.Model(), setup.promptVersion)
+	cachedText, cached, err := refineCache.Get(r.Context(), cacheKey)
+	if err != nil {
+		log.Printf("Refine cache lookup failed: %v", err)
+	}
+
+	stream, err := newSSEStream(w)
+	if err != nil {
+		result.ErrorResponse(w, "Streaming is not supported")
+		return
+	}
+
+	if cached {
+		stream.Send("", "done", refineDoneEvent(req, setup, text, cachedText, true))
+		return
+	}
+
+	refinedText, err := setup.streamer.RefineStream(r.Context(), text, setup.writingContext, req.Tone, func(delta string) error {
+		return stream.Send("", "token", models.RefineStreamEvent{Delta: delta})
+	})
+	if r.Context().Err() != nil {
+		log.Printf("Refine stream cancelled by client")
+		return
+	}
+	if err != nil {
+		log.Printf("Refine stream failed: %v", err)
+		message := "Failed to refine text"
+		var quotaErr *services.QuotaExceededError
+		if errors.As(err, &quotaErr) {
+			message = quotaExceededMessage(quotaErr)
+		} else if errors.Is(err, services.ErrProviderUnavailable) {
+			message = providerUnavailableMessage
+		}
+		stream.Send("", "error", models.RefineStreamEvent{Message: message})
+		return
+	}
+	if err := refineCache.Set(r.Context(), cacheKey, refinedText); err != nil {
+		log.Printf("Refine cache store failed: %v", err)
+	}
+
+	stream.Send("", "done", refineDoneEvent(req, setup, text, refinedText, false))
+}
+
+// refineResponse is the result of a refine request
+func refineResponse(req models.RefineRequest, setup refineSetup, text, refinedText string, cached bool) models.RefineResponse {
+	response := models.RefineResponse{
+		RefinedText:   refinedText,
+		Message:       "Text refined successfully",
+		Cached:        cached,
+		PromptVersion: setup.promptVersion,
+	}
+	if req.Mode == models.RefineModeEdits {
+		response.Edits = services.ComputeEdits(text, refinedText)
+	}
+	return response
+}
+
+func refineDoneEvent(req models.RefineRequest, setup refineSetup, text, refinedText string, cached bool) models.RefineStreamEvent {
+	event := models.RefineStreamEvent{
+		RefinedText:   refinedText,
+		Message:       "Text refined successfully",
+		Cached:        cached,
+		PromptVersion: setup.promptVersion,
+	}
+	if req.Mode == models.RefineModeEdits {
+		event.Edits = services.ComputeEdits(text, refinedText)
+	}
+	return event
+}
+
+// ApplyRefineEditsHandler builds the final text from the edits a user accepted
+// out of those returned by a refine request in "edits" mode. The text must be
+// the original that was refined; edits that no longer match it are rejected.
+func ApplyRefineEditsHandler(w http.ResponseWriter, r *http.Request) {
+	payload := models.NewPayload()
+	result := models.NewResponse()
+	var req models.ApplyEditsRequest
+
+	if err := payload.DecodePayload(r, &req); err != nil {
+		result.ErrorResponse(w, "Invalid JSON format")
+		return
+	}
+
+	// Offsets refer to the trimmed text that was refined
+	text, applied, err := services.ApplyEdits(strings.TrimSpace(req.Text), req.Edits, req.Accepted)
+	if err != nil {
+		result.ErrorResponse(w, "Edits do not match the text")
+		return
+	}
+
+	result.SetData(models.ApplyEditsResponse{Text: text, Applied: applied})
+	result.SuccessResponse(w, "Edits applied successfully")
+}
@@ -0,0 +1,203 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"personal-diary/models"
+	"personal-diary/services"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultRelatedLimit = 5
+	maxRelatedLimit     = 20
+)
+
+// vectorIndex holds one embedding per entry for GET /diary/{id}/related.
+// indexEntry and removeEntryChunks keep it in step with the stored chunks.
+var vectorIndex = services.NewVectorIndex()
+
+// loadUserVectors reads the user's chunks and adds one vector per entry to
+// the index
+func loadUserVectors(ctx context.Context, email string) error {
+	opts := options.Find().SetProjection(bson.M{"entryId": 1, "vector": 1, "entryCreatedAt": 1})
+	cursor, err := entryChunkCollection.Find(ctx, bson.M{"email": email, "model": embedder.Model()}, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	chunkVectors := make(map[string][][]float32)
+	createdAt := make(map[string]time.Time)
+	for cursor.Next(ctx) {
+		var chunk models.EntryChunk
+		if err := cursor.Decode(&chunk); err != nil {
+			return err
+		}
+		chunkVectors[chunk.EntryID] = append(chunkVectors[chunk.EntryID], chunk.Vector)
+		createdAt[chunk.EntryID] = chunk.EntryCreatedAt
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	vectors := make(map[string][]float32, len(chunkVectors))
+	for id, chunks := range chunkVectors {
+		vectors[id] = services.EntryVector(chunks)
+	}
+	vectorIndex.Load(email, vectors, createdAt)
+	return nil
+}
+
+// GetRelatedEntries returns the user's past entries most similar to the given
+// one, with their cosine similarity. ?limit= sets how many (default 5, max 20).
+func GetRelatedEntries(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	result := models.NewResponse()
+	email := getEmailFromHeader(r)
+
+	limit := defaultRelatedLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxRelatedLimit {
+			result.ErrorResponse(w, "Limit must be between 1 and 20")
+			return
+		}
+		limit = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+
+	if !vectorIndex.Loaded(email) {
+		if err := loadUserVectors(ctx, email); err != nil {
+			log.Printf("Failed to load vectors of %s: %v", email, err)
+			result.ErrorResponse(w, "Failed to find related entries")
+			return
+		}
+	}
+
+	matches, ok := vectorIndex.Nearest(email, id, limit)
+	if !ok {
+		// Not embedded yet, e.g. written before the backfill reached it
+		var entry models.DiaryEntry
+		err := diaryCollection.FindOne(ctx, bson.M{"_id": id, "email": email}).Decode(&entry)
+		if err == mongo.ErrNoDocuments {
+			result.ErrorResponse(w, "Diary entry not found")
+			return
+		} else if err != nil {
+			result.ErrorResponse(w, "Failed to fetch diary entry")
+			return
+		}
+		if err := indexEntry(ctx, entry); err != nil {
+			log.Printf("Failed to index entry %s: %v", id, err)
+			aiErrorResponse(w, result, err, "Failed to find related entries")
+			return
+		}
+		matches, _ = vectorIndex.Nearest(email, id, limit)
+	}
+
+	related := []models.RelatedEntry{}
+	if len(matches) > 0 {
+		ids := make([]string, len(matches))
+		for i, match := range matches {
+			ids[i] = match.EntryID
+		}
+		opts := options.Find().SetProjection(bson.M{"title": 1, "createdAt": 1})
+		cursor, err := diaryCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "email": email}, opts)
+		if err != nil {
+			result.ErrorResponse(w, "Failed to fetch related entries")
+			return
+		}
+		var entries []models.DiaryEntry
+		if err := cursor.All(ctx, &entries); err != nil {
+			result.ErrorResponse(w, "Failed to fetch related entries")
+			return
+		}
+		byID := make(map[string]models.DiaryEntry, len(entries))
+		for _, entry := range entries {
+			byID[entry.ID] = entry
+		}
+
+		// Keep the similarity order; entries deleted meanwhile are skipped
+		for _, match := range matches {
+			if entry, ok := byID[match.EntryID]; ok {
+				related = append(related, models.RelatedEntry{
+					EntryID:   entry.ID,
+					Title:     entry.Title,
+					CreatedAt: entry.CreatedAt,
+					Score:     match.Score,
+				})
+			}
+		}
+	}
+
+	result.SetData(related)
+	result.SuccessResponse(w, "Related entries fetched successfully")
+}
+
+// StartEmbeddingBackfill embeds entries that have no current chunks, such as
+// entries written before embeddings existed or under a different model. It
+// runs at startup and then hourly to pick up entries whose indexing failed.
+func StartEmbeddingBackfill() {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		backfillEmbeddings()
+		for range ticker.C {
+			backfillEmbeddings()
+		}
+	}()
+	log.Printf("Embedding backfill started (model %s)", embedder.Model())
+}
+
+func backfillEmbeddings() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	emails, err := diaryCollection.Distinct(ctx, "email", bson.M{})
+	cancel()
+	if err != nil {
+		log.Printf("Embedding backfill: failed to list users: %v", err)
+		return
+	}
+
+	indexed := 0
+	for _, value := range emails {
+		email, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		stale, err := staleEntries(ctx, email)
+		if err != nil {
+			log.Printf("Embedding backfill: failed to list entries of %s: %v", email, err)
+			cancel()
+			continue
+		}
+		for _, entry := range stale {
+			if err := indexEntry(ctx, entry); err != nil {
+				cancel()
+				if errors.Is(err, services.ErrLLMNotConfigured) {
+					log.Printf("Embedding backfill skipped: %v", err)
+					return
+				}
+				log.Printf("Embedding backfill: failed to index entry %s: %v", entry.ID, err)
+				break
+			}
+			indexed++
+		}
+		cancel()
+	}
+	if indexed > 0 {
+		log.Printf("Embedding backfill indexed %d entries", indexed)
+	}
+}
@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"personal-diary/models"
+)
+
+// sseStream writes Server-Sent Events to a response, flushing after each one
+type sseStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func newSSEStream(w http.ResponseWriter) (*sseStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("streaming is not supported by the response writer")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // disable proxy buffering (nginx)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &sseStream{w: w, flusher: flusher}, nil
+}
+
+// Send writes one event. The data is encoded like regular response bodies,
+// so it is encrypted whenever payload encryption is enabled.
+func (s *sseStream) Send(id, event string, data any) error {
+	encoded, err := models.EncodeData(data)
+	if err != nil {
+		return err
+	}
+
+	if id != "" {
+		fmt.Fprintf(s.w, "id: %s\n", id)
+	}
+	if event != "" {
+		fmt.Fprintf(s.w, "event: %s\n", event)
+	}
+	if _, err := fmt.Fprintf(s.w, "data: %s\n\n", encoded); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// Retry tells the browser how long to wait before reconnecting
+func (s *sseStream) Retry(milliseconds int) {
+	fmt.Fprintf(s.w, "retry: %d\n\n", milliseconds)
+	s.flusher.Flush()
+}
+
+// Ping writes a comment line to keep idle connections open through proxies
+func (s *sseStream) Ping() error {
+	if _, err := fmt.Fprint(s.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
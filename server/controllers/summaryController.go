@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"personal-diary/models"
+	"personal-diary/services"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxSummaryRangeDays bounds POST /diary/summary to about a year of entries
+const maxSummaryRangeDays = 366
+
+// SummarizeEntryHandler returns a summary and key points for one entry. The
+// summary is cached on the entry until its content changes; ?refresh=true
+// forces a new one.
+func SummarizeEntryHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	result := models.NewResponse()
+	email := getEmailFromHeader(r)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var entry models.DiaryEntry
+	err := diaryCollection.FindOne(ctx, bson.M{"_id": id, "email": email}).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		result.ErrorResponse(w, "Diary entry not found")
+		return
+	} else if err != nil {
+		result.ErrorResponse(w, "Failed to fetch diary entry")
+		return
+	}
+
+	summary := entry.Summary
+	cached := summary != nil && summary.ContentHash == services.ContentHash(entry.Content) && r.URL.Query().Get("refresh") != "true"
+	if !cached {
+		summary, err = services.SummarizeEntry(r.Context(), llmProvider, entry)
+		if err != nil {
+			log.Printf("Summarising entry %s failed: %v", id, err)
+			aiErrorResponse(w, result, err, "Failed to summarise diary entry")
+			return
+		}
+
+		// Only store the summary if the entry was not edited meanwhile
+		saveCtx, saveCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer saveCancel()
+		filter := bson.M{"_id": id, "email": email, "content": entry.Content}
+		if _, err := diaryCollection.UpdateOne(saveCtx, filter, bson.M{"$set": bson.M{"summary": summary}}); err != nil {
+			log.Printf("Failed to cache summary for %s: %v", id, err)
+		}
+	}
+
+	result.SetData(models.SummaryResponse{
+		Summary:       summary.Summary,
+		KeyPoints:     summary.KeyPoints,
+		EntryCount:    1,
+		Cached:        cached,
+		PromptVersion: summary.PromptVersion,
+	})
+	result.SuccessResponse(w, "Diary entry summarised successfully")
+}
+
+// SummarizePeriodHandler summarises all entries in an inclusive date range
+func SummarizePeriodHandler(w http.ResponseWriter, r *http.Request) {
+	var req models.SummaryRequest
+	payload := models.NewPayload()
+	result := models.NewResponse()
+	if err := payload.DecodePayload(r, &req); err != nil {
+		result.ErrorResponse(w, "Invalid request payload")
+		return
+	}
+
+	from, err := time.Parse("2006-01-02", req.From)
+	if err != nil {
+		result.ErrorResponse(w, "From must be a date in YYYY-MM-DD format")
+		return
+	}
+	to, err := time.Parse("2006-01-02", req.To)
+	if err != nil {
+		result.ErrorResponse(w, "To must be a date in YYYY-MM-DD format")
+		return
+	}
+	end := to.AddDate(0, 0, 1)
+	if !end.After(from) {
+		result.ErrorResponse(w, "From must not be after To")
+		return
+	}
+	if end.Sub(from) > maxSummaryRangeDays*24*time.Hour {
+		result.ErrorResponse(w, "Date range is too long. Maximum one year allowed.")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"email": getEmailFromHeader(r), "createdAt": bson.M{"$gte": from, "$lt": end}}
+	cursor, err := diaryCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		result.ErrorResponse(w, "Failed to fetch diary entries")
+		return
+	}
+	var entries []models.DiaryEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		result.ErrorResponse(w, "Failed to decode diary entries")
+		return
+	}
+	if len(entries) == 0 {
+		result.ErrorResponse(w, "No diary entries in this period")
+		return
+	}
+
+	summary, keyPoints, err := services.SummarizePeriod(r.Context(), llmProvider, entries)
+	if err != nil {
+		log.Printf("Summarising period %s..%s failed: %v", req.From, req.To, err)
+		aiErrorResponse(w, result, err, "Failed to summarise diary entries")
+		return
+	}
+
+	result.SetData(models.SummaryResponse{
+		Summary:       summary,
+		KeyPoints:     keyPoints,
+		EntryCount:    len(entries),
+		From:          from,
+		To:            to,
+		PromptVersion: services.PromptVersion(r.Context(), services.PromptSummary),
+	})
+	result.SuccessResponse(w, "Diary entries summarised successfully")
+}
+
+// invalidateStaleSummary drops a cached summary that no longer matches the
+// entry's content. It is called after every content update.
+func invalidateStaleSummary(ctx context.Context, entry *models.DiaryEntry) {
+	if entry.Summary == nil || entry.Summary.ContentHash == services.ContentHash(entry.Content) {
+		return
+	}
+	filter := bson.M{"_id": entry.ID, "summary.contentHash": entry.Summary.ContentHash}
+	if _, err := diaryCollection.UpdateOne(ctx, filter, bson.M{"$unset": bson.M{"summary": ""}}); err != nil {
+		log.Printf("Failed to invalidate summary of %s: %v", entry.ID, err)
+		return
+	}
+	entry.Summary = nil
+}
@@ -0,0 +1,505 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"personal-diary/config"
+	"personal-diary/models"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var syncCounterCollection *mongo.Collection = config.GetCollection("sync_counters")
+var tombstoneCollection *mongo.Collection = config.GetCollection("diary_tombstones")
+
+const (
+	defaultSyncPageSize = 200
+	maxSyncPageSize     = 500
+	maxSyncBatchSize    = 200
+	syncTokenPrefix     = "v1:"
+)
+
+// Client-generated entry ids must be URL and Mongo friendly
+var syncIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// syncWriteGrace bounds how long an unfinished write holds back pulls, so a
+// request that died before finishSyncWrite cannot stall sync for good
+const syncWriteGrace = time.Minute
+
+var errEntryChanged = errors.New("entry changed concurrently")
+
+type syncCounter struct {
+	Seq     int64 `bson:"seq"`
+	Pending []struct {
+		Seq int64     `bson:"seq"`
+		At  time.Time `bson:"at"`
+	} `bson:"pending"`
+}
+
+// nextSyncSeq increments and returns the user's change sequence. Every entry
+// write is stamped with it so GET /sync can return changes in order. The
+// number is pending until the caller's finishSyncWrite, and pulls stop short
+// of pending numbers so a write that commits late is not skipped.
+func nextSyncSeq(ctx context.Context, email string) (int64, error) {
+	now := time.Now()
+	// One pipeline update takes the number, marks it pending and drops
+	// pending numbers of writes that never finished
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"seq": bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$seq", 0}}, 1}}}}},
+		{{Key: "$set", Value: bson.M{"pending": bson.M{"$concatArrays": bson.A{
+			bson.M{"$filter": bson.M{
+				"input": bson.M{"$ifNull": bson.A{"$pending", bson.A{}}},
+				"cond":  bson.M{"$gt": bson.A{"$$this.at", now.Add(-syncWriteGrace)}},
+			}},
+			bson.A{bson.M{"seq": "$seq", "at": now}},
+		}}}}},
+	}
+	var counter syncCounter
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := syncCounterCollection.FindOneAndUpdate(ctx, bson.M{"_id": email}, update, opts).Decode(&counter)
+	return counter.Seq, err
+}
+
+// finishSyncWrite releases a number from nextSyncSeq once the write stamped
+// with it has committed or failed
+func finishSyncWrite(email string, seq int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{"$pull": bson.M{"pending": bson.M{"seq": seq}}}
+	if _, err := syncCounterCollection.UpdateOne(ctx, bson.M{"_id": email}, update); err != nil {
+		log.Printf("Failed to finish sync write %d for %s: %v", seq, email, err)
+	}
+}
+
+// syncWatermark is the highest sequence number below which every write of
+// the user has finished. Pulls never return changes above it.
+func syncWatermark(ctx context.Context, email string) (int64, error) {
+	var counter syncCounter
+	err := syncCounterCollection.FindOne(ctx, bson.M{"_id": email}).Decode(&counter)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	watermark := counter.Seq
+	cutoff := time.Now().Add(-syncWriteGrace)
+	for _, pending := range counter.Pending {
+		if pending.At.After(cutoff) && pending.Seq <= watermark {
+			watermark = pending.Seq - 1
+		}
+	}
+	return watermark, nil
+}
+
+func tombstoneID(email, entryID string) string {
+	return email + ":" + entryID
+}
+
+// recordTombstone remembers a deleted entry so other devices remove it too
+func recordTombstone(ctx context.Context, email, entryID string, version, seq int64) error {
+	tombstone := models.DiaryTombstone{
+		ID:        tombstoneID(email, entryID),
+		EntryID:   entryID,
+		Email:     email,
+		Version:   version,
+		DeletedAt: time.Now(),
+		SyncSeq:   seq,
+	}
+	opts := options.Replace().SetUpsert(true)
+	_, err := tombstoneCollection.ReplaceOne(ctx, bson.M{"_id": tombstone.ID}, tombstone, opts)
+	return err
+}
+
+// deleteEntry deletes the user's entry matching filter. The tombstone is
+// recorded first, so a deleted entry always reaches other devices; it is
+// withdrawn again if the delete fails. errEntryChanged means the entry was
+// updated between the lookup and the delete.
+func deleteEntry(ctx context.Context, email string, filter bson.M) error {
+	filter["email"] = email
+	var entry models.DiaryEntry
+	if err := diaryCollection.FindOne(ctx, filter).Decode(&entry); err != nil {
+		return err
+	}
+
+	seq, err := nextSyncSeq(ctx, email)
+	if err != nil {
+		return err
+	}
+	defer finishSyncWrite(email, seq)
+
+	if err := recordTombstone(ctx, email, entry.ID, entry.Version+1, seq); err != nil {
+		return err
+	}
+	deleted, err := diaryCollection.DeleteOne(ctx, bson.M{"_id": entry.ID, "email": email, "version": entry.Version})
+	if err == nil && deleted.DeletedCount == 0 {
+		err = errEntryChanged
+	}
+	if err != nil {
+		undo := bson.M{"_id": tombstoneID(email, entry.ID), "syncSeq": seq}
+		if _, undoErr := tombstoneCollection.DeleteOne(ctx, undo); undoErr != nil {
+			log.Printf("Failed to withdraw tombstone for %s: %v", entry.ID, undoErr)
+		}
+		return err
+	}
+
+	removeEntryChunks(ctx, email, entry.ID)
+	removeEntryAttachments(entry.ID)
+	publishEntryEvent(email, models.EventEntryDeleted, entry.ID, nil)
+	return nil
+}
+
+func encodeSyncToken(seq int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(syncTokenPrefix + strconv.FormatInt(seq, 10)))
+}
+
+func decodeSyncToken(token string) (int64, error) {
+	if token == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || !strings.HasPrefix(string(raw), syncTokenPrefix) {
+		return 0, fmt.Errorf("invalid sync token")
+	}
+	seq, err := strconv.ParseInt(strings.TrimPrefix(string(raw), syncTokenPrefix), 10, 64)
+	if err != nil || seq < 0 {
+		return 0, fmt.Errorf("invalid sync token")
+	}
+	return seq, nil
+}
+
+// MigrateSyncSeq stamps entries written before sync existed, so that a full
+// sync (no token) can page through them like any other change. It runs once
+// at startup and finds nothing to do after the first run.
+func MigrateSyncSeq() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	legacyFilter := bson.M{"syncSeq": bson.M{"$exists": false}}
+	emails, err := diaryCollection.Distinct(ctx, "email", legacyFilter)
+	if err != nil {
+		log.Printf("Sync migration: failed to list users: %v", err)
+		return
+	}
+
+	stamped := 0
+	for _, value := range emails {
+		email, ok := value.(string)
+		if !ok {
+			continue
+		}
+		n, err := backfillSyncSeq(ctx, email)
+		stamped += n
+		if err != nil {
+			log.Printf("Sync migration of %s failed: %v", email, err)
+		}
+	}
+	if stamped > 0 {
+		log.Printf("Sync migration stamped %d entries", stamped)
+	}
+}
+
+// backfillSyncSeq stamps the user's entries that have no sequence number and
+// returns how many it stamped
+func backfillSyncSeq(ctx context.Context, email string) (int, error) {
+	cursor, err := diaryCollection.Find(ctx, bson.M{"email": email, "syncSeq": bson.M{"$exists": false}},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		return 0, err
+	}
+	var legacy []models.DiaryEntry
+	if err := cursor.All(ctx, &legacy); err != nil {
+		return 0, err
+	}
+
+	for i, entry := range legacy {
+		if err := stampSyncSeq(ctx, email, entry); err != nil {
+			return i, err
+		}
+	}
+	return len(legacy), nil
+}
+
+func stampSyncSeq(ctx context.Context, email string, entry models.DiaryEntry) error {
+	seq, err := nextSyncSeq(ctx, email)
+	if err != nil {
+		return err
+	}
+	defer finishSyncWrite(email, seq)
+
+	set := bson.M{"syncSeq": seq, "version": max(entry.Version, 1)}
+	if entry.UpdatedAt.IsZero() {
+		set["updatedAt"] = entry.CreatedAt
+	}
+	filter := bson.M{"_id": entry.ID, "syncSeq": bson.M{"$exists": false}}
+	_, err = diaryCollection.UpdateOne(ctx, filter, bson.M{"$set": set})
+	return err
+}
+
+// PullSync returns entries and deletions changed since the given sync token.
+// Clients call it repeatedly with the returned token while hasMore is true.
+func PullSync(w http.ResponseWriter, r *http.Request) {
+	result := models.NewResponse()
+
+	since, err := decodeSyncToken(r.URL.Query().Get("since"))
+	if err != nil {
+		result.ErrorResponse(w, "Invalid sync token")
+		return
+	}
+	limit := defaultSyncPageSize
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			result.ErrorResponse(w, "Invalid limit")
+			return
+		}
+		limit = min(parsed, maxSyncPageSize)
+	}
+
+	email := getEmailFromHeader(r)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Changes above the watermark may still be joined by writes that took
+	// a lower number but have not committed; they are returned next time
+	watermark, err := syncWatermark(ctx, email)
+	if err != nil {
+		result.ErrorResponse(w, "Failed to prepare sync")
+		return
+	}
+
+	filter := bson.M{"email": email, "syncSeq": bson.M{"$gt": since, "$lte": watermark}}
+	opts := options.Find().SetSort(bson.D{{Key: "syncSeq", Value: 1}}).SetLimit(int64(limit + 1))
+
+	var entries []models.DiaryEntry
+	cursor, err := diaryCollection.Find(ctx, filter, opts)
+	if err == nil {
+		err = cursor.All(ctx, &entries)
+	}
+	if err != nil {
+		result.ErrorResponse(w, "Failed to fetch changed entries")
+		return
+	}
+
+	var tombstones []models.DiaryTombstone
+	cursor, err = tombstoneCollection.Find(ctx, filter, opts)
+	if err == nil {
+		err = cursor.All(ctx, &tombstones)
+	}
+	if err != nil {
+		result.ErrorResponse(w, "Failed to fetch deleted entries")
+		return
+	}
+
+	// Merge both lists in sequence order and cut the page at limit changes
+	page := models.SyncPullResponse{Entries: []models.DiaryEntry{}, Deleted: []models.DiaryTombstone{}}
+	last := since
+	i, j := 0, 0
+	for i+j < limit && (i < len(entries) || j < len(tombstones)) {
+		if j >= len(tombstones) || (i < len(entries) && entries[i].SyncSeq < tombstones[j].SyncSeq) {
+			page.Entries = append(page.Entries, entries[i])
+			last = entries[i].SyncSeq
+			i++
+		} else {
+			page.Deleted = append(page.Deleted, tombstones[j])
+			last = tombstones[j].SyncSeq
+			j++
+		}
+	}
+	page.HasMore = i < len(entries) || j < len(tombstones)
+	page.SyncToken = encodeSyncToken(last)
+
+	result.SetData(page)
+	result.SuccessResponse(w, "Sync changes fetched successfully")
+}
+
+// PushSync applies a batch of offline edits. Each change is applied only if
+// its base version still matches the server; otherwise it is reported as a
+// conflict together with the server copy so the client can merge.
+func PushSync(w http.ResponseWriter, r *http.Request) {
+	var req models.SyncPushRequest
+	payload := models.NewPayload()
+	result := models.NewResponse()
+	if err := payload.DecodePayload(r, &req); err != nil {
+		result.ErrorResponse(w, "Invalid request payload")
+		return
+	}
+
+	if len(req.Changes) == 0 {
+		result.ErrorResponse(w, "No changes to sync")
+		return
+	}
+	if len(req.Changes) > maxSyncBatchSize {
+		result.ErrorResponse(w, fmt.Sprintf("Too many changes. Maximum %d per request.", maxSyncBatchSize))
+		return
+	}
+
+	email := getEmailFromHeader(r)
+	results := make([]models.SyncChangeResult, 0, len(req.Changes))
+	for _, change := range req.Changes {
+		results = append(results, applySyncChange(email, change))
+	}
+
+	result.SetData(map[string]interface{}{"results": results})
+	result.SuccessResponse(w, "Sync changes processed")
+}
+
+func applySyncChange(email string, change models.SyncChange) models.SyncChangeResult {
+	if !syncIDPattern.MatchString(change.ID) {
+		return models.SyncChangeResult{ID: change.ID, Status: models.SyncStatusError, Message: "Invalid entry id"}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var existing models.DiaryEntry
+	err := diaryCollection.FindOne(ctx, bson.M{"_id": change.ID}).Decode(&existing)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("Sync lookup of %s failed: %v", change.ID, err)
+		return models.SyncChangeResult{ID: change.ID, Status: models.SyncStatusError, Message: "Database error"}
+	}
+	found := err == nil
+	if found && existing.Email != email {
+		// Answer as for a malformed id, so ids of other users' entries
+		// cannot be probed
+		return models.SyncChangeResult{ID: change.ID, Status: models.SyncStatusError, Message: "Invalid entry id"}
+	}
+
+	switch change.Op {
+	case models.SyncOpUpsert:
+		if !found {
+			return insertSyncedEntry(ctx, email, change)
+		}
+		return updateSyncedEntry(ctx, email, change, &existing)
+	case models.SyncOpDelete:
+		if !found {
+			// Already gone; deleting twice is not a conflict
+			return models.SyncChangeResult{ID: change.ID, Status: models.SyncStatusApplied}
+		}
+		return deleteSyncedEntry(ctx, email, change, &existing)
+	default:
+		return models.SyncChangeResult{ID: change.ID, Status: models.SyncStatusError, Message: "Unknown sync operation"}
+	}
+}
+
+func insertSyncedEntry(ctx context.Context, email string, change models.SyncChange) models.SyncChangeResult {
+	if change.BaseVersion > 0 {
+		// Edited offline, but deleted on the server in the meantime
+		return models.SyncChangeResult{ID: change.ID, Status: models.SyncStatusConflict, Message: "Entry was deleted on the server"}
+	}
+
+	seq, err := nextSyncSeq(ctx, email)
+	if err != nil {
+		return models.SyncChangeResult{ID: change.ID, Status: models.SyncStatusError, Message: "Database error"}
+	}
+	defer finishSyncWrite(email, seq)
+
+	now := time.Now()
+	entry := models.DiaryEntry{
+		ID:        change.ID,
+		Title:     change.Title,
+		Content:   change.Content,
+		CreatedAt: change.CreatedAt,
+		UpdatedAt: now,
+		Email:     email,
+		Version:   1,
+		SyncSeq:   seq,
+	}
+	if entry.CreatedAt.IsZero() || entry.CreatedAt.After(now) {
+		entry.CreatedAt = now
+	}
+
+	if _, err := diaryCollection.InsertOne(ctx, entry); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			// Taken meanwhile, possibly by another user
+			return models.SyncChangeResult{ID: change.ID, Status: models.SyncStatusError, Message: "Invalid entry id"}
+		}
+		return models.SyncChangeResult{ID: change.ID, Status: models.SyncStatusError, Message: "Failed to create diary entry"}
+	}
+	// The id may have been used by an entry this user deleted earlier. A
+	// tombstone left behind is older than the entry, so clients still end up
+	// with the entry; only the pointless deletion would be synced.
+	if _, err := tombstoneCollection.DeleteOne(ctx, bson.M{"_id": tombstoneID(email, change.ID)}); err != nil {
+		log.Printf("Failed to clear tombstone for %s: %v", change.ID, err)
+	}
+
+	publishEntryEvent(email, models.EventEntryCreated, entry.ID, &entry)
+	onEntrySaved(entry)
+	return models.SyncChangeResult{ID: entry.ID, Status: models.SyncStatusApplied, Version: entry.Version}
+}
+
+func updateSyncedEntry(ctx context.Context, email string, change models.SyncChange, existing *models.DiaryEntry) models.SyncChangeResult {
+	if existing.Version != change.BaseVersion {
+		return models.SyncChangeResult{ID: change.ID, Status: models.SyncStatusConflict, ServerEntry: existing, Version: existing.Version}
+	}
+
+	seq, err := nextSyncSeq(ctx, email)
+	if err != nil {
+		return models.SyncChangeResult{ID: change.ID, Status: models.SyncStatusError, Message: "Database error"}
+	}
+	defer finishSyncWrite(email, seq)
+
+	filter := bson.M{"_id": change.ID, "email": email, "version": change.BaseVersion}
+	update := bson.M{
+		"$set": bson.M{
+			"title":     change.Title,
+			"content":   change.Content,
+			"updatedAt": time.Now(),
+			"syncSeq":   seq,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+
+	var updated models.DiaryEntry
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = diaryCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		// Changed by another request between the lookup and the update
+		return conflictWithCurrent(ctx, email, change.ID)
+	} else if err != nil {
+		return models.SyncChangeResult{ID: change.ID, Status: models.SyncStatusError, Message: "Failed to update diary entry"}
+	}
+
+	invalidateStaleSummary(ctx, &updated)
+	publishEntryEvent(email, models.EventEntryUpdated, updated.ID, &updated)
+	onEntrySaved(updated)
+	return models.SyncChangeResult{ID: updated.ID, Status: models.SyncStatusApplied, Version: updated.Version}
+}
+
+func deleteSyncedEntry(ctx context.Context, email string, change models.SyncChange, existing *models.DiaryEntry) models.SyncChangeResult {
+	if existing.Version != change.BaseVersion {
+		return models.SyncChangeResult{ID: change.ID, Status: models.SyncStatusConflict, ServerEntry: existing, Version: existing.Version}
+	}
+
+	err := deleteEntry(ctx, email, bson.M{"_id": change.ID, "version": change.BaseVersion})
+	if err == mongo.ErrNoDocuments || errors.Is(err, errEntryChanged) {
+		// Changed by another request since the lookup
+		return conflictWithCurrent(ctx, email, change.ID)
+	} else if err != nil {
+		log.Printf("Sync delete of %s failed: %v", change.ID, err)
+		return models.SyncChangeResult{ID: change.ID, Status: models.SyncStatusError, Message: "Failed to delete diary entry"}
+	}
+	return models.SyncChangeResult{ID: change.ID, Status: models.SyncStatusApplied, Version: existing.Version + 1}
+}
+
+// conflictWithCurrent reports a conflict carrying the latest server copy.
+// An entry of another user is reported as deleted, never returned.
+func conflictWithCurrent(ctx context.Context, email, id string) models.SyncChangeResult {
+	var current models.DiaryEntry
+	if err := diaryCollection.FindOne(ctx, bson.M{"_id": id, "email": email}).Decode(&current); err != nil {
+		return models.SyncChangeResult{ID: id, Status: models.SyncStatusConflict, Message: "Entry was deleted on the server"}
+	}
+	return models.SyncChangeResult{ID: id, Status: models.SyncStatusConflict, ServerEntry: &current, Version: current.Version}
+}
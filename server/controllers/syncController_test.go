@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"personal-diary/middleware"
+	"personal-diary/models"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPushSyncHidesOtherUsersEntries(t *testing.T) {
+	owner, other := testUser(t), testUser(t)
+	handler := middleware.JwtVerify(http.HandlerFunc(PushSync))
+
+	entry := models.DiaryEntry{
+		ID:        primitive.NewObjectID().Hex(),
+		Title:     "Private",
+		Content:   "Only the owner may read this.",
+		Email:     owner,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		Version:   3,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := diaryCollection.InsertOne(ctx, entry); err != nil {
+		t.Fatalf("insert entry: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change models.SyncChange
+	}{
+		{"update", models.SyncChange{ID: entry.ID, Op: models.SyncOpUpsert, BaseVersion: 1, Title: "Mine now"}},
+		{"update at the current version", models.SyncChange{ID: entry.ID, Op: models.SyncOpUpsert, BaseVersion: 3, Title: "Mine now"}},
+		{"delete", models.SyncChange{ID: entry.ID, Op: models.SyncOpDelete, BaseVersion: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/sync", encryptedBody(t, models.SyncPushRequest{Changes: []models.SyncChange{tt.change}}))
+			authorize(t, req, other)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			resp := decodeResponse(t, rec)
+			if resp.Status != "success" {
+				t.Fatalf("status %q: %s", resp.Status, resp.Message)
+			}
+			var data struct {
+				Results []models.SyncChangeResult `json:"results"`
+			}
+			if err := json.Unmarshal(resp.Data, &data); err != nil {
+				t.Fatalf("unmarshal results: %v", err)
+			}
+			if len(data.Results) != 1 {
+				t.Fatalf("got %d results, want 1", len(data.Results))
+			}
+			if result := data.Results[0]; result.ServerEntry != nil || result.Status == models.SyncStatusApplied {
+				t.Errorf("result = %+v, want neither the entry nor a change to it", result)
+			}
+		})
+	}
+
+	// The lookup after a lost race must not find the entry either
+	if result := conflictWithCurrent(ctx, other, entry.ID); result.ServerEntry != nil {
+		t.Errorf("conflictWithCurrent returned the owner's entry: %+v", result.ServerEntry)
+	}
+	if result := conflictWithCurrent(ctx, owner, entry.ID); result.ServerEntry == nil || result.Version != entry.Version {
+		t.Errorf("conflictWithCurrent for the owner = %+v, want the entry", result)
+	}
+}
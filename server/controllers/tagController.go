@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"personal-diary/config"
+	"personal-diary/models"
+	"personal-diary/services"
+	"personal-diary/utils"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var tagPreferenceCollection *mongo.Collection = config.GetCollection("tag_preferences")
+
+const (
+	maxSuggestedTags = 5
+	// maxVocabularyTags bounds how much of the user's vocabulary is loaded
+	maxVocabularyTags = 200
+)
+
+// tagQueue suggests tags for new entries in the background
+var tagQueue = newBackgroundQueue("tag suggestion", 2, 256)
+
+// suggestTagsAsync suggests tags for a new entry in the background and
+// stores them, unless the entry was edited in the meantime. Clients learn of
+// them through an entry update event.
+func suggestTagsAsync(entry models.DiaryEntry) {
+	if entry.Version != 1 || len(entry.SuggestedTags) > 0 {
+		return
+	}
+
+	tagQueue.Submit(func() {
+		ctx, cancel := context.WithTimeout(utils.WithUserEmail(context.Background(), entry.Email), time.Minute)
+		defer cancel()
+
+		profile, err := loadTagProfile(ctx, entry.Email)
+		if err != nil {
+			log.Printf("Failed to load tag profile of %s: %v", entry.Email, err)
+		}
+		suggested, promptVersion := services.SuggestTags(ctx, llmProvider, entry, profile, maxSuggestedTags)
+		if len(suggested) == 0 {
+			return
+		}
+
+		filter := bson.M{"_id": entry.ID, "email": entry.Email, "version": entry.Version}
+		update := bson.M{"$set": bson.M{"suggestedTags": suggested, "suggestedTagsPromptVersion": promptVersion}}
+		var updated models.DiaryEntry
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = diaryCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+		if err == mongo.ErrNoDocuments {
+			return
+		} else if err != nil {
+			log.Printf("Failed to store suggested tags of %s: %v", entry.ID, err)
+			return
+		}
+		publishEntryEvent(entry.Email, models.EventEntryUpdated, updated.ID, &updated)
+	})
+}
+
+// loadTagProfile collects the user's tag vocabulary and suggestion history
+func loadTagProfile(ctx context.Context, email string) (services.TagProfile, error) {
+	profile := services.TagProfile{
+		Accepted: make(map[string]int),
+		Rejected: make(map[string]int),
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"email": email}}},
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: maxVocabularyTags}},
+	}
+	cursor, err := diaryCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return profile, err
+	}
+	var vocabulary []models.TagVocabularyEntry
+	if err := cursor.All(ctx, &vocabulary); err != nil {
+		return profile, err
+	}
+	for _, entry := range vocabulary {
+		profile.Vocabulary = append(profile.Vocabulary, entry.Tag)
+	}
+
+	cursor, err = tagPreferenceCollection.Find(ctx, bson.M{"email": email})
+	if err != nil {
+		return profile, err
+	}
+	var preferences []models.TagPreference
+	if err := cursor.All(ctx, &preferences); err != nil {
+		return profile, err
+	}
+	for _, preference := range preferences {
+		profile.Accepted[preference.Tag] = preference.Accepted
+		profile.Rejected[preference.Tag] = preference.Rejected
+	}
+	return profile, nil
+}
+
+// AcceptSuggestedTags moves suggested tags onto the entry's applied tags
+func AcceptSuggestedTags(w http.ResponseWriter, r *http.Request) {
+	decideSuggestedTags(w, r, true)
+}
+
+// RejectSuggestedTags discards suggested tags from the entry
+func RejectSuggestedTags(w http.ResponseWriter, r *http.Request) {
+	decideSuggestedTags(w, r, false)
+}
+
+// decideSuggestedTags accepts or rejects some or all of an entry's suggested
+// tags and records the decision so future suggestions follow it
+func decideSuggestedTags(w http.ResponseWriter, r *http.Request, accept bool) {
+	id := mux.Vars(r)["id"]
+	var req models.TagDecisionRequest
+	payload := models.NewPayload()
+	result := models.NewResponse()
+	if err := payload.DecodePayload(r, &req); err != nil {
+		result.ErrorResponse(w, "Invalid request payload")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	email := getEmailFromHeader(r)
+	var entry models.DiaryEntry
+	err := diaryCollection.FindOne(ctx, bson.M{"_id": id, "email": email}).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		result.ErrorResponse(w, "Diary entry not found")
+		return
+	} else if err != nil {
+		result.ErrorResponse(w, "Failed to fetch diary entry")
+		return
+	}
+
+	// Only tags that are currently suggested can be decided on
+	tags := entry.SuggestedTags
+	if len(req.Tags) > 0 {
+		suggested := make(map[string]bool)
+		for _, tag := range entry.SuggestedTags {
+			suggested[tag] = true
+		}
+		tags = nil
+		for _, tag := range services.NormalizeTags(req.Tags) {
+			if suggested[tag] {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	if len(tags) == 0 {
+		result.ErrorResponse(w, "No matching suggested tags")
+		return
+	}
+
+	seq, err := nextSyncSeq(ctx, email)
+	if err != nil {
+		result.ErrorResponse(w, "Failed to update diary entry")
+		return
+	}
+	defer finishSyncWrite(email, seq)
+
+	update := bson.M{
+		"$pull": bson.M{"suggestedTags": bson.M{"$in": tags}},
+		"$set":  bson.M{"updatedAt": time.Now(), "syncSeq": seq},
+		"$inc":  bson.M{"version": 1},
+	}
+	if accept {
+		update["$addToSet"] = bson.M{"tags": bson.M{"$each": tags}}
+	}
+
+	var updated models.DiaryEntry
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = diaryCollection.FindOneAndUpdate(ctx, bson.M{"_id": id, "email": email}, update, opts).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		result.ErrorResponse(w, "Diary entry not found")
+		return
+	} else if err != nil {
+		result.ErrorResponse(w, "Failed to update diary entry")
+		return
+	}
+	publishEntryEvent(email, models.EventEntryUpdated, updated.ID, &updated)
+
+	if err := recordTagDecision(ctx, email, tags, accept); err != nil {
+		log.Printf("Failed to record tag preferences of %s: %v", email, err)
+	}
+
+	result.SetData(updated)
+	if accept {
+		result.SuccessResponse(w, "Tags accepted successfully")
+	} else {
+		result.SuccessResponse(w, "Tags rejected successfully")
+	}
+}
+
+// recordTagDecision counts an accept or reject for each tag
+func recordTagDecision(ctx context.Context, email string, tags []string, accept bool) error {
+	counter := "rejected"
+	if accept {
+		counter = "accepted"
+	}
+
+	var writes []mongo.WriteModel
+	for _, tag := range tags {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": email + ":" + tag}).
+			SetUpdate(bson.M{
+				"$inc":         bson.M{counter: 1},
+				"$set":         bson.M{"updatedAt": time.Now()},
+				"$setOnInsert": bson.M{"email": email, "tag": tag},
+			}).
+			SetUpsert(true))
+	}
+	_, err := tagPreferenceCollection.BulkWrite(ctx, writes)
+	return err
+}
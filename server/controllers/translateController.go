@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"personal-diary/models"
+	"personal-diary/services"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TranslateEntryHandler returns a translated copy of an entry's title and
+// content. With "save" the translation is also stored as a sibling entry
+// linked to the original.
+func TranslateEntryHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var req models.TranslateRequest
+	payload := models.NewPayload()
+	result := models.NewResponse()
+	if err := payload.DecodePayload(r, &req); err != nil {
+		result.ErrorResponse(w, "Invalid request payload")
+		return
+	}
+
+	target, ok := services.LookupLanguage(req.TargetLanguage)
+	if !ok {
+		result.ErrorResponse(w, "Unsupported target language. Supported: "+strings.Join(services.TranslationLanguageCodes(), ", "))
+		return
+	}
+	source := ""
+	if req.SourceLanguage != "" {
+		if source, ok = services.LookupLanguage(req.SourceLanguage); !ok {
+			result.ErrorResponse(w, "Unsupported source language. Supported: "+strings.Join(services.TranslationLanguageCodes(), ", "))
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	email := getEmailFromHeader(r)
+	var entry models.DiaryEntry
+	err := diaryCollection.FindOne(ctx, bson.M{"_id": id, "email": email}).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		result.ErrorResponse(w, "Diary entry not found")
+		return
+	} else if err != nil {
+		result.ErrorResponse(w, "Failed to fetch diary entry")
+		return
+	}
+	if utf8.RuneCountInString(entry.Content) > services.MaxTranslationChars {
+		result.ErrorResponse(w, "Entry is too long to translate")
+		return
+	}
+
+	translation, err := services.TranslateEntry(r.Context(), llmProvider, entry, source, target)
+	if err != nil {
+		log.Printf("Translating entry %s failed: %v", id, err)
+		aiErrorResponse(w, result, err, "Failed to translate diary entry")
+		return
+	}
+	_, sourceSupported := services.LookupLanguage(translation.SourceLanguage)
+
+	response := models.TranslationResponse{
+		EntryID:         entry.ID,
+		Title:           translation.Title,
+		Content:         translation.Content,
+		SourceLanguage:  translation.SourceLanguage,
+		SourceDetected:  source == "",
+		SourceSupported: sourceSupported,
+		TargetLanguage:  target,
+		PromptVersion:   translation.PromptVersion,
+	}
+
+	if req.Save {
+		saved, err := saveTranslation(entry, translation, target)
+		if err != nil {
+			result.ErrorResponse(w, "Failed to save translation")
+			return
+		}
+		response.SavedEntry = saved
+	}
+
+	result.SetData(response)
+	result.SuccessResponse(w, "Diary entry translated successfully")
+}
+
+// saveTranslation stores a translation as a new entry. Translations of a
+// translation link to the same original, so all siblings share one parent.
+func saveTranslation(original models.DiaryEntry, translation *services.Translation, language string) (*models.DiaryEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	parent := original.ID
+	if original.TranslationOf != "" {
+		parent = original.TranslationOf
+	}
+	entry := models.DiaryEntry{
+		ID:            primitive.NewObjectID().Hex(),
+		Title:         translation.Title,
+		Content:       translation.Content,
+		CreatedAt:     time.Now(),
+		Email:         original.Email,
+		Version:       1,
+		Tags:          original.Tags,
+		TranslationOf: parent,
+		Language:      language,
+	}
+	entry.UpdatedAt = entry.CreatedAt
+
+	seq, err := nextSyncSeq(ctx, entry.Email)
+	if err != nil {
+		return nil, err
+	}
+	defer finishSyncWrite(entry.Email, seq)
+	entry.SyncSeq = seq
+	if _, err := diaryCollection.InsertOne(ctx, entry); err != nil {
+		return nil, err
+	}
+	publishEntryEvent(entry.Email, models.EventEntryCreated, entry.ID, &entry)
+	onEntrySaved(entry)
+	return &entry, nil
+}
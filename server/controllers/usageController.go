@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"personal-diary/config"
+	"personal-diary/models"
+	"personal-diary/services"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var aiUsageCollection *mongo.Collection = config.GetCollection("ai_usage")
+var aiQuotaCollection *mongo.Collection = config.GetCollection("ai_quotas")
+
+// usageMeter limits and records every AI call; llmProvider and embedder are
+// wrapped with it
+var usageMeter = services.NewUsageMeter(mongoUsageStore{})
+
+// mongoUsageStore keeps the AI usage ledger in MongoDB
+type mongoUsageStore struct{}
+
+func (mongoUsageStore) Record(ctx context.Context, record models.AIUsageRecord) error {
+	_, err := aiUsageCollection.InsertOne(ctx, record)
+	return err
+}
+
+func (mongoUsageStore) Totals(ctx context.Context, email string, since time.Time) (models.AIUsageTotals, error) {
+	totals, err := aggregateUsage(ctx, email, since, nil)
+	if err != nil || len(totals) == 0 {
+		return models.AIUsageTotals{}, err
+	}
+	return totals[0].AIUsageTotals, nil
+}
+
+func (mongoUsageStore) Quota(ctx context.Context, email string) (*models.AIQuota, error) {
+	var quota models.AIQuota
+	err := aiQuotaCollection.FindOne(ctx, bson.M{"_id": email}).Decode(&quota)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
+
+// aggregateUsage sums the user's ledger records since the given time,
+// grouped by groupBy (nil for a single total)
+func aggregateUsage(ctx context.Context, email string, since time.Time, groupBy any) ([]models.AIModelUsage, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"email": email, "createdAt": bson.M{"$gte": since}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":              groupBy,
+			"requests":         bson.M{"$sum": 1},
+			"promptTokens":     bson.M{"$sum": "$promptTokens"},
+			"completionTokens": bson.M{"$sum": "$completionTokens"},
+			"totalTokens":      bson.M{"$sum": "$totalTokens"},
+			"images":           bson.M{"$sum": "$images"},
+			"costUsd":          bson.M{"$sum": "$costUsd"},
+			"provider":         bson.M{"$first": "$provider"},
+			"model":            bson.M{"$first": "$model"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "costUsd", Value: -1}, {Key: "totalTokens", Value: -1}}}},
+	}
+	cursor, err := aiUsageCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var usage []models.AIModelUsage
+	if err := cursor.All(ctx, &usage); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// aiErrorResponse reports a failed AI call. An exceeded quota is answered
+// with 429 and says when it resets, an unavailable provider with 503; other
+// errors get the generic message.
+func aiErrorResponse(w http.ResponseWriter, result *models.Response, err error, message string) {
+	var quotaErr *services.QuotaExceededError
+	if errors.As(err, &quotaErr) {
+		result.ErrorResponseWithStatus(w, quotaExceededMessage(quotaErr), http.StatusTooManyRequests)
+		return
+	}
+	if errors.Is(err, services.ErrProviderUnavailable) {
+		result.ErrorResponseWithStatus(w, providerUnavailableMessage, http.StatusServiceUnavailable)
+		return
+	}
+	result.ErrorResponse(w, message)
+}
+
+// providerUnavailableMessage answers requests failed fast by the AI
+// provider's circuit breaker
+const providerUnavailableMessage = "The AI service is temporarily unavailable. Please try again in a minute."
+
+func quotaExceededMessage(err *services.QuotaExceededError) string {
+	return "You have used your " + err.Period + " AI allowance. It resets at " +
+		err.ResetsAt.UTC().Format("Jan 2, 15:04 MST") + "."
+}
+
+// GetAIUsage returns the user's AI usage and limits for the current UTC day
+// and month, and this month's usage per model
+func GetAIUsage(w http.ResponseWriter, r *http.Request) {
+	result := models.NewResponse()
+	email := getEmailFromHeader(r)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	quota, err := usageMeter.Limits(ctx, email)
+	if err != nil {
+		result.ErrorResponse(w, "Failed to fetch AI usage")
+		return
+	}
+	dayStart, monthStart := services.QuotaPeriodStarts(time.Now())
+	daily, err := usageMeter.Totals(ctx, email, dayStart)
+	if err != nil {
+		result.ErrorResponse(w, "Failed to fetch AI usage")
+		return
+	}
+	monthly, err := usageMeter.Totals(ctx, email, monthStart)
+	if err != nil {
+		result.ErrorResponse(w, "Failed to fetch AI usage")
+		return
+	}
+	byModel, err := aggregateUsage(ctx, email, monthStart, bson.M{"provider": "$provider", "model": "$model"})
+	if err != nil {
+		result.ErrorResponse(w, "Failed to fetch AI usage")
+		return
+	}
+	if byModel == nil {
+		byModel = []models.AIModelUsage{}
+	}
+
+	result.SetData(models.AIUsageResponse{
+		Daily: models.AIUsagePeriod{
+			AIUsageTotals: daily,
+			TokenLimit:    quota.DailyTokens,
+			Since:         dayStart,
+			ResetsAt:      dayStart.AddDate(0, 0, 1),
+		},
+		Monthly: models.AIUsagePeriod{
+			AIUsageTotals: monthly,
+			TokenLimit:    quota.MonthlyTokens,
+			Since:         monthStart,
+			ResetsAt:      monthStart.AddDate(0, 1, 0),
+		},
+		ByModel: byModel,
+	})
+	result.SuccessResponse(w, "AI usage fetched successfully")
+}
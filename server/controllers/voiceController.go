@@ -0,0 +1,282 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"personal-diary/middleware"
+	"personal-diary/models"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// maxAudioBytes matches the upload limit of OpenAI's transcription API
+	maxAudioBytes = 25 << 20
+	// maxAudioMemory is how much of an upload is buffered in memory; the
+	// rest goes to a temporary file
+	maxAudioMemory = 8 << 20
+	// attachmentLinkTTL is how long an attachment link works, long enough
+	// to listen to a recording
+	attachmentLinkTTL = time.Hour
+)
+
+var errAttachmentNotFound = errors.New("attachment not found")
+
+// audioContentTypes are the recording formats accepted, by file extension
+var audioContentTypes = map[string]string{
+	".flac": "audio/flac",
+	".m4a":  "audio/mp4",
+	".mp3":  "audio/mpeg",
+	".mp4":  "audio/mp4",
+	".mpga": "audio/mpeg",
+	".oga":  "audio/ogg",
+	".ogg":  "audio/ogg",
+	".wav":  "audio/wav",
+	".webm": "audio/webm",
+}
+
+// attachmentDir is where attachment files are kept, one directory per entry.
+// It is set by ATTACHMENT_DIR and is not served statically.
+func attachmentDir() string {
+	if dir := os.Getenv("ATTACHMENT_DIR"); dir != "" {
+		return dir
+	}
+	return "attachments"
+}
+
+// entryAttachmentDir returns the directory of an entry's attachments, or ""
+// for IDs that are not safe as a directory name
+func entryAttachmentDir(entryID string) string {
+	if entryID == "" || entryID == "." || entryID == ".." || filepath.Base(entryID) != entryID {
+		return ""
+	}
+	return filepath.Join(attachmentDir(), entryID)
+}
+
+// CreateVoiceEntry transcribes an uploaded recording into a new entry that
+// keeps the recording as an attachment. It takes multipart form data: the
+// recording as "audio", and optionally "title" and a "language" hint.
+func CreateVoiceEntry(w http.ResponseWriter, r *http.Request) {
+	result := models.NewResponse()
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAudioBytes+maxAudioMemory)
+	if err := r.ParseMultipartForm(maxAudioMemory); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			result.ErrorResponseWithStatus(w, fmt.Sprintf("Recording is too large. Maximum %d MB allowed.", maxAudioBytes>>20), http.StatusRequestEntityTooLarge)
+			return
+		}
+		result.ErrorResponse(w, "Invalid upload")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("audio")
+	if err != nil {
+		result.ErrorResponse(w, "Audio file is required")
+		return
+	}
+	defer file.Close()
+
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+	contentType, ok := audioContentTypes[ext]
+	if !ok {
+		result.ErrorResponse(w, "Unsupported audio format. Use mp3, m4a, wav, webm, ogg or flac.")
+		return
+	}
+	if header.Size > maxAudioBytes {
+		result.ErrorResponseWithStatus(w, fmt.Sprintf("Recording is too large. Maximum %d MB allowed.", maxAudioBytes>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+	audio, err := io.ReadAll(file)
+	if err != nil {
+		result.ErrorResponse(w, "Failed to read recording")
+		return
+	}
+	if len(audio) == 0 {
+		result.ErrorResponse(w, "Audio file is empty")
+		return
+	}
+
+	transcript, err := transcriber.Transcribe(r.Context(), audio, header.Filename, strings.TrimSpace(r.FormValue("language")))
+	if err != nil {
+		log.Printf("Transcription of %s failed: %v", header.Filename, err)
+		aiErrorResponse(w, result, err, "Failed to transcribe recording")
+		return
+	}
+	if strings.TrimSpace(transcript.Text) == "" {
+		result.ErrorResponse(w, "No speech was found in the recording")
+		return
+	}
+
+	now := time.Now()
+	entry := models.DiaryEntry{
+		ID:        primitive.NewObjectID().Hex(),
+		Title:     strings.TrimSpace(r.FormValue("title")),
+		Content:   transcript.Text,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Email:     getEmailFromHeader(r),
+		Version:   1,
+		Tags:      []string{},
+	}
+	if entry.Title == "" {
+		entry.Title = "Voice note, " + now.Format("January 2, 2006 3:04 PM")
+	}
+	attachment := models.Attachment{
+		ID:          primitive.NewObjectID().Hex(),
+		Kind:        models.AttachmentAudio,
+		Filename:    header.Filename,
+		ContentType: contentType,
+		Size:        int64(len(audio)),
+		Duration:    transcript.Duration,
+		Segments:    transcript.Segments,
+		CreatedAt:   now,
+	}
+	attachment.URL = "/diary/" + entry.ID + "/attachments/" + attachment.ID
+	entry.Attachments = []models.Attachment{attachment}
+
+	dir := entryAttachmentDir(entry.ID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Printf("Failed to create attachment directory: %v", err)
+		result.ErrorResponse(w, "Failed to save recording")
+		return
+	}
+	if err := os.WriteFile(filepath.Join(dir, attachment.ID+ext), audio, 0600); err != nil {
+		log.Printf("Failed to save recording: %v", err)
+		removeEntryAttachments(entry.ID)
+		result.ErrorResponse(w, "Failed to save recording")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	seq, err := nextSyncSeq(ctx, entry.Email)
+	if err != nil {
+		removeEntryAttachments(entry.ID)
+		result.ErrorResponse(w, "Failed to create diary entry")
+		return
+	}
+	defer finishSyncWrite(entry.Email, seq)
+	entry.SyncSeq = seq
+	if _, err := diaryCollection.InsertOne(ctx, entry); err != nil {
+		removeEntryAttachments(entry.ID)
+		result.ErrorResponse(w, "Failed to create diary entry")
+		return
+	}
+	publishEntryEvent(entry.Email, models.EventEntryCreated, entry.ID, &entry)
+	onEntrySaved(entry)
+
+	result.SetData(entry)
+	result.SuccessResponse(w, "Voice note saved successfully")
+}
+
+// GetAttachment serves an attachment of one of the user's entries. Range
+// requests are supported so recordings can be seeked during playback. Besides
+// the Authorization header it accepts a link from CreateAttachmentLink.
+func GetAttachment(w http.ResponseWriter, r *http.Request) {
+	result := models.NewResponse()
+	vars := mux.Vars(r)
+
+	attachment, err := findAttachment(getEmailFromHeader(r), vars["id"], vars["attachmentId"])
+	if err != nil {
+		attachmentErrorResponse(w, result, err)
+		return
+	}
+
+	path := filepath.Join(entryAttachmentDir(vars["id"]), attachment.ID+strings.ToLower(filepath.Ext(attachment.Filename)))
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Failed to open attachment %s: %v", path, err)
+		result.ErrorResponseWithStatus(w, "Attachment file is missing", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, attachment.Filename, attachment.CreatedAt, file)
+}
+
+// CreateAttachmentLink returns a URL of an attachment that works without the
+// Authorization header for attachmentLinkTTL, for use in <audio src>
+func CreateAttachmentLink(w http.ResponseWriter, r *http.Request) {
+	result := models.NewResponse()
+	vars := mux.Vars(r)
+	email := getEmailFromHeader(r)
+
+	attachment, err := findAttachment(email, vars["id"], vars["attachmentId"])
+	if err != nil {
+		attachmentErrorResponse(w, result, err)
+		return
+	}
+
+	token, expires, err := middleware.NewLinkToken(email, AttachmentLinkPurpose(vars["id"], attachment.ID), attachmentLinkTTL)
+	if err != nil {
+		result.ErrorResponse(w, "Failed to create attachment link")
+		return
+	}
+
+	result.SetData(models.SignedLink{URL: attachment.URL + "?token=" + url.QueryEscape(token), ExpiresAt: expires})
+	result.SuccessResponse(w, "Attachment link created successfully")
+}
+
+// AttachmentLinkPurpose is the link token purpose that opens one attachment
+func AttachmentLinkPurpose(entryID, attachmentID string) string {
+	return "attachment:" + entryID + "/" + attachmentID
+}
+
+func findAttachment(email, entryID, attachmentID string) (models.Attachment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var entry models.DiaryEntry
+	filter := bson.M{"_id": entryID, "email": email}
+	opts := options.FindOne().SetProjection(bson.M{"attachments": 1})
+	if err := diaryCollection.FindOne(ctx, filter, opts).Decode(&entry); err != nil {
+		return models.Attachment{}, err
+	}
+	for _, attachment := range entry.Attachments {
+		if attachment.ID == attachmentID {
+			return attachment, nil
+		}
+	}
+	return models.Attachment{}, errAttachmentNotFound
+}
+
+// attachmentErrorResponse reports a failed lookup of an attachment
+func attachmentErrorResponse(w http.ResponseWriter, result *models.Response, err error) {
+	switch {
+	case errors.Is(err, errAttachmentNotFound):
+		result.ErrorResponseWithStatus(w, "Attachment not found", http.StatusNotFound)
+	case errors.Is(err, mongo.ErrNoDocuments):
+		result.ErrorResponseWithStatus(w, "Diary entry not found", http.StatusNotFound)
+	default:
+		result.ErrorResponse(w, "Failed to fetch attachment")
+	}
+}
+
+// removeEntryAttachments deletes the files of an entry's attachments
+func removeEntryAttachments(entryID string) {
+	dir := entryAttachmentDir(entryID)
+	if dir == "" {
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("Failed to remove attachments of %s: %v", entryID, err)
+	}
+}
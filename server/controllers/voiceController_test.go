@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"personal-diary/middleware"
+	"personal-diary/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func voiceTestRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.Handle("/diary/voice", middleware.JwtVerify(http.HandlerFunc(CreateVoiceEntry))).Methods("POST")
+	r.Handle("/diary/{id}/attachments/{attachmentId}/link", middleware.JwtVerify(http.HandlerFunc(CreateAttachmentLink))).Methods("POST")
+	r.Handle("/diary/{id}/attachments/{attachmentId}", middleware.LinkTokenVerify(func(r *http.Request) string {
+		vars := mux.Vars(r)
+		return AttachmentLinkPurpose(vars["id"], vars["attachmentId"])
+	})(http.HandlerFunc(GetAttachment))).Methods("GET")
+	return r
+}
+
+// voiceUpload is a multipart request for POST /diary/voice
+func voiceUpload(t *testing.T, filename string, audio []byte, fields map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	if filename != "" {
+		part, err := form.CreateFormFile("audio", filename)
+		if err != nil {
+			t.Fatalf("CreateFormFile: %v", err)
+		}
+		part.Write(audio)
+	}
+	form.Close()
+
+	req := httptest.NewRequest("POST", "/diary/voice", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func TestCreateVoiceEntry(t *testing.T) {
+	email := testUser(t)
+	t.Setenv("ATTACHMENT_DIR", t.TempDir())
+	router := voiceTestRouter()
+
+	// The fake transcriber hears UTF-8 audio as its text, a segment per line
+	audio := []byte("Walked to the lake after work.\nThe water was calm and so was I.")
+	req := voiceUpload(t, "evening.webm", audio, map[string]string{"title": "Evening walk"})
+	authorize(t, req, email)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	resp := decodeResponse(t, rec)
+	if resp.Status != "success" {
+		t.Fatalf("status %q: %s", resp.Status, resp.Message)
+	}
+	var entry models.DiaryEntry
+	if err := json.Unmarshal(resp.Data, &entry); err != nil {
+		t.Fatalf("unmarshal entry: %v", err)
+	}
+	if entry.Title != "Evening walk" || entry.Content != string(audio) {
+		t.Errorf("entry = %q / %q, want the title and the transcript", entry.Title, entry.Content)
+	}
+	if len(entry.Attachments) != 1 {
+		t.Fatalf("got %d attachments, want 1", len(entry.Attachments))
+	}
+	attachment := entry.Attachments[0]
+	if attachment.ContentType != "audio/webm" || attachment.Size != int64(len(audio)) {
+		t.Errorf("attachment = %s, %d bytes; want audio/webm, %d bytes", attachment.ContentType, attachment.Size, len(audio))
+	}
+	if len(attachment.Segments) != 2 || attachment.Segments[1].Start != attachment.Segments[0].End {
+		t.Errorf("segments = %+v, want two consecutive lines", attachment.Segments)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var stored models.DiaryEntry
+	if err := diaryCollection.FindOne(ctx, bson.M{"_id": entry.ID, "email": email}).Decode(&stored); err != nil {
+		t.Fatalf("entry not stored: %v", err)
+	}
+	if stored.SyncSeq == 0 {
+		t.Error("stored entry has no sync sequence number")
+	}
+	saved, err := os.ReadFile(filepath.Join(entryAttachmentDir(entry.ID), attachment.ID+".webm"))
+	if err != nil || !bytes.Equal(saved, audio) {
+		t.Errorf("saved recording = %q, %v; want the upload", saved, err)
+	}
+
+	// The recording plays from a link, without the Authorization header
+	req = httptest.NewRequest("POST", attachment.URL+"/link", nil)
+	authorize(t, req, email)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = decodeResponse(t, rec)
+	var link models.SignedLink
+	if err := json.Unmarshal(resp.Data, &link); err != nil || link.URL == "" {
+		t.Fatalf("link response %q: %s, %v", resp.Status, resp.Message, err)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", link.URL, nil))
+	if played, _ := io.ReadAll(rec.Body); rec.Code != http.StatusOK || !bytes.Equal(played, audio) {
+		t.Errorf("GET %s = %d %q, want the recording", link.URL, rec.Code, played)
+	}
+
+	// A link opens no other attachment
+	other := strings.Replace(link.URL, attachment.ID, "other", 1)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", other, nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET %s = %d, want %d", other, rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestCreateVoiceEntryRejects(t *testing.T) {
+	email := testUser(t)
+	t.Setenv("ATTACHMENT_DIR", t.TempDir())
+	router := voiceTestRouter()
+
+	tests := []struct {
+		name     string
+		filename string
+		audio    []byte
+		want     string
+	}{
+		{"no recording", "", nil, "Audio file is required"},
+		{"unsupported format", "notes.txt", []byte("Hello"), "Unsupported audio format. Use mp3, m4a, wav, webm, ogg or flac."},
+		{"empty recording", "silence.wav", nil, "Audio file is empty"},
+		{"no speech", "silence.wav", []byte(" \n "), "No speech was found in the recording"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := voiceUpload(t, tt.filename, tt.audio, nil)
+			authorize(t, req, email)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			resp := decodeResponse(t, rec)
+			if resp.Status != "error" || resp.Message != tt.want {
+				t.Errorf("got %s %q, want error %q", resp.Status, resp.Message, tt.want)
+			}
+		})
+	}
+}
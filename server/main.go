@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath" // Make sure this is imported
+	"time"
 
+	"personal-diary/config"
+	"personal-diary/controllers"
 	"personal-diary/middleware"
 	"personal-diary/routers"
 
@@ -26,12 +30,31 @@ func init() {
 // It sets up the router, initializes routes, and serves static files.
 func main() {
 	// config.ConnectDB()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := config.PingDB(ctx); err != nil {
+		log.Fatal("MongoDB connection error:", err)
+	}
+	cancel()
+	log.Println("MongoDB connected ✅")
 
 	r := mux.NewRouter()
 
 	// Setup existing routes
 	routers.AuthRouters(r)
 	routers.DiaryRouters(r)
+	routers.DigestRouters(r)
+	routers.EventRouters(r)
+	routers.SyncRouters(r)
+	routers.MeRouters(r)
+	routers.PresetRouters(r)
+	routers.JobRouters(r)
+
+	// Send weekly AI digests to users who opted in
+	controllers.StartWeeklyDigestScheduler()
+	controllers.MigrateSyncSeq()
+	controllers.StartEmbeddingBackfill()
+	controllers.SeedRefinePresets()
+	controllers.StartPromptRegistry()
 
 	// Define the upload directory relative to the server's execution path
 	// This path should point to: your_project_root/personal-diary-frontend/public/uploads
@@ -57,6 +80,9 @@ func main() {
 		log.Fatalf("Failed to initialize Gemini routers: %v", err)
 	}
 
+	// Process queued AI jobs once every job kind is registered
+	controllers.StartJobWorkers()
+
 	// Serve static files from the new uploads directory
 	// This allows the Go server to serve these files directly if needed.
 	// During Vue development, the Vite dev server will also serve files from `public`.
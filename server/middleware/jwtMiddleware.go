@@ -54,7 +54,9 @@ package middleware
 
 import (
 	"net/http"
+	"personal-diary/utils"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
@@ -124,6 +126,18 @@ func JwtVerify(next http.Handler) http.Handler {
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
+		// Link tokens only open the URL they were made for
+		if claims, ok := token.Claims.(jwt.MapClaims); ok && claims["purpose"] != nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		// Expose the user to handlers and the AI usage meter
+		if claims, ok := token.Claims.(jwt.MapClaims); ok {
+			if email, ok := claims["email"].(string); ok {
+				r = r.WithContext(utils.WithUserEmail(r.Context(), email))
+			}
+		}
 
 		next.ServeHTTP(w, r)
 	})
@@ -142,3 +156,54 @@ func RevokeToken(tokenString string) {
 func IsBlacklisted(token string) bool {
 	return BlacklistedTokens[token]
 }
+
+// NewLinkToken signs a short-lived token that only authorizes requests for
+// purpose, such as playing one attachment. It is put in URLs as ?token= for
+// <audio src> and EventSource, which cannot send the Authorization header,
+// so that the session token never appears in a URL.
+func NewLinkToken(email, purpose string, ttl time.Duration) (string, time.Time, error) {
+	expires := time.Now().Add(ttl)
+	claims := jwt.MapClaims{
+		"email":   email,
+		"purpose": purpose,
+		"exp":     expires.Unix(),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(JwtKey)
+	return token, expires, err
+}
+
+// LinkTokenVerify authenticates requests by a token from NewLinkToken in the
+// "token" query parameter, made for purpose(r). Requests with an
+// Authorization header are checked by JwtVerify instead.
+func LinkTokenVerify(purpose func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		verifySession := JwtVerify(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "" {
+				verifySession.ServeHTTP(w, r)
+				return
+			}
+
+			tokenStr := r.URL.Query().Get("token")
+			token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+				return JwtKey, nil
+			})
+			if err != nil || !token.Valid {
+				http.Error(w, "Invalid or expired link", http.StatusUnauthorized)
+				return
+			}
+			claims, _ := token.Claims.(jwt.MapClaims)
+			tokenPurpose, _ := claims["purpose"].(string)
+			email, _ := claims["email"].(string)
+			if tokenPurpose == "" || tokenPurpose != purpose(r) || email == "" {
+				http.Error(w, "Invalid or expired link", http.StatusUnauthorized)
+				return
+			}
+
+			// Handlers read the user from the Authorization header
+			r.Header.Set("Authorization", "Bearer "+tokenStr)
+			r = r.WithContext(utils.WithUserEmail(r.Context(), email))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// EntryChunk is a passage of a diary entry with its embedding. Chunks are
+// what "ask my diary" searches; they are rebuilt whenever the entry changes.
+type EntryChunk struct {
+	ID             string    `bson:"_id"` // entryId:index
+	EntryID        string    `bson:"entryId"`
+	Email          string    `bson:"email"`
+	Index          int       `bson:"index"`
+	Text           string    `bson:"text"`
+	Vector         []float32 `bson:"vector"`
+	Model          string    `bson:"model"`       // embedding model the vector came from
+	ContentHash    string    `bson:"contentHash"` // of the entry title and content
+	EntryTitle     string    `bson:"entryTitle"`
+	EntryCreatedAt time.Time `bson:"entryCreatedAt"`
+}
+
+type AskRequest struct {
+	Question string `json:"question"`
+}
+
+// AskSource is an entry the answer is based on
+type AskSource struct {
+	EntryID   string    `json:"entryId"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"createdAt"`
+	Excerpt   string    `json:"excerpt"`
+	Score     float64   `json:"score"`
+	Link      string    `json:"link"`
+}
+
+type AskResponse struct {
+	Answer        string      `json:"answer"`
+	Sources       []AskSource `json:"sources"`
+	PromptVersion string      `json:"promptVersion,omitempty"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Attachment kinds
+const (
+	AttachmentAudio = "audio"
+)
+
+// Attachment is a file stored with an entry. Files are only served to the
+// entry's owner, from URL.
+type Attachment struct {
+	ID          string `json:"id" bson:"id"`
+	Kind        string `json:"kind" bson:"kind"`
+	Filename    string `json:"filename" bson:"filename"`
+	ContentType string `json:"contentType" bson:"contentType"`
+	Size        int64  `json:"size" bson:"size"`
+	URL         string `json:"url" bson:"url"`
+	// Duration of a recording in seconds, when the provider reports it
+	Duration float64 `json:"duration,omitempty" bson:"duration,omitempty"`
+	// Segments align the transcript of a recording with its playback
+	Segments  []TranscriptSegment `json:"segments,omitempty" bson:"segments,omitempty"`
+	CreatedAt time.Time           `json:"createdAt" bson:"createdAt"`
+}
+
+// TranscriptSegment is a stretch of a recording and what was said in it;
+// Start and End are seconds from the start of the recording
+type TranscriptSegment struct {
+	Start float64 `json:"start" bson:"start"`
+	End   float64 `json:"end" bson:"end"`
+	Text  string  `json:"text" bson:"text"`
+}
@@ -1,17 +1,26 @@
 package models
 
+import "time"
 
 // RefineRequest represents the request structure for text refinement
 type RefineRequest struct {
 	Text    string `json:"text"`
 	Context string `json:"context,omitempty"`
 	Tone    string `json:"tone,omitempty"`
+	// Mode "edits" also returns the changes as a list of TextEdit
+	Mode string `json:"mode,omitempty"`
+	// PresetID refines with a RefinePreset instead of Context
+	PresetID string `json:"presetId,omitempty"`
 }
 
 // RefineResponse represents the response structure
 type RefineResponse struct {
-	RefinedText string `json:"refinedText"`
-	Message     string `json:"message,omitempty"`
+	RefinedText string     `json:"refinedText"`
+	Message     string     `json:"message,omitempty"`
+	Cached      bool       `json:"cached"` // served from the refine cache
+	Edits       []TextEdit `json:"edits,omitempty"`
+	// PromptVersion labels the prompt that produced RefinedText, e.g. "refine@1"
+	PromptVersion string `json:"promptVersion,omitempty"`
 }
 
 // Message for ChatGPT
@@ -26,6 +35,20 @@ type ChatGPTRequest struct {
 	Messages    []Message `json:"messages"`
 	Temperature float64   `json:"temperature"`
 	MaxTokens   int       `json:"max_tokens"`
+	Stream      bool      `json:"stream,omitempty"`
+	// StreamOptions asks for a final usage chunk when streaming
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// Usage is the token accounting of a ChatGPT response
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
 }
 
 // Choice from ChatGPT response
@@ -44,5 +67,57 @@ type APIError struct {
 // ChatGPTResponse full response
 type ChatGPTResponse struct {
 	Choices []Choice  `json:"choices"`
+	Usage   *Usage    `json:"usage,omitempty"`
 	Error   *APIError `json:"error,omitempty"`
 }
+
+// StreamChoice is one choice of a streamed ChatGPT chunk
+type StreamChoice struct {
+	Delta        Message `json:"delta"`
+	FinishReason string  `json:"finish_reason"`
+}
+
+// ChatGPTStreamChunk is one "data:" event of a streaming response
+type ChatGPTStreamChunk struct {
+	Choices []StreamChoice `json:"choices"`
+	Usage   *Usage         `json:"usage,omitempty"` // only on the last chunk
+	Error   *APIError      `json:"error,omitempty"`
+}
+
+// RefineStreamEvent is sent to the browser for each streamed refinement step
+type RefineStreamEvent struct {
+	Delta         string     `json:"delta,omitempty"`
+	RefinedText   string     `json:"refinedText,omitempty"`
+	Message       string     `json:"message,omitempty"`
+	Cached        bool       `json:"cached,omitempty"`
+	Edits         []TextEdit `json:"edits,omitempty"`
+	PromptVersion string     `json:"promptVersion,omitempty"`
+}
+
+// EntrySummary is a generated summary cached on a diary entry. ContentHash
+// identifies the content it was made from, so edits make it stale.
+type EntrySummary struct {
+	Summary       string    `json:"summary" bson:"summary"`
+	KeyPoints     []string  `json:"keyPoints" bson:"keyPoints"`
+	ContentHash   string    `json:"-" bson:"contentHash"`
+	Model         string    `json:"model" bson:"model"`
+	PromptVersion string    `json:"promptVersion,omitempty" bson:"promptVersion,omitempty"` // e.g. "summary@1"
+	GeneratedAt   time.Time `json:"generatedAt" bson:"generatedAt"`
+}
+
+// SummaryRequest selects the date range (YYYY-MM-DD, inclusive) for POST /diary/summary
+type SummaryRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// SummaryResponse is returned by both summary endpoints
+type SummaryResponse struct {
+	Summary       string    `json:"summary"`
+	KeyPoints     []string  `json:"keyPoints"`
+	EntryCount    int       `json:"entryCount"`
+	From          time.Time `json:"from,omitempty"`
+	To            time.Time `json:"to,omitempty"`
+	Cached        bool      `json:"cached"`
+	PromptVersion string    `json:"promptVersion,omitempty"`
+}
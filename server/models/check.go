@@ -0,0 +1,38 @@
+package models
+
+// Annotation categories
+const (
+	AnnotationSpelling = "spelling"
+	AnnotationGrammar  = "grammar"
+	AnnotationClarity  = "clarity"
+)
+
+// Sources of a text check
+const (
+	CheckSourceModel      = "model"
+	CheckSourceDictionary = "dictionary"
+)
+
+// CheckRequest asks for issues in a text without rewriting it
+type CheckRequest struct {
+	Text string `json:"text"`
+}
+
+// Annotation marks an issue in the checked text. Start and End are character
+// offsets; Original is the text between them.
+type Annotation struct {
+	Start        int      `json:"start"`
+	End          int      `json:"end"`
+	Original     string   `json:"original"`
+	Category     string   `json:"category"`
+	Message      string   `json:"message"`
+	Replacements []string `json:"replacements"`
+}
+
+// CheckResponse lists the annotations in text order. Source says whether the
+// model or the offline dictionary produced them.
+type CheckResponse struct {
+	Annotations   []Annotation `json:"annotations"`
+	Source        string       `json:"source"`
+	PromptVersion string       `json:"promptVersion,omitempty"`
+}
@@ -202,6 +202,12 @@ func (r *Response) SuccessResponse(w http.ResponseWriter, message string) error
 }
 
 func (r *Response) ErrorResponse(w http.ResponseWriter, message string) error {
+	return r.ErrorResponseWithStatus(w, message, http.StatusOK)
+}
+
+// ErrorResponseWithStatus is ErrorResponse with an HTTP status code, for
+// errors clients need to tell apart such as an exceeded AI quota
+func (r *Response) ErrorResponseWithStatus(w http.ResponseWriter, message string, statusCode int) error {
 	r.Status = "error"
 	if message == "" {
 		message = "error"
@@ -218,10 +224,12 @@ func (r *Response) ErrorResponse(w http.ResponseWriter, message string) error {
 			return err
 		}
 		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
 		_, err = w.Write([]byte(encrypted))
 		return err
 	} else {
 		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
 		if err := json.NewEncoder(w).Encode(r); err != nil {
 			return err
 		}
@@ -230,6 +238,19 @@ func (r *Response) ErrorResponse(w http.ResponseWriter, message string) error {
 	return nil
 }
 
+// EncodeData marshals data the same way response bodies are sent, encrypting
+// it when payload encryption is enabled. It is used for streamed messages.
+func EncodeData(data any) (string, error) {
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	if IsEncrypted {
+		return utils.EncryptAES(string(dataBytes))
+	}
+	return string(dataBytes), nil
+}
+
 func (p *Payload) SetStatus(status string) {
 	p.Status = status
 }
@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// Conversation is a reflective thread with the journaling companion about
+// one diary entry
+type Conversation struct {
+	ID       string                `json:"id" bson:"_id"`
+	Email    string                `json:"-" bson:"email"`
+	EntryID  string                `json:"entryId" bson:"entryId"`
+	Messages []ConversationMessage `json:"messages" bson:"messages"`
+	// SavedEntryID is the entry the thread was last saved as
+	SavedEntryID string    `json:"savedEntryId,omitempty" bson:"savedEntryId,omitempty"`
+	CreatedAt    time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// ConversationMessage is a Message of a thread; Role is "user" or
+// "assistant", the companion
+type ConversationMessage struct {
+	Message       `bson:",inline"`
+	PromptVersion string    `json:"promptVersion,omitempty" bson:"promptVersion,omitempty"`
+	CreatedAt     time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// ConversationRequest starts a thread or adds the user's message to one.
+// Starting with an empty message lets the companion open the thread.
+type ConversationRequest struct {
+	Message string `json:"message"`
+}
+
+// ConversationResponse is a thread after the companion replied. Trimmed
+// counts the earlier messages the companion could not see this turn.
+type ConversationResponse struct {
+	Conversation *Conversation `json:"conversation"`
+	Trimmed      int           `json:"trimmed"`
+}
+
+// SaveConversationRequest saves a thread as a new entry; Title defaults to
+// one based on the discussed entry
+type SaveConversationRequest struct {
+	Title string `json:"title,omitempty"`
+}
@@ -7,5 +7,24 @@ type DiaryEntry struct {
 	Title     string    `json:"title" bson:"title"`
 	Content   string    `json:"content" bson:"content"`
 	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
-	Email     string    `json:"email" bson:"email"` // owner
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+	Email     string    `json:"email" bson:"email"`     // owner
+	Version   int64     `json:"version" bson:"version"` // incremented on every change, used for sync conflicts
+	SyncSeq   int64     `json:"-" bson:"syncSeq"`       // per-user change sequence behind sync tokens
+
+	Tags          []string `json:"tags" bson:"tags"`                                       // applied by the user
+	SuggestedTags []string `json:"suggestedTags,omitempty" bson:"suggestedTags,omitempty"` // awaiting accept or reject
+	// SuggestedTagsPromptVersion labels the prompt of model suggestions, e.g. "tags@1"
+	SuggestedTagsPromptVersion string `json:"suggestedTagsPromptVersion,omitempty" bson:"suggestedTagsPromptVersion,omitempty"`
+
+	// Translations are saved as sibling entries pointing at the original
+	TranslationOf string `json:"translationOf,omitempty" bson:"translationOf,omitempty"`
+	Language      string `json:"language,omitempty" bson:"language,omitempty"`
+	// Conversations saved as entries point at the entry they discuss
+	ReflectionOf string `json:"reflectionOf,omitempty" bson:"reflectionOf,omitempty"`
+	// Voice notes keep their recording, with the transcript as Content
+	Attachments []Attachment `json:"attachments,omitempty" bson:"attachments,omitempty"`
+
+	Summary *EntrySummary `json:"summary,omitempty" bson:"summary,omitempty"`
+	Mood    *MoodAnalysis `json:"mood,omitempty" bson:"mood,omitempty"`
 }
@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// DigestSettings stores a user's opt-in for the weekly AI digest email
+type DigestSettings struct {
+	Email       string    `json:"email" bson:"_id"`
+	Enabled     bool      `json:"enabled" bson:"enabled"`
+	DeliveryDay string    `json:"deliveryDay" bson:"deliveryDay"` // lower-case weekday, e.g. "sunday"
+	LastRunAt   time.Time `json:"lastRunAt,omitempty" bson:"lastRunAt,omitempty"`
+	LastSentAt  time.Time `json:"lastSentAt,omitempty" bson:"lastSentAt,omitempty"`
+	// LastAttemptAt and FailedAttempts track failed runs on the delivery day
+	LastAttemptAt  time.Time `json:"lastAttemptAt,omitempty" bson:"lastAttemptAt,omitempty"`
+	FailedAttempts int       `json:"failedAttempts,omitempty" bson:"failedAttempts,omitempty"`
+	UpdatedAt      time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// DigestSettingsRequest is the payload accepted by PUT /digest/settings
+type DigestSettingsRequest struct {
+	Enabled     bool   `json:"enabled"`
+	DeliveryDay string `json:"deliveryDay"`
+}
+
+// DigestStats holds the writing statistics shown in the weekly digest
+type DigestStats struct {
+	EntryCount        int `json:"entryCount"`
+	DaysWritten       int `json:"daysWritten"`
+	TotalWords        int `json:"totalWords"`
+	AverageWords      int `json:"averageWords"`
+	LongestEntryWords int `json:"longestEntryWords"`
+}
+
+// WeeklyDigest is the generated content of one digest email
+type WeeklyDigest struct {
+	PeriodStart   time.Time   `json:"periodStart"`
+	PeriodEnd     time.Time   `json:"periodEnd"`
+	Reflection    string      `json:"reflection"`
+	Highlights    []string    `json:"highlights"`
+	MoodTrend     string      `json:"moodTrend"`
+	Stats         DigestStats `json:"stats"`
+	PromptVersion string      `json:"promptVersion,omitempty"`
+}
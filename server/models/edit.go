@@ -0,0 +1,39 @@
+package models
+
+// Edit operation types
+const (
+	EditInsert  = "insert"
+	EditDelete  = "delete"
+	EditReplace = "replace"
+)
+
+// RefineModeEdits asks the refine endpoints for reviewable edits alongside
+// the refined text
+const RefineModeEdits = "edits"
+
+// TextEdit is one change between an original and a refined text. Start and
+// End are character offsets into the original text; an insert has
+// Start == End.
+type TextEdit struct {
+	ID          int    `json:"id"`
+	Type        string `json:"type"`
+	Start       int    `json:"start"`
+	End         int    `json:"end"`
+	Original    string `json:"original"`
+	Replacement string `json:"replacement"`
+	Reason      string `json:"reason"`
+}
+
+// ApplyEditsRequest carries the original text, the edits returned for it and
+// the IDs of the edits the user accepted
+type ApplyEditsRequest struct {
+	Text     string     `json:"text"`
+	Edits    []TextEdit `json:"edits"`
+	Accepted []int      `json:"accepted"`
+}
+
+// ApplyEditsResponse is the text with the accepted edits applied
+type ApplyEditsResponse struct {
+	Text    string `json:"text"`
+	Applied int    `json:"applied"`
+}
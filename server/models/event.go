@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Diary event types pushed to clients over GET /events
+const (
+	EventEntryCreated = "entry.created"
+	EventEntryUpdated = "entry.updated"
+	EventEntryDeleted = "entry.deleted"
+)
+
+// DiaryEvent describes a change to one of the user's diary entries
+type DiaryEvent struct {
+	ID         uint64      `json:"id"`
+	Type       string      `json:"type"`
+	EntryID    string      `json:"entryId"`
+	Entry      *DiaryEntry `json:"entry,omitempty"` // nil for deletions
+	OccurredAt time.Time   `json:"occurredAt"`
+}
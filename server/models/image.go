@@ -6,9 +6,15 @@ package models
 
 // Enhanced response structure that includes both full path and URL
 type BackgroundImageData struct {
-	ImageURL    string `json:"imageUrl"`  // URL path for web access
-	ImagePath   string `json:"imagePath"` // Full file system path
-	Prompt      string `json:"prompt"`
+	ImageURL  string `json:"imageUrl"`  // URL path for web access
+	ImagePath string `json:"imagePath"` // Full file system path
+	Prompt    string `json:"prompt"`
+	// PromptVersion labels the prompt that produced Prompt
+	PromptVersion string `json:"promptVersion,omitempty"`
+	// ImageModel generated the image; "procedural" for the offline renderer
+	ImageModel string `json:"imageModel,omitempty"`
+	// Seed renders the same procedural background again
+	Seed        int64  `json:"seed"`
 	GeneratedAt string `json:"generatedAt"`
 }
 
@@ -21,4 +27,8 @@ type BackgroundImageResponse struct {
 type BackgroundImageRequest struct {
 	Content string `json:"content"`
 	Title   string `json:"title,omitempty"`
+	// EntryID lends the entry's analysed mood to the procedural palette
+	EntryID string `json:"entryId,omitempty"`
+	// Seed repeats a procedural background; by default it follows the text
+	Seed int64 `json:"seed,omitempty"`
 }
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AI job kinds
+const (
+	JobRefine     = "refine"
+	JobBackground = "background"
+)
+
+// AI job states. Queued jobs wait for a worker, also between retries;
+// succeeded, failed and cancelled are final.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job is an AI request processed in the background. Input is the request
+// of its kind, e.g. a RefineRequest, and Result the matching response.
+type Job struct {
+	ID              string          `json:"id" bson:"_id"`
+	Email           string          `json:"-" bson:"email"`
+	Kind            string          `json:"kind" bson:"kind"`
+	Status          string          `json:"status" bson:"status"`
+	Progress        int             `json:"progress" bson:"progress"` // percent
+	Input           json.RawMessage `json:"-" bson:"input"`
+	Result          json.RawMessage `json:"result,omitempty" bson:"result,omitempty"`
+	Error           string          `json:"error,omitempty" bson:"error,omitempty"`
+	Attempts        int             `json:"attempts" bson:"attempts"`
+	MaxAttempts     int             `json:"maxAttempts" bson:"maxAttempts"`
+	CancelRequested bool            `json:"cancelRequested,omitempty" bson:"cancelRequested,omitempty"`
+	// RunAfter is when a queued job may start, later than CreatedAt while it
+	// waits to be retried
+	RunAfter time.Time `json:"runAfter" bson:"runAfter"`
+	// Worker and LeaseUntil record the worker running the job. A job whose
+	// lease ran out, because its server stopped, is picked up again.
+	Worker     string     `json:"-" bson:"worker,omitempty"`
+	LeaseUntil *time.Time `json:"-" bson:"leaseUntil,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt" bson:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt" bson:"updatedAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty" bson:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty" bson:"finishedAt,omitempty"`
+}
+
+// JobRequest submits a job; Input is the request body of its kind
+type JobRequest struct {
+	Kind  string          `json:"kind"`
+	Input json.RawMessage `json:"input"`
+}
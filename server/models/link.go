@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// SignedLink is a URL that works without the Authorization header until
+// ExpiresAt, for browser features that cannot send headers
+type SignedLink struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Mood analysis sources
+const (
+	MoodSourceLLM     = "llm"
+	MoodSourceLexicon = "lexicon"
+)
+
+// MoodAnalysis is the sentiment stored on a diary entry
+type MoodAnalysis struct {
+	Score         float64   `json:"score" bson:"score"`       // -1 (very negative) to 1 (very positive)
+	Emotions      []string  `json:"emotions" bson:"emotions"` // dominant emotions, strongest first
+	Energy        float64   `json:"energy" bson:"energy"`     // 0 (drained, calm) to 1 (energetic, agitated)
+	Source        string    `json:"source" bson:"source"`
+	Model         string    `json:"model,omitempty" bson:"model,omitempty"`
+	PromptVersion string    `json:"promptVersion,omitempty" bson:"promptVersion,omitempty"` // of LLM analyses, e.g. "mood@1"
+	ContentHash   string    `json:"-" bson:"contentHash"`
+	AnalyzedAt    time.Time `json:"analyzedAt" bson:"analyzedAt"`
+}
+
+// MoodTrendPoint aggregates the analysed entries of one day or week
+type MoodTrendPoint struct {
+	Period        string    `json:"period"` // "2006-01-02" for days, "2006-W01" for ISO weeks
+	Start         time.Time `json:"start"`
+	EntryCount    int       `json:"entryCount"`
+	AverageScore  float64   `json:"averageScore"`
+	AverageEnergy float64   `json:"averageEnergy"`
+	TopEmotions   []string  `json:"topEmotions"`
+}
+
+// MoodTrendsResponse is returned by GET /diary/mood-trends
+type MoodTrendsResponse struct {
+	From   time.Time        `json:"from"`
+	To     time.Time        `json:"to"`
+	Daily  []MoodTrendPoint `json:"daily"`
+	Weekly []MoodTrendPoint `json:"weekly"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// RefinePreset is a named set of refinement instructions. System presets
+// cover the built-in writing contexts, have no owner and cannot be changed.
+type RefinePreset struct {
+	ID           string  `json:"id" bson:"_id"`
+	Email        string  `json:"-" bson:"email,omitempty"`
+	Name         string  `json:"name" bson:"name"`
+	Instructions string  `json:"instructions" bson:"instructions"`
+	Tone         string  `json:"tone,omitempty" bson:"tone,omitempty"`
+	Temperature  float64 `json:"temperature" bson:"temperature"`
+	// MaxLength caps the refined text in characters; 0 leaves it to the model
+	MaxLength int `json:"maxLength" bson:"maxLength"`
+	// Context is the built-in writing context a system preset stands for
+	Context   string    `json:"context,omitempty" bson:"context,omitempty"`
+	System    bool      `json:"system" bson:"system"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// RefinePresetRequest creates or updates a user's preset. A nil Temperature
+// uses the default.
+type RefinePresetRequest struct {
+	Name         string   `json:"name"`
+	Instructions string   `json:"instructions"`
+	Tone         string   `json:"tone"`
+	Temperature  *float64 `json:"temperature"`
+	MaxLength    int      `json:"maxLength"`
+}
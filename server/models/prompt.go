@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// PromptTemplate is a prompt version stored in MongoDB. A document with a
+// Template adds a new version; one without only sets the Weight of a version
+// shipped with the server. Versions with a positive weight share traffic in
+// proportion to it; when none has one the newest shipped version is used.
+type PromptTemplate struct {
+	Name      string    `json:"name" bson:"name"`
+	Version   int       `json:"version" bson:"version"`
+	Template  string    `json:"template,omitempty" bson:"template,omitempty"`
+	Weight    int       `json:"weight" bson:"weight"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+}
@@ -0,0 +1,65 @@
+package models
+
+import "time"
+
+// Kinds of personal information redacted before text is sent to an AI
+// provider
+const (
+	RedactionEmail   = "email"
+	RedactionPhone   = "phone"
+	RedactionAddress = "address"
+	RedactionCard    = "card"
+	RedactionName    = "name"
+)
+
+// RedactionSettings control redaction for a user. Redaction is on unless the
+// user turned it off; Names are the people and places the user wants hidden.
+type RedactionSettings struct {
+	Email     string    `json:"-" bson:"_id"`
+	Enabled   bool      `json:"enabled" bson:"enabled"`
+	Names     []string  `json:"names" bson:"names"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty" bson:"updatedAt"`
+}
+
+// RedactionSettingsRequest updates redaction settings; nil fields are kept
+type RedactionSettingsRequest struct {
+	Enabled *bool    `json:"enabled,omitempty"`
+	Names   []string `json:"names,omitempty"`
+}
+
+// RedactedItem is one value replaced by a placeholder such as "[EMAIL_1]"
+type RedactedItem struct {
+	Placeholder string `json:"placeholder" bson:"placeholder"`
+	Kind        string `json:"kind" bson:"kind"`
+	Value       string `json:"value" bson:"value"`
+}
+
+// RedactionLogItem is a RedactedItem as it is logged: the value itself is
+// never stored, only a masked hint such as "•••• 4242" or "j•••@example.com"
+type RedactionLogItem struct {
+	Placeholder string `json:"placeholder" bson:"placeholder"`
+	Kind        string `json:"kind" bson:"kind"`
+	Hint        string `json:"hint" bson:"hint"`
+}
+
+// RedactionRecord lists what was redacted from one AI call
+type RedactionRecord struct {
+	ID        string             `json:"id" bson:"_id,omitempty"`
+	Email     string             `json:"-" bson:"email"`
+	Provider  string             `json:"provider" bson:"provider"`
+	Model     string             `json:"model" bson:"model"`
+	Items     []RedactionLogItem `json:"items" bson:"items"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// RedactionPreviewRequest asks what would be redacted from Text
+type RedactionPreviewRequest struct {
+	Text string `json:"text"`
+}
+
+// RedactionPreviewResponse is Text as it would be sent to a provider
+type RedactionPreviewResponse struct {
+	Enabled      bool           `json:"enabled"`
+	RedactedText string         `json:"redactedText"`
+	Items        []RedactedItem `json:"items"`
+}
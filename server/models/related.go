@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// RelatedEntry is a past entry similar to the one being viewed
+type RelatedEntry struct {
+	EntryID   string    `json:"entryId"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"createdAt"`
+	Score     float64   `json:"score"` // cosine similarity, 1 is identical
+}
@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+// Sync operations accepted by POST /sync
+const (
+	SyncOpUpsert = "upsert"
+	SyncOpDelete = "delete"
+)
+
+// Per-change outcomes reported by POST /sync
+const (
+	SyncStatusApplied  = "applied"
+	SyncStatusConflict = "conflict"
+	SyncStatusError    = "error"
+)
+
+// DiaryTombstone records a deleted entry so offline clients learn about it
+type DiaryTombstone struct {
+	ID        string    `json:"-" bson:"_id"` // email + ":" + entry id
+	EntryID   string    `json:"id" bson:"entryId"`
+	Email     string    `json:"-" bson:"email"`
+	Version   int64     `json:"version" bson:"version"`
+	DeletedAt time.Time `json:"deletedAt" bson:"deletedAt"`
+	SyncSeq   int64     `json:"-" bson:"syncSeq"`
+}
+
+// SyncPullResponse is returned by GET /sync
+type SyncPullResponse struct {
+	Entries   []DiaryEntry     `json:"entries"`
+	Deleted   []DiaryTombstone `json:"deleted"`
+	SyncToken string           `json:"syncToken"`
+	HasMore   bool             `json:"hasMore"`
+}
+
+// SyncChange is one offline edit. ID is generated by the client for new
+// entries; BaseVersion is the server version the edit was made against
+// (0 for entries created offline).
+type SyncChange struct {
+	ID          string    `json:"id"`
+	Op          string    `json:"op"`
+	BaseVersion int64     `json:"baseVersion"`
+	Title       string    `json:"title,omitempty"`
+	Content     string    `json:"content,omitempty"`
+	CreatedAt   time.Time `json:"createdAt,omitempty"`
+}
+
+// SyncPushRequest is the payload of POST /sync
+type SyncPushRequest struct {
+	Changes []SyncChange `json:"changes"`
+}
+
+// SyncChangeResult reports what happened to one pushed change. On conflict
+// ServerEntry holds the current server copy, or is nil when the entry was
+// deleted on the server.
+type SyncChangeResult struct {
+	ID          string      `json:"id"`
+	Status      string      `json:"status"`
+	Version     int64       `json:"version,omitempty"`
+	ServerEntry *DiaryEntry `json:"serverEntry,omitempty"`
+	Message     string      `json:"message,omitempty"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// TagPreference counts how often a user accepted or rejected a suggested tag
+type TagPreference struct {
+	ID        string    `json:"-" bson:"_id"` // email:tag
+	Email     string    `json:"-" bson:"email"`
+	Tag       string    `json:"tag" bson:"tag"`
+	Accepted  int       `json:"accepted" bson:"accepted"`
+	Rejected  int       `json:"rejected" bson:"rejected"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// TagDecisionRequest lists the suggested tags to accept or reject. An empty
+// list applies the decision to all current suggestions.
+type TagDecisionRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// TagVocabularyEntry is one of the user's applied tags with its usage count
+type TagVocabularyEntry struct {
+	Tag   string `json:"tag" bson:"_id"`
+	Count int    `json:"count" bson:"count"`
+}
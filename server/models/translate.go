@@ -0,0 +1,25 @@
+package models
+
+// TranslateRequest asks for a translation of an entry. SourceLanguage is
+// optional and detected when empty; Save stores the translation as a new
+// entry linked to the original.
+type TranslateRequest struct {
+	TargetLanguage string `json:"targetLanguage"`
+	SourceLanguage string `json:"sourceLanguage,omitempty"`
+	Save           bool   `json:"save"`
+}
+
+// TranslationResponse is a translated copy of an entry. SourceDetected is
+// set when the source language came from the model rather than the request,
+// and SourceSupported is false when it is not one the app knows.
+type TranslationResponse struct {
+	EntryID         string      `json:"entryId"`
+	Title           string      `json:"title"`
+	Content         string      `json:"content"`
+	SourceLanguage  string      `json:"sourceLanguage"`
+	SourceDetected  bool        `json:"sourceDetected"`
+	SourceSupported bool        `json:"sourceSupported"`
+	TargetLanguage  string      `json:"targetLanguage"`
+	PromptVersion   string      `json:"promptVersion,omitempty"`
+	SavedEntry      *DiaryEntry `json:"savedEntry,omitempty"`
+}
@@ -0,0 +1,69 @@
+package models
+
+import "time"
+
+// AI usage kinds recorded in the ledger
+const (
+	AIUsageChat          = "chat"
+	AIUsageEmbedding     = "embedding"
+	AIUsageImage         = "image"
+	AIUsageTranscription = "transcription"
+)
+
+// AIUsageRecord is one metered AI call in the usage ledger
+type AIUsageRecord struct {
+	ID               string    `json:"-" bson:"_id,omitempty"`
+	Email            string    `json:"-" bson:"email"` // empty for calls made outside a user request
+	Kind             string    `json:"kind" bson:"kind"`
+	Provider         string    `json:"provider" bson:"provider"`
+	Model            string    `json:"model" bson:"model"`
+	PromptTokens     int       `json:"promptTokens" bson:"promptTokens"`
+	CompletionTokens int       `json:"completionTokens" bson:"completionTokens"`
+	TotalTokens      int       `json:"totalTokens" bson:"totalTokens"`
+	Images           int       `json:"images,omitempty" bson:"images,omitempty"`
+	CostUSD          float64   `json:"costUsd" bson:"costUsd"`
+	Estimated        bool      `json:"estimated" bson:"estimated"` // token counts estimated from text length
+	PromptVersion    string    `json:"promptVersion,omitempty" bson:"promptVersion,omitempty"`
+	CreatedAt        time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// AIQuota holds a user's token limits; 0 means unlimited. Documents in the
+// ai_quotas collection override the defaults from the environment.
+type AIQuota struct {
+	Email         string    `json:"-" bson:"_id"`
+	DailyTokens   int64     `json:"dailyTokens" bson:"dailyTokens"`
+	MonthlyTokens int64     `json:"monthlyTokens" bson:"monthlyTokens"`
+	UpdatedAt     time.Time `json:"updatedAt,omitempty" bson:"updatedAt"`
+}
+
+// AIUsageTotals sums ledger records
+type AIUsageTotals struct {
+	Requests         int64   `json:"requests" bson:"requests"`
+	PromptTokens     int64   `json:"promptTokens" bson:"promptTokens"`
+	CompletionTokens int64   `json:"completionTokens" bson:"completionTokens"`
+	TotalTokens      int64   `json:"totalTokens" bson:"totalTokens"`
+	Images           int64   `json:"images" bson:"images"`
+	CostUSD          float64 `json:"costUsd" bson:"costUsd"`
+}
+
+// AIUsagePeriod is the usage and limit of one quota period
+type AIUsagePeriod struct {
+	AIUsageTotals `bson:",inline"`
+	TokenLimit    int64     `json:"tokenLimit"` // 0 means unlimited
+	Since         time.Time `json:"since"`
+	ResetsAt      time.Time `json:"resetsAt"`
+}
+
+// AIModelUsage is the usage of one provider and model
+type AIModelUsage struct {
+	Provider      string `json:"provider" bson:"provider"`
+	Model         string `json:"model" bson:"model"`
+	AIUsageTotals `bson:",inline"`
+}
+
+// AIUsageResponse is returned by GET /me/ai-usage
+type AIUsageResponse struct {
+	Daily   AIUsagePeriod  `json:"daily"`
+	Monthly AIUsagePeriod  `json:"monthly"`
+	ByModel []AIModelUsage `json:"byModel"` // this month
+}
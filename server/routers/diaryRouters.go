@@ -1,6 +1,7 @@
 package routers
 
 import (
+	"net/http"
 	"personal-diary/controllers"
 	"personal-diary/middleware"
 
@@ -8,14 +9,43 @@ import (
 )
 
 func DiaryRouters(routers *mux.Router) {
+	// <audio src> cannot send the Authorization header, so attachments also
+	// open with a link from POST .../link
+	routers.Handle("/diary/{id}/attachments/{attachmentId}",
+		middleware.LinkTokenVerify(attachmentLinkPurpose)(http.HandlerFunc(controllers.GetAttachment))).Methods("GET")
+
 	dairyRouter := routers.PathPrefix("/diary").Subrouter()
 	dairyRouter.Use(middleware.JwtVerify)
 
 	dairyRouter.HandleFunc("", controllers.CreateDiary).Methods("POST")
 	dairyRouter.HandleFunc("", controllers.GetAllDiaries).Methods("GET")
+	dairyRouter.HandleFunc("/mood-trends", controllers.GetMoodTrends).Methods("GET")
+	dairyRouter.HandleFunc("/refine/stream", controllers.RefineStreamHandler).Methods("POST")
+	dairyRouter.HandleFunc("/refine/apply", controllers.ApplyRefineEditsHandler).Methods("POST")
+	dairyRouter.HandleFunc("/summary", controllers.SummarizePeriodHandler).Methods("POST")
+	dairyRouter.HandleFunc("/ask", controllers.AskDiary).Methods("POST")
+	dairyRouter.HandleFunc("/check", controllers.CheckTextHandler).Methods("POST")
+	dairyRouter.HandleFunc("/voice", controllers.CreateVoiceEntry).Methods("POST")
+	dairyRouter.HandleFunc("/{id}/summary", controllers.SummarizeEntryHandler).Methods("POST")
+	dairyRouter.HandleFunc("/{id}/related", controllers.GetRelatedEntries).Methods("GET")
+	dairyRouter.HandleFunc("/{id}/translate", controllers.TranslateEntryHandler).Methods("POST")
+	dairyRouter.HandleFunc("/{id}/tags/accept", controllers.AcceptSuggestedTags).Methods("POST")
+	dairyRouter.HandleFunc("/{id}/tags/reject", controllers.RejectSuggestedTags).Methods("POST")
+	dairyRouter.HandleFunc("/{id}/attachments/{attachmentId}/link", controllers.CreateAttachmentLink).Methods("POST")
+	dairyRouter.HandleFunc("/{id}/conversations", controllers.StartConversation).Methods("POST")
+	dairyRouter.HandleFunc("/{id}/conversations", controllers.GetConversations).Methods("GET")
+	dairyRouter.HandleFunc("/{id}/conversations/{conversationId}", controllers.GetConversation).Methods("GET")
+	dairyRouter.HandleFunc("/{id}/conversations/{conversationId}/messages", controllers.PostConversationMessage).Methods("POST")
+	dairyRouter.HandleFunc("/{id}/conversations/{conversationId}/save", controllers.SaveConversation).Methods("POST")
+	dairyRouter.HandleFunc("/{id}", controllers.GetDiary).Methods("GET")
 	dairyRouter.HandleFunc("/{id}", controllers.UpdateDiary).Methods("PUT")
 	dairyRouter.HandleFunc("/{id}", controllers.DeleteDiary).Methods("DELETE")
 	dairyRouter.HandleFunc("/{id}", controllers.RefineTextHandler).Methods("POST")
 	
 
 }
+
+func attachmentLinkPurpose(r *http.Request) string {
+	vars := mux.Vars(r)
+	return controllers.AttachmentLinkPurpose(vars["id"], vars["attachmentId"])
+}
@@ -0,0 +1,16 @@
+package routers
+
+import (
+	"personal-diary/controllers"
+	"personal-diary/middleware"
+
+	"github.com/gorilla/mux"
+)
+
+func DigestRouters(routers *mux.Router) {
+	digestRouter := routers.PathPrefix("/digest").Subrouter()
+	digestRouter.Use(middleware.JwtVerify)
+
+	digestRouter.HandleFunc("/settings", controllers.GetDigestSettings).Methods("GET")
+	digestRouter.HandleFunc("/settings", controllers.UpdateDigestSettings).Methods("PUT")
+}
@@ -0,0 +1,20 @@
+package routers
+
+import (
+	"net/http"
+	"personal-diary/controllers"
+	"personal-diary/middleware"
+
+	"github.com/gorilla/mux"
+)
+
+func EventRouters(routers *mux.Router) {
+	// EventSource cannot set headers, so the stream also opens with a link
+	// from POST /events/link
+	routers.Handle("/events", middleware.LinkTokenVerify(eventsLinkPurpose)(http.HandlerFunc(controllers.StreamEvents))).Methods("GET")
+	routers.Handle("/events/link", middleware.JwtVerify(http.HandlerFunc(controllers.CreateEventsLink))).Methods("POST")
+}
+
+func eventsLinkPurpose(r *http.Request) string {
+	return controllers.EventsLinkPurpose
+}
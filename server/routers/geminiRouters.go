@@ -5,6 +5,7 @@ package routers
 
 import (
 	"personal-diary/controllers"
+	"personal-diary/middleware"
 	"personal-diary/services"
 
 	"github.com/gorilla/mux"
@@ -22,6 +23,7 @@ func GeminiRouters(router *mux.Router, apiKey string, uploadDir string) error {
 
 	// Create subrouter
 	geminiRouter := router.PathPrefix("/gemini").Subrouter()
+	geminiRouter.Use(middleware.JwtVerify)
 
 	// Bind handler
 	geminiRouter.HandleFunc("/generate-background", imageController.GenerateBackground).Methods("POST")
@@ -0,0 +1,18 @@
+package routers
+
+import (
+	"personal-diary/controllers"
+	"personal-diary/middleware"
+
+	"github.com/gorilla/mux"
+)
+
+func JobRouters(routers *mux.Router) {
+	jobRouter := routers.PathPrefix("/jobs").Subrouter()
+	jobRouter.Use(middleware.JwtVerify)
+
+	jobRouter.HandleFunc("", controllers.CreateJob).Methods("POST")
+	jobRouter.HandleFunc("", controllers.GetJobs).Methods("GET")
+	jobRouter.HandleFunc("/{id}", controllers.GetJob).Methods("GET")
+	jobRouter.HandleFunc("/{id}/cancel", controllers.CancelJob).Methods("POST")
+}
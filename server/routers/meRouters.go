@@ -0,0 +1,19 @@
+package routers
+
+import (
+	"personal-diary/controllers"
+	"personal-diary/middleware"
+
+	"github.com/gorilla/mux"
+)
+
+func MeRouters(routers *mux.Router) {
+	meRouter := routers.PathPrefix("/me").Subrouter()
+	meRouter.Use(middleware.JwtVerify)
+
+	meRouter.HandleFunc("/ai-usage", controllers.GetAIUsage).Methods("GET")
+	meRouter.HandleFunc("/redaction", controllers.GetRedactionSettings).Methods("GET")
+	meRouter.HandleFunc("/redaction", controllers.UpdateRedactionSettings).Methods("PUT")
+	meRouter.HandleFunc("/redaction/preview", controllers.PreviewRedaction).Methods("POST")
+	meRouter.HandleFunc("/redactions", controllers.GetRedactions).Methods("GET")
+}
@@ -0,0 +1,18 @@
+package routers
+
+import (
+	"personal-diary/controllers"
+	"personal-diary/middleware"
+
+	"github.com/gorilla/mux"
+)
+
+func PresetRouters(routers *mux.Router) {
+	presetRouter := routers.PathPrefix("/presets").Subrouter()
+	presetRouter.Use(middleware.JwtVerify)
+
+	presetRouter.HandleFunc("", controllers.GetRefinePresets).Methods("GET")
+	presetRouter.HandleFunc("", controllers.CreateRefinePreset).Methods("POST")
+	presetRouter.HandleFunc("/{id}", controllers.UpdateRefinePreset).Methods("PUT")
+	presetRouter.HandleFunc("/{id}", controllers.DeleteRefinePreset).Methods("DELETE")
+}
@@ -0,0 +1,16 @@
+package routers
+
+import (
+	"personal-diary/controllers"
+	"personal-diary/middleware"
+
+	"github.com/gorilla/mux"
+)
+
+func SyncRouters(routers *mux.Router) {
+	syncRouter := routers.PathPrefix("/sync").Subrouter()
+	syncRouter.Use(middleware.JwtVerify)
+
+	syncRouter.HandleFunc("", controllers.PullSync).Methods("GET")
+	syncRouter.HandleFunc("", controllers.PushSync).Methods("POST")
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"personal-diary/models"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// askChunkChars keeps passages small enough that a retrieved chunk is about
+// one topic, yet large enough to carry context (roughly 350 tokens)
+const askChunkChars = 1500
+
+const askExcerptChars = 240
+
+var citationPattern = regexp.MustCompile(`\[(\d+)\]`)
+
+// ScoredChunk is a retrieved chunk with its similarity to the question
+type ScoredChunk struct {
+	Chunk models.EntryChunk
+	Score float64
+}
+
+// entryIndexVersion changes when the embedded text is prepared differently,
+// so that entries indexed before are embedded again. Version 2 has stable
+// redaction placeholders.
+const entryIndexVersion = "2"
+
+// EntryIndexHash fingerprints the parts of an entry that are embedded, so
+// unchanged entries are not embedded again
+func EntryIndexHash(entry models.DiaryEntry) string {
+	return ContentHash(entryIndexVersion + "\n" + entry.Title + "\n" + entry.Content)
+}
+
+// ChunkEntry splits an entry's content into passages of at most
+// askChunkChars, keeping paragraphs together where possible
+func ChunkEntry(entry models.DiaryEntry) []string {
+	var paragraphs []string
+	for _, paragraph := range strings.Split(entry.Content, "\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+		if len(paragraph) > askChunkChars {
+			paragraphs = append(paragraphs, splitWords(paragraph, askChunkChars)...)
+		} else {
+			paragraphs = append(paragraphs, paragraph)
+		}
+	}
+	if len(paragraphs) == 0 && strings.TrimSpace(entry.Title) != "" {
+		// Title-only entries are still searchable
+		return []string{strings.TrimSpace(entry.Title)}
+	}
+	return chunkSections(paragraphs, askChunkChars)
+}
+
+// ChunkEmbeddingText is what gets embedded for a chunk: the passage with its
+// entry's title and date, which often carry the topic
+func ChunkEmbeddingText(title string, createdAt time.Time, text string) string {
+	return fmt.Sprintf("%s (%s)\n%s", title, createdAt.Format("January 2, 2006"), text)
+}
+
+// RankChunks returns the k chunks most similar to the query vector
+func RankChunks(query []float32, chunks []models.EntryChunk, k int) []ScoredChunk {
+	scored := make([]ScoredChunk, 0, len(chunks))
+	for _, chunk := range chunks {
+		scored = append(scored, ScoredChunk{Chunk: chunk, Score: CosineSimilarity(query, chunk.Vector)})
+	}
+	sort.SliceStable(scored, func(i, j int) bool { return scored[i].Score > scored[j].Score })
+	if len(scored) > k {
+		scored = scored[:k]
+	}
+	return scored
+}
+
+// AnswerQuestion asks the chat model to answer from the retrieved chunks. It
+// returns the answer and the chunks it cited, in order of first citation.
+func AnswerQuestion(ctx context.Context, provider LLMProvider, question string, chunks []ScoredChunk, now time.Time) (string, []ScoredChunk, error) {
+	instructions, _, err := renderPrompt(ctx, PromptAsk, AskPromptData{})
+	if err != nil {
+		return "", nil, err
+	}
+
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "Today is %s.\n\nDiary excerpts:\n", now.Format("Monday, January 2, 2006"))
+	for i, scored := range chunks {
+		fmt.Fprintf(&prompt, "\n[%d] %s - %s\n%s\n", i+1,
+			scored.Chunk.EntryCreatedAt.Format("Mon Jan 2, 2006"), scored.Chunk.EntryTitle, scored.Chunk.Text)
+	}
+	fmt.Fprintf(&prompt, "\nQuestion: %s", question)
+
+	answer, err := provider.Complete(ctx, CompletionRequest{
+		Messages: []models.Message{
+			{Role: "system", Content: instructions},
+			{Role: "user", Content: prompt.String()},
+		},
+		Temperature: 0.2,
+		MaxTokens:   500,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	var cited []ScoredChunk
+	seen := make(map[int]bool)
+	for _, match := range citationPattern.FindAllStringSubmatch(answer, -1) {
+		n, err := strconv.Atoi(match[1])
+		if err != nil || n < 1 || n > len(chunks) || seen[n] {
+			continue
+		}
+		seen[n] = true
+		cited = append(cited, chunks[n-1])
+	}
+	return strings.TrimSpace(answer), cited, nil
+}
+
+// splitWords breaks text into pieces of at most maxChars at word boundaries
+func splitWords(text string, maxChars int) []string {
+	var pieces []string
+	var current strings.Builder
+	for _, word := range strings.Fields(text) {
+		if current.Len() > 0 && current.Len()+len(word)+1 > maxChars {
+			pieces = append(pieces, current.String())
+			current.Reset()
+		}
+		if current.Len() > 0 {
+			current.WriteByte(' ')
+		}
+		current.WriteString(word)
+	}
+	if current.Len() > 0 {
+		pieces = append(pieces, current.String())
+	}
+	return pieces
+}
+
+// CitedSources turns cited chunks into one source per entry
+func CitedSources(cited []ScoredChunk) []models.AskSource {
+	sources := []models.AskSource{}
+	seen := make(map[string]bool)
+	for _, scored := range cited {
+		if seen[scored.Chunk.EntryID] {
+			continue
+		}
+		seen[scored.Chunk.EntryID] = true
+		sources = append(sources, models.AskSource{
+			EntryID:   scored.Chunk.EntryID,
+			Title:     scored.Chunk.EntryTitle,
+			CreatedAt: scored.Chunk.EntryCreatedAt,
+			Excerpt:   truncateText(scored.Chunk.Text, askExcerptChars),
+			Score:     scored.Score,
+			Link:      "/diary/" + scored.Chunk.EntryID,
+		})
+	}
+	return sources
+}
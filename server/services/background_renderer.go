@@ -0,0 +1,362 @@
+package services
+
+import (
+	"hash/fnv"
+	"image"
+	"image/color"
+	"math"
+	"math/rand/v2"
+	"personal-diary/models"
+	"strings"
+	"unicode"
+)
+
+const (
+	backgroundWidth  = 1024
+	backgroundHeight = 768
+)
+
+// paletteKeywords are hues in degrees for words of the prompt or entry
+var paletteKeywords = map[string]float64{
+	"sunset": 25, "sunrise": 35, "dawn": 30, "dusk": 300, "warm": 30, "fire": 10, "autumn": 25, "fall": 28,
+	"sun": 48, "sunny": 48, "summer": 45, "beach": 42, "sand": 38, "desert": 35, "gold": 45, "golden": 45,
+	"ocean": 205, "sea": 200, "blue": 215, "rain": 210, "river": 195, "lake": 195, "water": 200, "sky": 205, "winter": 200, "snow": 200,
+	"forest": 125, "green": 120, "garden": 110, "tree": 115, "trees": 115, "park": 110, "spring": 95, "leaves": 100,
+	"purple": 275, "lavender": 265, "violet": 280, "night": 235, "stars": 240, "moon": 230,
+	"pink": 340, "rose": 345, "roses": 345, "blossom": 335, "flowers": 330, "cherry": 345,
+	"coffee": 28, "mountain": 210, "mountains": 210, "city": 220, "red": 0,
+}
+
+// emotionHues are hues in degrees for the emotions of the mood analysis
+var emotionHues = map[string]float64{
+	"joy": 45, "excitement": 20, "love": 345, "gratitude": 40, "pride": 280, "hope": 195,
+	"calm": 185, "sadness": 220, "loneliness": 240, "anxiety": 265, "fear": 255, "anger": 5,
+	"frustration": 15, "tiredness": 250, "guilt": 70, "boredom": 35, "confusion": 300,
+}
+
+// ImageSeed derives a stable seed from texts, so the same entry gets the
+// same procedural background
+func ImageSeed(texts ...string) int64 {
+	h := fnv.New64a()
+	for _, text := range texts {
+		h.Write([]byte(text))
+		h.Write([]byte{0})
+	}
+	return int64(h.Sum64() >> 1)
+}
+
+// RenderBackground paints a background from req without any image API:
+// a multi-stop gradient warped by noise, layered cloud noise, watercolour
+// washes and bokeh. The palette comes from req.Mood and the keywords of
+// req.Prompt and req.Text; the same request always renders the same image.
+func RenderBackground(req ImageRequest) *image.RGBA {
+	seed := uint64(req.Seed)
+	if req.Seed == 0 {
+		seed = uint64(ImageSeed(req.Prompt, req.Text))
+	}
+	rng := rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15))
+	noise := valueNoise{seed: seed}
+
+	mood := req.Mood
+	if mood == nil {
+		mood = &models.MoodAnalysis{Energy: 0.5}
+	}
+	palette := backgroundPalette(rng, mood, paletteHues(req.Prompt+" "+req.Text, mood))
+	canvas := newFloatCanvas(backgroundWidth, backgroundHeight)
+
+	paintGradient(canvas, rng, noise, palette)
+	paintClouds(canvas, noise)
+	washes := 5 + rng.IntN(5)
+	for i := 0; i < washes; i++ {
+		paintWatercolour(canvas, rng, noise, palette[rng.IntN(len(palette))], i)
+	}
+	// Energetic moods get more, brighter bokeh
+	bokeh := 8 + int(mood.Energy*30) + rng.IntN(6)
+	for i := 0; i < bokeh; i++ {
+		paintBokeh(canvas, rng, palette[len(palette)-1-rng.IntN(2)], 0.5+mood.Energy*0.5)
+	}
+	paintFinish(canvas, noise)
+	return canvas.image()
+}
+
+// paletteHues picks up to three hues: keywords first, then emotions, and
+// otherwise the mood's score from cool (negative) to warm (positive)
+func paletteHues(text string, mood *models.MoodAnalysis) []float64 {
+	var hues []float64
+	seen := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		if hue, ok := paletteKeywords[word]; ok && !seen[word] && len(hues) < 2 {
+			seen[word] = true
+			hues = append(hues, hue)
+		}
+	}
+	for _, emotion := range mood.Emotions {
+		if hue, ok := emotionHues[emotion]; ok && len(hues) < 3 {
+			hues = append(hues, hue)
+		}
+	}
+	if len(hues) == 0 {
+		// -1 is a deep blue, 0 teal and 1 gold
+		score := clamp(mood.Score, -1, 1)
+		if score > 0 {
+			hues = append(hues, 190-150*score)
+		} else {
+			hues = append(hues, 190-40*score)
+		}
+	}
+	return hues
+}
+
+// backgroundPalette builds five colours from dark to light. Positive moods
+// are lighter and energetic ones more saturated.
+func backgroundPalette(rng *rand.Rand, mood *models.MoodAnalysis, hues []float64) []rgb {
+	saturation := 0.3 + 0.45*clamp(mood.Energy, 0, 1)
+	lift := 0.08 * clamp(mood.Score, -1, 1)
+	palette := make([]rgb, 5)
+	for i := range palette {
+		hue := hues[i%len(hues)] + (rng.Float64()-0.5)*24
+		lightness := clamp(0.42+float64(i)*0.1+lift+(rng.Float64()-0.5)*0.05, 0.2, 0.92)
+		palette[i] = hslToRGB(hue, saturation*(1-0.15*float64(i)/4), lightness)
+	}
+	return palette
+}
+
+// paintGradient fills the canvas with the palette along a random direction,
+// warped by low-frequency noise so the bands flow
+func paintGradient(c *floatCanvas, rng *rand.Rand, noise valueNoise, palette []rgb) {
+	angle := rng.Float64() * 2 * math.Pi
+	dx, dy := math.Cos(angle), math.Sin(angle)
+	span := math.Abs(dx)*0.5 + math.Abs(dy)*0.5
+	for y := 0; y < c.height; y++ {
+		fy := float64(y)/float64(c.height) - 0.5
+		for x := 0; x < c.width; x++ {
+			fx := float64(x)/float64(c.width) - 0.5
+			t := (fx*dx+fy*dy)/span*0.5 + 0.5
+			t += (noise.fbm(float64(x)/280, float64(y)/280, 4) - 0.5) * 0.45
+			c.set(x, y, paletteAt(palette, clamp(t, 0, 1)))
+		}
+	}
+}
+
+// paintClouds modulates brightness with two layers of noise
+func paintClouds(c *floatCanvas, noise valueNoise) {
+	for y := 0; y < c.height; y++ {
+		for x := 0; x < c.width; x++ {
+			large := noise.fbm(float64(x)/160+31, float64(y)/160+17, 4)
+			fine := noise.fbm(float64(x)/40+7, float64(y)/40+53, 3)
+			c.scale(x, y, 0.88+0.18*large+0.06*fine)
+		}
+	}
+}
+
+// paintWatercolour lays an irregular translucent wash whose pigment pools
+// at its edge, with a granulated texture
+func paintWatercolour(c *floatCanvas, rng *rand.Rand, noise valueNoise, pigment rgb, layer int) {
+	cx := rng.Float64() * float64(c.width)
+	cy := rng.Float64() * float64(c.height)
+	radius := (0.15 + rng.Float64()*0.25) * float64(c.width)
+	opacity := 0.18 + rng.Float64()*0.2
+	offset := float64(layer) * 97.3
+
+	reach := radius * 1.4
+	for y := max(0, int(cy-reach)); y < min(c.height, int(cy+reach)); y++ {
+		for x := max(0, int(cx-reach)); x < min(c.width, int(cx+reach)); x++ {
+			d := math.Hypot(float64(x)-cx, float64(y)-cy) / radius
+			if d > 1.4 {
+				continue
+			}
+			edge := 1 + 0.7*(noise.fbm(float64(x)/90+offset, float64(y)/90-offset, 3)-0.5)
+			t := d / edge
+			if t >= 1 {
+				continue
+			}
+			alpha := opacity * (0.55 + 0.45*smoothstep(0.65, 0.97, t)) * (1 - smoothstep(0.94, 1, t))
+			alpha *= 0.8 + 0.4*noise.at(float64(x)/2.5+offset, float64(y)/2.5)
+			// Pigment darkens like paint: a multiply blend
+			c.blend(x, y, c.get(x, y).mul(pigment.lighten(0.25)), alpha)
+		}
+	}
+}
+
+// paintBokeh adds a soft out-of-focus light with a slightly brighter rim
+func paintBokeh(c *floatCanvas, rng *rand.Rand, light rgb, strength float64) {
+	cx := rng.Float64() * float64(c.width)
+	// Lights gather towards the top, like a sky or a window
+	cy := math.Pow(rng.Float64(), 1.5) * float64(c.height)
+	radius := 6 + math.Pow(rng.Float64(), 2)*60
+	intensity := (0.08 + rng.Float64()*0.22) * strength
+	glow := light.lighten(0.5)
+
+	for y := max(0, int(cy-radius-1)); y < min(c.height, int(cy+radius+1)); y++ {
+		for x := max(0, int(cx-radius-1)); x < min(c.width, int(cx+radius+1)); x++ {
+			d := math.Hypot(float64(x)-cx, float64(y)-cy) / radius
+			if d >= 1 {
+				continue
+			}
+			v := (1 - smoothstep(0.88, 1, d)) * (0.7 + 0.3*smoothstep(0.55, 0.95, d))
+			c.screen(x, y, glow, v*intensity)
+		}
+	}
+}
+
+// paintFinish adds paper grain and a gentle vignette
+func paintFinish(c *floatCanvas, noise valueNoise) {
+	for y := 0; y < c.height; y++ {
+		fy := float64(y)/float64(c.height) - 0.5
+		for x := 0; x < c.width; x++ {
+			fx := float64(x)/float64(c.width) - 0.5
+			vignette := 1 - 0.35*(fx*fx+fy*fy)
+			grain := 1 + (noise.at(float64(x)*0.8+211, float64(y)*0.8+113)-0.5)*0.05
+			c.scale(x, y, vignette*grain)
+		}
+	}
+}
+
+// valueNoise is seeded lattice noise in [0, 1)
+type valueNoise struct {
+	seed uint64
+}
+
+func (n valueNoise) lattice(x, y int64) float64 {
+	h := n.seed ^ uint64(x)*0x9e3779b97f4a7c15 ^ uint64(y)*0xc2b2ae3d27d4eb4f
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return float64(h>>11) / (1 << 53)
+}
+
+// at interpolates the lattice smoothly
+func (n valueNoise) at(x, y float64) float64 {
+	x0, y0 := math.Floor(x), math.Floor(y)
+	tx, ty := smooth(x-x0), smooth(y-y0)
+	ix, iy := int64(x0), int64(y0)
+	top := lerp(n.lattice(ix, iy), n.lattice(ix+1, iy), tx)
+	bottom := lerp(n.lattice(ix, iy+1), n.lattice(ix+1, iy+1), tx)
+	return lerp(top, bottom, ty)
+}
+
+// fbm sums octaves of noise, each twice as fine and half as strong
+func (n valueNoise) fbm(x, y float64, octaves int) float64 {
+	var sum, weight float64
+	amplitude := 1.0
+	for i := 0; i < octaves; i++ {
+		sum += amplitude * n.at(x, y)
+		weight += amplitude
+		x, y = x*2+13.7, y*2+7.1
+		amplitude /= 2
+	}
+	return sum / weight
+}
+
+// rgb is a colour with channels from 0 to 1
+type rgb struct{ r, g, b float64 }
+
+func (c rgb) mul(o rgb) rgb { return rgb{c.r * o.r, c.g * o.g, c.b * o.b} }
+
+// lighten moves the colour towards white by amount
+func (c rgb) lighten(amount float64) rgb {
+	return rgb{lerp(c.r, 1, amount), lerp(c.g, 1, amount), lerp(c.b, 1, amount)}
+}
+
+func hslToRGB(hue, saturation, lightness float64) rgb {
+	hue = math.Mod(math.Mod(hue, 360)+360, 360) / 360
+	if saturation == 0 {
+		return rgb{lightness, lightness, lightness}
+	}
+	q := lightness * (1 + saturation)
+	if lightness >= 0.5 {
+		q = lightness + saturation - lightness*saturation
+	}
+	p := 2*lightness - q
+	channel := func(t float64) float64 {
+		t = math.Mod(t+1, 1)
+		switch {
+		case t < 1.0/6:
+			return p + (q-p)*6*t
+		case t < 0.5:
+			return q
+		case t < 2.0/3:
+			return p + (q-p)*(2.0/3-t)*6
+		default:
+			return p
+		}
+	}
+	return rgb{channel(hue + 1.0/3), channel(hue), channel(hue - 1.0/3)}
+}
+
+// paletteAt is the colour at t along evenly spaced stops
+func paletteAt(palette []rgb, t float64) rgb {
+	pos := t * float64(len(palette)-1)
+	i := min(int(pos), len(palette)-2)
+	f := smooth(pos - float64(i))
+	a, b := palette[i], palette[i+1]
+	return rgb{lerp(a.r, b.r, f), lerp(a.g, b.g, f), lerp(a.b, b.b, f)}
+}
+
+type floatCanvas struct {
+	width, height int
+	pix           []float32
+}
+
+func newFloatCanvas(width, height int) *floatCanvas {
+	return &floatCanvas{width: width, height: height, pix: make([]float32, width*height*3)}
+}
+
+func (c *floatCanvas) get(x, y int) rgb {
+	i := (y*c.width + x) * 3
+	return rgb{float64(c.pix[i]), float64(c.pix[i+1]), float64(c.pix[i+2])}
+}
+
+func (c *floatCanvas) set(x, y int, col rgb) {
+	i := (y*c.width + x) * 3
+	c.pix[i], c.pix[i+1], c.pix[i+2] = float32(col.r), float32(col.g), float32(col.b)
+}
+
+func (c *floatCanvas) scale(x, y int, factor float64) {
+	i := (y*c.width + x) * 3
+	for k := 0; k < 3; k++ {
+		c.pix[i+k] *= float32(factor)
+	}
+}
+
+// blend mixes col over the pixel with opacity alpha
+func (c *floatCanvas) blend(x, y int, col rgb, alpha float64) {
+	cur := c.get(x, y)
+	c.set(x, y, rgb{lerp(cur.r, col.r, alpha), lerp(cur.g, col.g, alpha), lerp(cur.b, col.b, alpha)})
+}
+
+// screen lightens the pixel with light, as overlapping lights do
+func (c *floatCanvas) screen(x, y int, light rgb, amount float64) {
+	cur := c.get(x, y)
+	c.set(x, y, rgb{
+		1 - (1-cur.r)*(1-light.r*amount),
+		1 - (1-cur.g)*(1-light.g*amount),
+		1 - (1-cur.b)*(1-light.b*amount),
+	})
+}
+
+func (c *floatCanvas) image() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, c.width, c.height))
+	for y := 0; y < c.height; y++ {
+		for x := 0; x < c.width; x++ {
+			col := c.get(x, y)
+			img.SetRGBA(x, y, color.RGBA{toByte(col.r), toByte(col.g), toByte(col.b), 255})
+		}
+	}
+	return img
+}
+
+func toByte(v float64) uint8 {
+	return uint8(clamp(v, 0, 1)*255 + 0.5)
+}
+
+func lerp(a, b, t float64) float64 { return a + (b-a)*t }
+
+// smooth eases t from 0 to 1
+func smooth(t float64) float64 { return t * t * (3 - 2*t) }
+
+func smoothstep(edge0, edge1, x float64) float64 {
+	return smooth(clamp((x-edge0)/(edge1-edge0), 0, 1))
+}
@@ -0,0 +1,33 @@
+package services
+
+import (
+	"bytes"
+	"personal-diary/models"
+	"testing"
+)
+
+func TestRenderBackgroundIsSeeded(t *testing.T) {
+	req := ImageRequest{
+		Prompt: "A misty lake at dawn",
+		Text:   "Swam before work, the water was still cold.",
+		Mood:   &models.MoodAnalysis{Score: 0.4, Energy: 0.6, Emotions: []string{"calm"}},
+		Seed:   7,
+	}
+	first := RenderBackground(req).Pix
+	if again := RenderBackground(req).Pix; !bytes.Equal(first, again) {
+		t.Error("the same seed rendered different images")
+	}
+
+	req.Seed = 8
+	if other := RenderBackground(req).Pix; bytes.Equal(first, other) {
+		t.Error("different seeds rendered the same image")
+	}
+}
+
+func TestRenderBackgroundSeedsFromText(t *testing.T) {
+	// Without a seed, the same entry still gets the same background
+	req := ImageRequest{Text: "Rain all day, stayed in with a book."}
+	if !bytes.Equal(RenderBackground(req).Pix, RenderBackground(req).Pix) {
+		t.Error("an unseeded request rendered different images")
+	}
+}
@@ -1,36 +1,71 @@
 package services
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
 	"personal-diary/models"
-	"strconv"
 	"strings"
-	"time"
 )
 
-// Implement the chat GPT
+const (
+	defaultOpenAIBaseURL = "https://api.openai.com/v1"
+	defaultOpenAIModel   = "gpt-4o-mini"
+)
+
+// OpenAIProvider talks to any OpenAI-compatible chat completions endpoint.
+// Pointing the base URL at a local server (llama.cpp, Ollama, vLLM...) lets
+// it stand in for OpenAI; such servers usually need no API key.
+type OpenAIProvider struct {
+	baseURL string
+	apiKey  string
+	model   string
+	client  *LLMClient
+}
 
-func RefineTextWithChatGPT(text, context, tone string) (string, error) {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		return "", fmt.Errorf("OpenAI API key not configured")
+func NewOpenAIProvider(baseURL, apiKey, model string) *OpenAIProvider {
+	if baseURL == "" {
+		baseURL = defaultOpenAIBaseURL
 	}
+	if model == "" {
+		model = defaultOpenAIModel
+	}
+	baseURL = strings.TrimRight(baseURL, "/")
+	return &OpenAIProvider{
+		baseURL: baseURL,
+		apiKey:  apiKey,
+		model:   model,
+		client:  NewLLMClient(baseURL),
+	}
+}
 
-	prompt := buildRefinePrompt(text, context, tone)
+func (p *OpenAIProvider) Name() string  { return "openai" }
+func (p *OpenAIProvider) Model() string { return p.model }
+
+func (p *OpenAIProvider) Refine(ctx context.Context, text, writingContext, tone string) (string, error) {
+	return refineWithProvider(ctx, p, text, writingContext, tone)
+}
+
+func (p *OpenAIProvider) RefineStream(ctx context.Context, text, writingContext, tone string, onDelta func(string) error) (string, error) {
+	return refineStreamWithProvider(ctx, p, text, writingContext, tone, onDelta)
+}
+
+// Complete sends the messages to the chat completions API and returns the
+// trimmed content of the first choice. Failed attempts are retried by the
+// LLM client until ctx is done.
+func (p *OpenAIProvider) Complete(ctx context.Context, completion CompletionRequest) (string, error) {
+	if p.apiKey == "" && p.baseURL == defaultOpenAIBaseURL {
+		return "", fmt.Errorf("OpenAI API key not configured: %w", ErrLLMNotConfigured)
+	}
 
 	chatGPTReq := models.ChatGPTRequest{
-		Model: "gpt-4o-mini",
-		Messages: []models.Message{
-			{Role: "system", Content: "You are a helpful writing assistant..."},
-			{Role: "user", Content: prompt},
-		},
-		Temperature: 0.3,
-		MaxTokens:   500,
+		Model:       p.model,
+		Messages:    completion.Messages,
+		Temperature: completion.Temperature,
+		MaxTokens:   completion.MaxTokens,
 	}
 
 	jsonData, err := json.Marshal(chatGPTReq)
@@ -38,96 +73,116 @@ func RefineTextWithChatGPT(text, context, tone string) (string, error) {
 		return "", fmt.Errorf("marshal request error: %v", err)
 	}
 
-	var lastErr error
-	for i := 0; i < 3; i++ { // Retry up to 3 times
-		req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
+	body, err := p.client.Send(ctx, p.chatRequest(jsonData, false))
+	if err != nil {
+		return "", err
+	}
+
+	var chatGPTResp models.ChatGPTResponse
+	if err := json.Unmarshal(body, &chatGPTResp); err != nil {
+		return "", fmt.Errorf("parse response error: %v", err)
+	}
+	if chatGPTResp.Error != nil {
+		return "", fmt.Errorf("ChatGPT error: %s", chatGPTResp.Error.Message)
+	}
+	if chatGPTResp.Usage != nil {
+		reportUsage(ctx, chatGPTResp.Usage.PromptTokens, chatGPTResp.Usage.CompletionTokens)
+	}
+	if len(chatGPTResp.Choices) == 0 {
+		return "", fmt.Errorf("empty response from ChatGPT")
+	}
+	return strings.TrimSpace(chatGPTResp.Choices[0].Message.Content), nil
+}
+
+// chatRequest builds a chat completions request with the given body for
+// every attempt of the LLM client
+func (p *OpenAIProvider) chatRequest(jsonData []byte, stream bool) func(context.Context) (*http.Request, error) {
+	return func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/chat/completions", bytes.NewReader(jsonData))
 		if err != nil {
-			return "", fmt.Errorf("create request error: %v", err)
+			return nil, err
 		}
 		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", "Bearer "+apiKey)
-
-		client := &http.Client{Timeout: 30 * time.Second}
-		resp, err := client.Do(req)
-		if err != nil {
-			lastErr = fmt.Errorf("send request error: %v", err)
-			time.Sleep(time.Duration(2<<i) * time.Second)
-			continue
+		if stream {
+			req.Header.Set("Accept", "text/event-stream")
 		}
-		defer resp.Body.Close()
-
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			lastErr = fmt.Errorf("read response error: %v", err)
-			time.Sleep(time.Duration(2<<i) * time.Second)
-			continue
+		if p.apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+p.apiKey)
 		}
+		return req, nil
+	}
+}
 
-		if resp.StatusCode == 429 {
-			lastErr = fmt.Errorf("rate limited: %s", string(body))
+// CompleteStream requests a streamed completion ("stream": true) and relays
+// each content delta. The request is bound to ctx, so a cancelled context
+// (e.g. the browser went away) closes the upstream connection and stops
+// generation. Only opening the stream is retried.
+func (p *OpenAIProvider) CompleteStream(ctx context.Context, completion CompletionRequest, onDelta func(string) error) (string, error) {
+	if p.apiKey == "" && p.baseURL == defaultOpenAIBaseURL {
+		return "", fmt.Errorf("OpenAI API key not configured: %w", ErrLLMNotConfigured)
+	}
 
-			// Optional: check for Retry-After header
-			if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
-				if seconds, err := strconv.Atoi(retryAfter); err == nil {
-					time.Sleep(time.Duration(seconds) * time.Second)
-				}
-			} else {
-				time.Sleep(time.Duration(2<<i) * time.Second) // exponential backoff
-			}
-			continue
-		}
+	streamReq := models.ChatGPTRequest{
+		Model:       p.model,
+		Messages:    completion.Messages,
+		Temperature: completion.Temperature,
+		MaxTokens:   completion.MaxTokens,
+		Stream:      true,
+	}
+	if p.baseURL == defaultOpenAIBaseURL {
+		// Not every compatible server accepts stream_options
+		streamReq.StreamOptions = &models.StreamOptions{IncludeUsage: true}
+	}
+	jsonData, err := json.Marshal(streamReq)
+	if err != nil {
+		return "", fmt.Errorf("marshal request error: %v", err)
+	}
 
-		if resp.StatusCode != http.StatusOK {
-			return "", fmt.Errorf("non-200 response: %d\n%s", resp.StatusCode, string(body))
+	// The stream lasts as long as generation does and is bounded by ctx
+	resp, err := p.client.Open(ctx, p.chatRequest(jsonData, true))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var full strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue // blank separators, comments and other SSE fields
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			return strings.TrimSpace(full.String()), nil
 		}
 
-		var chatGPTResp models.ChatGPTResponse
-		if err := json.Unmarshal(body, &chatGPTResp); err != nil {
-			return "", fmt.Errorf("parse response error: %v", err)
+		var chunk models.ChatGPTStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return "", fmt.Errorf("parse stream chunk error: %v", err)
 		}
-		if chatGPTResp.Error != nil {
-			return "", fmt.Errorf("ChatGPT error: %s", chatGPTResp.Error.Message)
+		if chunk.Error != nil {
+			return "", fmt.Errorf("ChatGPT error: %s", chunk.Error.Message)
 		}
-		if len(chatGPTResp.Choices) == 0 {
-			return "", fmt.Errorf("empty response from ChatGPT")
+		if chunk.Usage != nil {
+			reportUsage(ctx, chunk.Usage.PromptTokens, chunk.Usage.CompletionTokens)
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			full.WriteString(choice.Delta.Content)
+			if err := onDelta(choice.Delta.Content); err != nil {
+				return "", err
+			}
 		}
-		return strings.TrimSpace(chatGPTResp.Choices[0].Message.Content), nil
 	}
-	return "", lastErr
-}
-
-// buildRefinePrompt creates an appropriate prompt
-func buildRefinePrompt(text, context, tone string) string {
-	var prompt strings.Builder
-	prompt.WriteString("Please refine the following text to improve its clarity, grammar, and overall quality")
-
-	switch strings.ToLower(context) {
-	case "diary", "diary_entry":
-		prompt.WriteString(" for a personal diary entry. Maintain the personal and reflective nature")
-	case "email":
-		prompt.WriteString(" for an email. Make it clear and professional")
-	case "academic":
-		prompt.WriteString(" for academic writing. Use formal language and ensure precision")
-	case "creative":
-		prompt.WriteString(" for creative writing. Enhance the literary quality and flow")
-	default:
-		prompt.WriteString(" while maintaining the original meaning and style")
-	}
-
-	switch strings.ToLower(tone) {
-	case "professional":
-		prompt.WriteString(", using a professional tone")
-	case "casual":
-		prompt.WriteString(", keeping a casual and friendly tone")
-	case "formal":
-		prompt.WriteString(", using formal language")
-	case "friendly":
-		prompt.WriteString(", maintaining a warm and friendly tone")
-	}
-
-	prompt.WriteString(":\n\n")
-	prompt.WriteString(text)
-	prompt.WriteString("\n\nPlease provide only the refined text without any explanations or additional comments.")
-
-	return prompt.String()
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("read stream error: %w", err)
+	}
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	return "", fmt.Errorf("stream ended without completion")
 }
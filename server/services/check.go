@@ -0,0 +1,251 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"errors"
+	"personal-diary/models"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// maxReplacements bounds the suggestions kept per annotation
+const maxReplacements = 3
+
+// checkReply is the JSON object the model is asked to return
+type checkReply struct {
+	Issues []checkIssue `json:"issues"`
+}
+
+type checkIssue struct {
+	Start        int      `json:"start"`
+	End          int      `json:"end"`
+	Text         string   `json:"text"`
+	Category     string   `json:"category"`
+	Message      string   `json:"message"`
+	Replacements []string `json:"replacements"`
+}
+
+var annotationCategories = map[string]bool{
+	models.AnnotationSpelling: true,
+	models.AnnotationGrammar:  true,
+	models.AnnotationClarity:  true,
+}
+
+// CheckText lists spelling, grammar and clarity issues in text. When no
+// provider is configured it falls back to CheckTextOffline.
+func CheckText(ctx context.Context, provider LLMProvider, text string) (models.CheckResponse, error) {
+	prompt, version, err := renderPrompt(ctx, PromptCheck, CheckPromptData{})
+	if err != nil {
+		return models.CheckResponse{}, err
+	}
+
+	reply, err := provider.Complete(ctx, CompletionRequest{
+		Messages: []models.Message{
+			{Role: "system", Content: prompt},
+			{Role: "user", Content: text},
+		},
+		Temperature: 0,
+		MaxTokens:   1500,
+	})
+	if errors.Is(err, ErrLLMNotConfigured) {
+		return CheckTextOffline(text), nil
+	}
+	if err != nil {
+		return models.CheckResponse{}, err
+	}
+
+	var parsed checkReply
+	if err := parseJSONReply(reply, &parsed); err != nil {
+		return models.CheckResponse{}, err
+	}
+	return models.CheckResponse{
+		Annotations:   validateAnnotations(text, parsed.Issues),
+		Source:        models.CheckSourceModel,
+		PromptVersion: version,
+	}, nil
+}
+
+// validateAnnotations turns the model's issues into annotations whose offsets
+// are known to cover their text. Models often miscount characters, so an
+// issue whose offsets do not match is moved to the nearest occurrence of its
+// text; issues whose text is not found, of unknown category or overlapping an
+// earlier one are dropped.
+func validateAnnotations(text string, issues []checkIssue) []models.Annotation {
+	runes := []rune(text)
+	annotations := []models.Annotation{}
+	for _, issue := range issues {
+		category := strings.ToLower(strings.TrimSpace(issue.Category))
+		issueText := []rune(issue.Text)
+		if !annotationCategories[category] || len(issueText) == 0 {
+			continue
+		}
+
+		start := issue.Start
+		if start < 0 || issue.End > len(runes) || issue.End-start != len(issueText) ||
+			string(runes[start:issue.End]) != issue.Text {
+			var found bool
+			if start, found = nearestOccurrence(runes, issueText, issue.Start); !found {
+				continue
+			}
+		}
+
+		var replacements []string
+		for _, replacement := range issue.Replacements {
+			if replacement != issue.Text && len(replacements) < maxReplacements {
+				replacements = append(replacements, replacement)
+			}
+		}
+		message := strings.TrimSpace(issue.Message)
+		if message == "" {
+			message = defaultAnnotationMessage(category)
+		}
+
+		annotations = append(annotations, models.Annotation{
+			Start:        start,
+			End:          start + len(issueText),
+			Original:     issue.Text,
+			Category:     category,
+			Message:      message,
+			Replacements: nonNil(replacements),
+		})
+	}
+	return dropOverlaps(annotations)
+}
+
+// nearestOccurrence finds needle in haystack closest to the hinted offset
+func nearestOccurrence(haystack, needle []rune, hint int) (int, bool) {
+	best, found := 0, false
+	for i := 0; i+len(needle) <= len(haystack); i++ {
+		if string(haystack[i:i+len(needle)]) != string(needle) {
+			continue
+		}
+		if !found || abs(i-hint) < abs(best-hint) {
+			best, found = i, true
+		}
+	}
+	return best, found
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// dropOverlaps sorts annotations by offset and keeps the first of any that
+// overlap, so a client can apply them independently
+func dropOverlaps(annotations []models.Annotation) []models.Annotation {
+	sort.SliceStable(annotations, func(i, j int) bool { return annotations[i].Start < annotations[j].Start })
+	kept := annotations[:0]
+	end := 0
+	for _, annotation := range annotations {
+		if annotation.Start >= end {
+			kept = append(kept, annotation)
+			end = annotation.End
+		}
+	}
+	return kept
+}
+
+func defaultAnnotationMessage(category string) string {
+	switch category {
+	case models.AnnotationSpelling:
+		return "Possible spelling mistake"
+	case models.AnnotationGrammar:
+		return "Possible grammar mistake"
+	default:
+		return "This could be clearer"
+	}
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+//go:embed dictionary/misspellings.txt
+var misspellingsFile string
+
+// misspellings maps common misspellings to their corrections
+var misspellings = loadMisspellings(misspellingsFile)
+
+func loadMisspellings(file string) map[string][]string {
+	dictionary := make(map[string][]string)
+	scanner := bufio.NewScanner(strings.NewReader(file))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if word, corrections, ok := strings.Cut(line, " "); ok {
+			dictionary[word] = strings.Split(strings.TrimSpace(corrections), ",")
+		}
+	}
+	return dictionary
+}
+
+// repeatableWords are correct when doubled, as in "I had had enough"
+var repeatableWords = map[string]bool{"had": true, "that": true}
+
+// CheckTextOffline finds common misspellings and repeated words using the
+// embedded dictionary. It knows far fewer mistakes than a model but needs
+// no provider.
+func CheckTextOffline(text string) models.CheckResponse {
+	annotations := []models.Annotation{}
+	var previous *editToken
+	var gap string // whitespace since the previous word
+	for _, token := range tokenizeForEdits(text) {
+		first := []rune(token.text)[0]
+		if unicode.IsSpace(first) {
+			gap = token.text
+			continue
+		}
+		if !isEditWordRune(first) {
+			previous, gap = nil, ""
+			continue
+		}
+
+		word := strings.ToLower(token.text)
+		if corrections, ok := misspellings[word]; ok {
+			annotations = append(annotations, models.Annotation{
+				Start:        token.start,
+				End:          token.end,
+				Original:     token.text,
+				Category:     models.AnnotationSpelling,
+				Message:      "Possible spelling mistake",
+				Replacements: matchCase(token.text, corrections),
+			})
+		} else if previous != nil && gap != "" && strings.ToLower(previous.text) == word && !repeatableWords[word] {
+			annotations = append(annotations, models.Annotation{
+				Start:        previous.start,
+				End:          token.end,
+				Original:     previous.text + gap + token.text,
+				Category:     models.AnnotationGrammar,
+				Message:      "Repeated word",
+				Replacements: []string{previous.text},
+			})
+		}
+		previous, gap = &token, ""
+	}
+	return models.CheckResponse{Annotations: dropOverlaps(annotations), Source: models.CheckSourceDictionary}
+}
+
+// matchCase capitalises corrections of a capitalised word
+func matchCase(word string, corrections []string) []string {
+	if !unicode.IsUpper([]rune(word)[0]) {
+		return corrections
+	}
+	matched := make([]string, len(corrections))
+	for i, correction := range corrections {
+		runes := []rune(correction)
+		runes[0] = unicode.ToUpper(runes[0])
+		matched[i] = string(runes)
+	}
+	return matched
+}
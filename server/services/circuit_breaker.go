@@ -0,0 +1,100 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// breakerThreshold consecutive failures open a circuit breaker
+	breakerThreshold = 5
+	// breakerCooldown is how long an open breaker fails calls before it lets
+	// one through to see whether the provider is back
+	breakerCooldown = 30 * time.Second
+)
+
+// ErrProviderUnavailable is returned without calling the provider while its
+// circuit breaker is open
+var ErrProviderUnavailable = errors.New("AI provider is temporarily unavailable")
+
+// CircuitBreaker stops calls to a provider that keeps failing. After
+// breakerThreshold failures in a row it opens and fails calls at once; after
+// breakerCooldown it lets a single trial call through, which closes it again
+// on success.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	open      bool
+	openUntil time.Time // when the next trial call may go through
+	trial     bool      // a trial call is in flight
+}
+
+var (
+	circuitBreakersMu sync.Mutex
+	circuitBreakers   = make(map[string]*CircuitBreaker)
+)
+
+// circuitBreakerFor returns the breaker of the named provider, so every
+// client of one provider sees it as down together
+func circuitBreakerFor(name string) *CircuitBreaker {
+	circuitBreakersMu.Lock()
+	defer circuitBreakersMu.Unlock()
+	breaker, ok := circuitBreakers[name]
+	if !ok {
+		breaker = &CircuitBreaker{}
+		circuitBreakers[name] = breaker
+	}
+	return breaker
+}
+
+// Allow returns ErrProviderUnavailable when a call must not be made. Every
+// allowed call must be followed by Success, Failure or Abandon.
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return nil
+	}
+	now := time.Now()
+	if now.Before(b.openUntil) {
+		return ErrProviderUnavailable
+	}
+	// One trial at a time; a trial that never reports back expires with
+	// the next cooldown
+	b.trial = true
+	b.openUntil = now.Add(breakerCooldown)
+	return nil
+}
+
+// Success records a call that reached a working provider
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.open = false
+	b.trial = false
+}
+
+// Failure records a call the provider failed
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.trial || b.failures >= breakerThreshold {
+		b.open = true
+		b.trial = false
+		b.openUntil = time.Now().Add(breakerCooldown)
+	}
+}
+
+// Abandon records a call given up by the caller, which says nothing about
+// the provider. An abandoned trial lets the next call try again.
+func (b *CircuitBreaker) Abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.trial {
+		b.trial = false
+		b.openUntil = time.Now()
+	}
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// openBreaker returns a breaker whose cooldown is over, so its next call is
+// the trial
+func openBreaker() *CircuitBreaker {
+	return &CircuitBreaker{failures: breakerThreshold, open: true, openUntil: time.Now().Add(-time.Millisecond)}
+}
+
+func TestCircuitBreakerAllowsOneTrialAtATime(t *testing.T) {
+	b := openBreaker()
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if b.Allow() == nil {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := allowed.Load(); n != 1 {
+		t.Errorf("%d calls allowed after the cooldown, want a single trial", n)
+	}
+}
+
+// testLLMClient is a client of a provider at url that answers every request
+// with status, counting the requests that reach it
+func testLLMClient(t *testing.T, status int) (client *LLMClient, url string, hits *atomic.Int32) {
+	t.Helper()
+	hits = &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return NewLLMClient(server.URL), server.URL, hits
+}
+
+// sendEmpty sends an empty POST to url
+func sendEmpty(ctx context.Context, client *LLMClient, url string) error {
+	_, err := client.Send(ctx, func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "POST", url, nil)
+	})
+	return err
+}
+
+func TestLLMClientRefusalsKeepBreakerClosed(t *testing.T) {
+	// A provider that refuses requests is up; only its own failures count
+	client, url, _ := testLLMClient(t, http.StatusBadRequest)
+	for i := 0; i < breakerThreshold*2; i++ {
+		var statusErr *LLMStatusError
+		if err := sendEmpty(context.Background(), client, url); !errors.As(err, &statusErr) {
+			t.Fatalf("request %d: error = %v, want the 400 reply", i+1, err)
+		}
+	}
+	if err := client.breaker.Allow(); err != nil {
+		t.Errorf("breaker opened after %d refused requests", breakerThreshold*2)
+	}
+}
+
+func TestLLMClientServerErrorsOpenBreaker(t *testing.T) {
+	client, url, hits := testLLMClient(t, http.StatusBadGateway)
+	// Every attempt counts, so two requests with their retries open it
+	for i := 0; i < 2; i++ {
+		sendEmpty(context.Background(), client, url)
+	}
+	before := hits.Load()
+	if err := sendEmpty(context.Background(), client, url); !errors.Is(err, ErrProviderUnavailable) {
+		t.Errorf("error = %v, want ErrProviderUnavailable", err)
+	}
+	if hits.Load() != before {
+		t.Error("the open breaker let a request reach the provider")
+	}
+}
+
+func TestLLMClientCancelledTrialIsAbandoned(t *testing.T) {
+	// The trial call hangs until its caller gives up
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+	client := NewLLMClient(server.URL)
+	client.breaker = openBreaker()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := sendEmpty(ctx, client, server.URL); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want the caller's deadline", err)
+	}
+	// Without Abandon the next call would wait out another cooldown
+	if err := client.breaker.Allow(); err != nil {
+		t.Errorf("after an abandoned trial Allow = %v, want another trial", err)
+	}
+}
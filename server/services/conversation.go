@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"personal-diary/models"
+	"strings"
+)
+
+const (
+	// conversationReplyTokens bounds one reply of the journaling companion
+	conversationReplyTokens = 600
+	// conversationHistoryTokens caps the earlier messages sent with each
+	// turn, even for models with much larger context windows
+	conversationHistoryTokens = 6000
+	// conversationEntryTokens caps the entry quoted in the system prompt
+	conversationEntryTokens = 4000
+)
+
+// conversationOpener stands in for the user when the companion opens a
+// thread, as models expect a conversation to start with the user
+const conversationOpener = "I'd like to reflect on this entry. Please start our conversation."
+
+// CompanionReply is the journaling companion's next message in a thread
+type CompanionReply struct {
+	Content string
+	// Trimmed is how many earlier messages were left out to fit the
+	// model's context window
+	Trimmed       int
+	PromptVersion string
+}
+
+// ContinueConversation asks the journaling companion for its next message
+// in a thread about entry. history holds the thread so far, ending with the
+// user's new message, or is empty to have the companion open the thread.
+func ContinueConversation(ctx context.Context, provider LLMProvider, entry models.DiaryEntry, history []models.Message) (*CompanionReply, error) {
+	model := provider.Model()
+	prompt, version, err := renderPrompt(ctx, PromptCompanion, CompanionPromptData{
+		Title:   entry.Title,
+		Date:    entry.CreatedAt.Format("Monday, January 2, 2006"),
+		Content: truncateToTokens(model, entry.Content, conversationEntryTokens),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	budget := min(conversationHistoryTokens,
+		ModelContextTokens(model)-EstimateModelTokens(model, prompt)-conversationReplyTokens)
+	kept, trimmed := TrimHistory(model, history, budget)
+	if len(kept) == 0 || kept[0].Role != "user" {
+		kept = append([]models.Message{{Role: "user", Content: conversationOpener}}, kept...)
+	}
+
+	reply, err := provider.Complete(ctx, CompletionRequest{
+		Messages:    append([]models.Message{{Role: "system", Content: prompt}}, kept...),
+		Temperature: 0.7,
+		MaxTokens:   conversationReplyTokens,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &CompanionReply{Content: strings.TrimSpace(reply), Trimmed: trimmed, PromptVersion: version}, nil
+}
+
+// TrimHistory keeps the most recent messages that fit in budget tokens for
+// model, and always the last one. It returns them with how many older
+// messages were dropped.
+func TrimHistory(model string, history []models.Message, budget int) ([]models.Message, int) {
+	start := len(history)
+	used := 0
+	for start > 0 {
+		// Each message also costs a few tokens of framing
+		cost := EstimateModelTokens(model, history[start-1].Content) + 4
+		if used+cost > budget && start < len(history) {
+			break
+		}
+		used += cost
+		start--
+	}
+	return history[start:], start
+}
+
+// truncateToTokens shortens text to about budget tokens for model, cutting
+// at a word boundary
+func truncateToTokens(model, text string, budget int) string {
+	if EstimateModelTokens(model, text) <= budget {
+		return text
+	}
+	runes := []rune(text)
+	// Tokens per character vary by script, so search for the longest prefix
+	low, high := 0, len(runes)
+	for low < high {
+		mid := (low + high + 1) / 2
+		if EstimateModelTokens(model, string(runes[:mid])) <= budget {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+	cut := string(runes[:low])
+	if space := strings.LastIndexAny(cut, " \n"); space > len(cut)/2 {
+		cut = cut[:space]
+	}
+	return strings.TrimSpace(cut) + " […]"
+}
+
+// ConversationTranscript formats a thread as the content of a diary entry
+func ConversationTranscript(messages []models.ConversationMessage) string {
+	var sb strings.Builder
+	for i, msg := range messages {
+		if i > 0 {
+			sb.WriteString("\n\n")
+		}
+		if msg.Role == "assistant" {
+			sb.WriteString("Companion: ")
+		} else {
+			sb.WriteString("Me: ")
+		}
+		sb.WriteString(strings.TrimSpace(msg.Content))
+	}
+	return sb.String()
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"personal-diary/models"
+	"strings"
+	"time"
+)
+
+// ErrNoDigestEntries is returned when a digest is requested for a week without entries
+var ErrNoDigestEntries = errors.New("no diary entries in digest period")
+
+// maxDigestEntryChars bounds how much of each entry is sent to the model
+const maxDigestEntryChars = 1500
+
+// GenerateWeeklyDigest asks the chat model for a reflection, highlights and a
+// mood trend over the given entries and combines them with local writing stats.
+func GenerateWeeklyDigest(ctx context.Context, provider LLMProvider, entries []models.DiaryEntry, start, end time.Time) (*models.WeeklyDigest, error) {
+	if len(entries) == 0 {
+		return nil, ErrNoDigestEntries
+	}
+
+	prompt, version, err := renderPrompt(ctx, PromptDigest, DigestPromptData{})
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := provider.Complete(ctx, CompletionRequest{
+		Messages: []models.Message{
+			{Role: "system", Content: prompt},
+			{Role: "user", Content: buildDigestPrompt(entries)},
+		},
+		Temperature: 0.5,
+		MaxTokens:   700,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed struct {
+		Reflection string   `json:"reflection"`
+		Highlights []string `json:"highlights"`
+		MoodTrend  string   `json:"moodTrend"`
+	}
+	if err := parseJSONReply(reply, &parsed); err != nil {
+		return nil, err
+	}
+
+	return &models.WeeklyDigest{
+		PeriodStart:   start,
+		PeriodEnd:     end,
+		Reflection:    strings.TrimSpace(parsed.Reflection),
+		Highlights:    parsed.Highlights,
+		MoodTrend:     strings.TrimSpace(parsed.MoodTrend),
+		Stats:         ComputeDigestStats(entries),
+		PromptVersion: version,
+	}, nil
+}
+
+// buildDigestPrompt lists the week's entries oldest first for the model
+func buildDigestPrompt(entries []models.DiaryEntry) string {
+	var prompt strings.Builder
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		content := truncateText(entry.Content, maxDigestEntryChars)
+		fmt.Fprintf(&prompt, "### %s - %s\n%s\n\n", entry.CreatedAt.Format("Monday, Jan 2"), entry.Title, content)
+	}
+	return strings.TrimSpace(prompt.String())
+}
+
+// truncateText cuts text to at most max runes, marking the cut with an ellipsis
+func truncateText(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max]) + "..."
+}
+
+// ComputeDigestStats counts entries, active days and words for the digest
+func ComputeDigestStats(entries []models.DiaryEntry) models.DigestStats {
+	stats := models.DigestStats{EntryCount: len(entries)}
+	days := make(map[string]bool)
+
+	for _, entry := range entries {
+		words := len(strings.Fields(entry.Content))
+		stats.TotalWords += words
+		if words > stats.LongestEntryWords {
+			stats.LongestEntryWords = words
+		}
+		days[entry.CreatedAt.Format("2006-01-02")] = true
+	}
+
+	stats.DaysWritten = len(days)
+	if stats.EntryCount > 0 {
+		stats.AverageWords = stats.TotalWords / stats.EntryCount
+	}
+	return stats
+}
+
+var digestEmailTemplate = template.Must(template.New("digest").Parse(`
+<html>
+<body style="font-family:Georgia,serif; color:#333; max-width:600px; margin:auto;">
+	<h2>Your week in your diary</h2>
+	<p style="color:#888;">{{.PeriodStart.Format "Jan 2"}} – {{.PeriodEnd.Format "Jan 2, 2006"}}</p>
+	<p>{{.Reflection}}</p>
+	{{if .Highlights}}
+	<h3>Highlights</h3>
+	<ul>{{range .Highlights}}<li>{{.}}</li>{{end}}</ul>
+	{{end}}
+	{{if .MoodTrend}}
+	<h3>Mood trend</h3>
+	<p>{{.MoodTrend}}</p>
+	{{end}}
+	<h3>Writing stats</h3>
+	<table cellpadding="6" style="border-collapse:collapse;">
+		<tr><td>Entries</td><td><b>{{.Stats.EntryCount}}</b></td></tr>
+		<tr><td>Days written</td><td><b>{{.Stats.DaysWritten}}</b> of 7</td></tr>
+		<tr><td>Words</td><td><b>{{.Stats.TotalWords}}</b> ({{.Stats.AverageWords}} per entry)</td></tr>
+		<tr><td>Longest entry</td><td><b>{{.Stats.LongestEntryWords}}</b> words</td></tr>
+	</table>
+	<p style="color:#888; font-size:12px;">You receive this email because the weekly digest is enabled in your diary settings.</p>
+</body>
+</html>`))
+
+// RenderDigestEmail returns the plain text and HTML bodies of a digest email
+func RenderDigestEmail(digest *models.WeeklyDigest) (string, string, error) {
+	var html bytes.Buffer
+	if err := digestEmailTemplate.Execute(&html, digest); err != nil {
+		return "", "", fmt.Errorf("render digest email error: %v", err)
+	}
+
+	var plain strings.Builder
+	fmt.Fprintf(&plain, "Your week in your diary (%s - %s)\n\n", digest.PeriodStart.Format("Jan 2"), digest.PeriodEnd.Format("Jan 2, 2006"))
+	plain.WriteString(digest.Reflection + "\n\n")
+	for _, highlight := range digest.Highlights {
+		plain.WriteString("- " + highlight + "\n")
+	}
+	if digest.MoodTrend != "" {
+		plain.WriteString("\nMood trend: " + digest.MoodTrend + "\n")
+	}
+	fmt.Fprintf(&plain, "\n%d entries, %d days written, %d words\n",
+		digest.Stats.EntryCount, digest.Stats.DaysWritten, digest.Stats.TotalWords)
+
+	return plain.String(), html.String(), nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/smtp"
 	"os"
+	"personal-diary/models"
 )
 
 type EmailSender struct {
@@ -69,3 +70,14 @@ func (e *EmailSender) SendPasswordResetEmail(to string, resetToken string) error
 
 	return e.Send(to, subject, plainText, htmlBody)
 }
+
+func (e *EmailSender) SendWeeklyDigest(to string, digest *models.WeeklyDigest) error {
+	plainText, htmlBody, err := RenderDigestEmail(digest)
+	if err != nil {
+		return err
+	}
+
+	subject := "📖 Your weekly diary digest"
+
+	return e.Send(to, subject, plainText, htmlBody)
+}
@@ -0,0 +1,273 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"os"
+	"personal-diary/models"
+	"strings"
+	"unicode"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+const (
+	defaultOpenAIEmbeddingModel = "text-embedding-3-small"
+	defaultGeminiEmbeddingModel = "text-embedding-004"
+	hashEmbeddingDims           = 512
+	// embedBatchSize bounds the number of texts sent in one embeddings call
+	embedBatchSize = 64
+)
+
+// Embedder turns texts into vectors whose cosine similarity reflects how
+// close their meanings are. Vectors of different models are not comparable.
+type Embedder interface {
+	Name() string
+	Model() string
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// NewEmbedderFromEnv builds the embedder selected by EMBEDDING_PROVIDER
+// ("openai", "gemini" or "hash"). It defaults to the LLM_PROVIDER's
+// embeddings, and to "hash" for the fake provider. EMBEDDING_MODEL
+// overrides the default model.
+func NewEmbedderFromEnv() (Embedder, error) {
+	model := os.Getenv("EMBEDDING_MODEL")
+
+	name := strings.ToLower(os.Getenv("EMBEDDING_PROVIDER"))
+	if name == "" {
+		name = strings.ToLower(os.Getenv("LLM_PROVIDER"))
+	}
+	switch name {
+	case "", "openai":
+		return NewOpenAIEmbedder(os.Getenv("OPENAI_BASE_URL"), os.Getenv("OPENAI_API_KEY"), model), nil
+	case "gemini":
+		return NewGeminiEmbedder(context.Background(), os.Getenv("GEMINI_API_KEY"), model)
+	case "fake", "hash":
+		return NewHashEmbedder(), nil
+	default:
+		return nil, fmt.Errorf("unknown EMBEDDING_PROVIDER %q", name)
+	}
+}
+
+// OpenAIEmbedder calls an OpenAI-compatible /embeddings endpoint
+type OpenAIEmbedder struct {
+	baseURL string
+	apiKey  string
+	model   string
+	client  *LLMClient
+}
+
+func NewOpenAIEmbedder(baseURL, apiKey, model string) *OpenAIEmbedder {
+	if baseURL == "" {
+		baseURL = defaultOpenAIBaseURL
+	}
+	if model == "" {
+		model = defaultOpenAIEmbeddingModel
+	}
+	baseURL = strings.TrimRight(baseURL, "/")
+	return &OpenAIEmbedder{
+		baseURL: baseURL,
+		apiKey:  apiKey,
+		model:   model,
+		client:  NewLLMClient(baseURL),
+	}
+}
+
+func (e *OpenAIEmbedder) Name() string  { return "openai" }
+func (e *OpenAIEmbedder) Model() string { return e.model }
+
+func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if e.apiKey == "" && e.baseURL == defaultOpenAIBaseURL {
+		return nil, fmt.Errorf("OpenAI API key not configured: %w", ErrLLMNotConfigured)
+	}
+
+	var vectors [][]float32
+	for start := 0; start < len(texts); start += embedBatchSize {
+		end := min(start+embedBatchSize, len(texts))
+		batch, err := e.embedBatch(ctx, texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, batch...)
+	}
+	return vectors, nil
+}
+
+func (e *OpenAIEmbedder) embedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	jsonData, err := json.Marshal(map[string]any{"model": e.model, "input": texts})
+	if err != nil {
+		return nil, fmt.Errorf("marshal request error: %v", err)
+	}
+
+	body, err := e.client.Send(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", e.baseURL+"/embeddings", bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if e.apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+e.apiKey)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+		Usage *models.Usage `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, fmt.Errorf("unmarshal response error: %v", err)
+	}
+	if parsed.Usage != nil {
+		reportUsage(ctx, parsed.Usage.PromptTokens, 0)
+	}
+	if len(parsed.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(parsed.Data))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, item := range parsed.Data {
+		if item.Index < 0 || item.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d out of range", item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
+	return vectors, nil
+}
+
+// GeminiEmbedder embeds texts with a Gemini embedding model
+type GeminiEmbedder struct {
+	client *genai.Client
+	model  string
+}
+
+func NewGeminiEmbedder(ctx context.Context, apiKey, model string) (*GeminiEmbedder, error) {
+	if model == "" {
+		model = defaultGeminiEmbeddingModel
+	}
+	if apiKey == "" {
+		return &GeminiEmbedder{model: model}, nil
+	}
+
+	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Gemini client: %w", err)
+	}
+	return &GeminiEmbedder{client: client, model: model}, nil
+}
+
+func (e *GeminiEmbedder) Name() string  { return "gemini" }
+func (e *GeminiEmbedder) Model() string { return e.model }
+
+func (e *GeminiEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if e.client == nil {
+		return nil, fmt.Errorf("Gemini API key not configured: %w", ErrLLMNotConfigured)
+	}
+
+	em := e.client.EmbeddingModel(e.model)
+	var vectors [][]float32
+	for start := 0; start < len(texts); start += embedBatchSize {
+		batch := em.NewBatch()
+		for _, text := range texts[start:min(start+embedBatchSize, len(texts))] {
+			batch.AddContent(genai.Text(text))
+		}
+		resp, err := em.BatchEmbedContents(ctx, batch)
+		if err != nil {
+			return nil, fmt.Errorf("Gemini embedding error: %w", err)
+		}
+		for _, embedding := range resp.Embeddings {
+			vectors = append(vectors, embedding.Values)
+		}
+	}
+	if len(vectors) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(vectors))
+	}
+	return vectors, nil
+}
+
+// HashEmbedder is an offline embedder that hashes words and word pairs into
+// a fixed number of buckets. It only captures shared vocabulary, not
+// meaning, but needs no API and is deterministic, which suits development.
+type HashEmbedder struct {
+	dims int
+}
+
+func NewHashEmbedder() *HashEmbedder {
+	return &HashEmbedder{dims: hashEmbeddingDims}
+}
+
+func (e *HashEmbedder) Name() string  { return "hash" }
+func (e *HashEmbedder) Model() string { return fmt.Sprintf("hash-%d", e.dims) }
+
+func (e *HashEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, e.dims)
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for j, word := range words {
+			if len(word) < 3 || tagStopwords[word] {
+				continue
+			}
+			vector[e.bucket(word)] += 1
+			if j+1 < len(words) {
+				vector[e.bucket(word+" "+words[j+1])] += 0.5
+			}
+		}
+		normalize(vector)
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+func (e *HashEmbedder) bucket(token string) int {
+	h := fnv.New32a()
+	h.Write([]byte(token))
+	return int(h.Sum32() % uint32(e.dims))
+}
+
+// CosineSimilarity returns the cosine of the angle between two vectors, or 0
+// when their lengths differ or either is zero
+func CosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+func normalize(vector []float32) {
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		return
+	}
+	norm = math.Sqrt(norm)
+	for i := range vector {
+		vector[i] = float32(float64(vector[i]) / norm)
+	}
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"personal-diary/models"
+	"sync"
+	"time"
+)
+
+// subscriberBuffer is how many undelivered events a connection may queue
+// before it is dropped and has to reconnect with Last-Event-ID.
+const subscriberBuffer = 32
+
+// EventBroker fans diary events out to the open SSE connections of each user
+// and keeps a short per-user history so reconnecting clients can catch up.
+type EventBroker struct {
+	mu          sync.Mutex
+	nextID      uint64
+	firstID     uint64
+	replaySize  int
+	replayAge   time.Duration
+	subscribers map[string]map[chan models.DiaryEvent]struct{}
+	history     map[string][]models.DiaryEvent
+	evictedUpTo map[string]uint64 // highest event ID dropped from a user's history
+}
+
+// NewEventBroker keeps up to replaySize events per user for at most replayAge
+func NewEventBroker(replaySize int, replayAge time.Duration) *EventBroker {
+	// IDs start from the boot time so they keep growing across restarts,
+	// which lets Subscribe recognise IDs issued by a previous process.
+	firstID := uint64(time.Now().UnixMilli()) * 1000
+
+	return &EventBroker{
+		nextID:      firstID,
+		firstID:     firstID,
+		replaySize:  replaySize,
+		replayAge:   replayAge,
+		subscribers: make(map[string]map[chan models.DiaryEvent]struct{}),
+		history:     make(map[string][]models.DiaryEvent),
+		evictedUpTo: make(map[string]uint64),
+	}
+}
+
+// Publish assigns the event an ID, records it and delivers it to every
+// connection of the user. Connections that cannot keep up are closed.
+func (b *EventBroker) Publish(email string, event models.DiaryEvent) models.DiaryEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	event.ID = b.nextID
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+
+	b.history[email] = append(b.history[email], event)
+	b.pruneLocked(email, event.OccurredAt)
+
+	for ch := range b.subscribers[email] {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers[email], ch)
+			close(ch)
+		}
+	}
+	return event
+}
+
+// Subscribe registers a new connection for the user. Events newer than
+// lastEventID are returned for replay; complete is false when some of them
+// are no longer available and the client should refetch its entries.
+// The returned function must be called when the connection ends.
+func (b *EventBroker) Subscribe(email string, lastEventID uint64) (<-chan models.DiaryEvent, []models.DiaryEvent, bool, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pruneLocked(email, time.Now())
+
+	var replay []models.DiaryEvent
+	complete := true
+	if lastEventID > 0 {
+		if lastEventID < b.firstID || lastEventID < b.evictedUpTo[email] {
+			complete = false
+		}
+		for _, event := range b.history[email] {
+			if event.ID > lastEventID {
+				replay = append(replay, event)
+			}
+		}
+	}
+
+	ch := make(chan models.DiaryEvent, subscriberBuffer)
+	if b.subscribers[email] == nil {
+		b.subscribers[email] = make(map[chan models.DiaryEvent]struct{})
+	}
+	b.subscribers[email][ch] = struct{}{}
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[email][ch]; ok {
+			delete(b.subscribers[email], ch)
+			close(ch)
+		}
+		if len(b.subscribers[email]) == 0 {
+			delete(b.subscribers, email)
+		}
+	}
+	return ch, replay, complete, unsubscribe
+}
+
+// pruneLocked trims the user's history to the replay window. b.mu must be held.
+func (b *EventBroker) pruneLocked(email string, now time.Time) {
+	events := b.history[email]
+	drop := 0
+	for drop < len(events) && (len(events)-drop > b.replaySize || now.Sub(events[drop].OccurredAt) > b.replayAge) {
+		drop++
+	}
+	if drop == 0 {
+		return
+	}
+
+	b.evictedUpTo[email] = events[drop-1].ID
+	if drop == len(events) {
+		delete(b.history, email)
+		return
+	}
+	b.history[email] = append([]models.DiaryEvent(nil), events[drop:]...)
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// FakeProvider is a deterministic, offline provider for tests and local
+// development. Refine tidies whitespace, capitalisation and final punctuation;
+// Complete returns Reply (or echoes the last message when Reply is nil).
+type FakeProvider struct {
+	Reply func(req CompletionRequest) string
+}
+
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{}
+}
+
+func (p *FakeProvider) Name() string  { return "fake" }
+func (p *FakeProvider) Model() string { return "fake" }
+
+func (p *FakeProvider) Complete(ctx context.Context, req CompletionRequest) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if p.Reply != nil {
+		return p.Reply(req), nil
+	}
+	if len(req.Messages) == 0 {
+		return "", nil
+	}
+	return req.Messages[len(req.Messages)-1].Content, nil
+}
+
+// CompleteStream delivers the Complete reply word by word
+func (p *FakeProvider) CompleteStream(ctx context.Context, req CompletionRequest, onDelta func(string) error) (string, error) {
+	reply, err := p.Complete(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	if err := fakeStream(ctx, reply, onDelta); err != nil {
+		return "", err
+	}
+	return reply, nil
+}
+
+func (p *FakeProvider) RefineStream(ctx context.Context, text, writingContext, tone string, onDelta func(string) error) (string, error) {
+	refined, err := p.Refine(ctx, text, writingContext, tone)
+	if err != nil {
+		return "", err
+	}
+	if err := fakeStream(ctx, refined, onDelta); err != nil {
+		return "", err
+	}
+	return refined, nil
+}
+
+// fakeStream splits text after each space so the pieces join back exactly
+func fakeStream(ctx context.Context, text string, onDelta func(string) error) error {
+	for _, piece := range strings.SplitAfter(text, " ") {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if piece == "" {
+			continue
+		}
+		if err := onDelta(piece); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var fakeSpaces = regexp.MustCompile(`[ \t]+`)
+
+func (p *FakeProvider) Refine(ctx context.Context, text, writingContext, tone string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	paragraphs := strings.Split(strings.TrimSpace(text), "\n")
+	for i, paragraph := range paragraphs {
+		paragraph = strings.TrimSpace(fakeSpaces.ReplaceAllString(paragraph, " "))
+		paragraphs[i] = paragraph
+		if paragraph == "" {
+			continue
+		}
+		runes := []rune(paragraph)
+		runes[0] = unicode.ToUpper(runes[0])
+		if last := runes[len(runes)-1]; !unicode.IsPunct(last) {
+			runes = append(runes, '.')
+		}
+		paragraphs[i] = string(runes)
+	}
+	return strings.Join(paragraphs, "\n"), nil
+}
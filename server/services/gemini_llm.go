@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+const defaultGeminiModel = "gemini-1.5-flash"
+
+// GeminiProvider runs completions on Gemini through the genai client
+type GeminiProvider struct {
+	client  *genai.Client
+	model   string
+	breaker *CircuitBreaker
+}
+
+func NewGeminiProvider(ctx context.Context, apiKey, model string) (*GeminiProvider, error) {
+	if model == "" {
+		model = defaultGeminiModel
+	}
+	if apiKey == "" {
+		// Keep the provider usable so callers get ErrLLMNotConfigured per request
+		return &GeminiProvider{model: model, breaker: circuitBreakerFor("gemini")}, nil
+	}
+
+	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Gemini client: %w", err)
+	}
+	return &GeminiProvider{client: client, model: model, breaker: circuitBreakerFor("gemini")}, nil
+}
+
+func (p *GeminiProvider) Name() string  { return "gemini" }
+func (p *GeminiProvider) Model() string { return p.model }
+
+func (p *GeminiProvider) Refine(ctx context.Context, text, writingContext, tone string) (string, error) {
+	return refineWithProvider(ctx, p, text, writingContext, tone)
+}
+
+// Complete maps the chat messages onto a Gemini chat session: system
+// messages become the system instruction, earlier turns the history and the
+// last user message is sent.
+func (p *GeminiProvider) Complete(ctx context.Context, req CompletionRequest) (string, error) {
+	chat, last, err := p.startChat(req)
+	if err != nil {
+		return "", err
+	}
+
+	if err := p.breaker.Allow(); err != nil {
+		return "", err
+	}
+	resp, err := chat.SendMessage(ctx, last...)
+	p.recordCall(ctx, err)
+	if err != nil {
+		return "", fmt.Errorf("Gemini request error: %w", err)
+	}
+	reportGeminiUsage(ctx, resp.UsageMetadata)
+
+	text := geminiResponseText(resp)
+	if text == "" {
+		return "", fmt.Errorf("empty response from Gemini")
+	}
+	return text, nil
+}
+
+func (p *GeminiProvider) RefineStream(ctx context.Context, text, writingContext, tone string, onDelta func(string) error) (string, error) {
+	return refineStreamWithProvider(ctx, p, text, writingContext, tone, onDelta)
+}
+
+// CompleteStream is Complete using Gemini's streaming API
+func (p *GeminiProvider) CompleteStream(ctx context.Context, req CompletionRequest, onDelta func(string) error) (string, error) {
+	chat, last, err := p.startChat(req)
+	if err != nil {
+		return "", err
+	}
+
+	var full strings.Builder
+	var usage *genai.UsageMetadata
+	// Every chunk carries the running totals, so only the last one counts
+	defer func() { reportGeminiUsage(ctx, usage) }()
+
+	if err := p.breaker.Allow(); err != nil {
+		return "", err
+	}
+	iter := chat.SendMessageStream(ctx, last...)
+	for {
+		resp, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			p.recordCall(ctx, err)
+			return "", fmt.Errorf("Gemini stream error: %w", err)
+		}
+		if resp.UsageMetadata != nil {
+			usage = resp.UsageMetadata
+		}
+		delta := geminiResponseText(resp)
+		if delta == "" {
+			continue
+		}
+		full.WriteString(delta)
+		if err := onDelta(delta); err != nil {
+			p.recordCall(ctx, nil)
+			return "", err
+		}
+	}
+	p.recordCall(ctx, nil)
+	return strings.TrimSpace(full.String()), nil
+}
+
+// recordCall reports the outcome of a call to the circuit breaker. Blocked
+// replies come from a working service and cancelled calls say nothing
+// about it.
+func (p *GeminiProvider) recordCall(ctx context.Context, err error) {
+	recordGeminiCall(ctx, p.breaker, err)
+}
+
+func recordGeminiCall(ctx context.Context, breaker *CircuitBreaker, err error) {
+	var blocked *genai.BlockedError
+	switch {
+	case ctx.Err() != nil:
+		breaker.Abandon()
+	case err == nil, errors.As(err, &blocked):
+		breaker.Success()
+	default:
+		breaker.Failure()
+	}
+}
+
+// startChat prepares a chat session holding all but the last message, which
+// is returned as the parts to send.
+func (p *GeminiProvider) startChat(req CompletionRequest) (*genai.ChatSession, []genai.Part, error) {
+	if p.client == nil {
+		return nil, nil, fmt.Errorf("Gemini API key not configured: %w", ErrLLMNotConfigured)
+	}
+
+	model := p.client.GenerativeModel(p.model)
+	model.SetTemperature(float32(req.Temperature))
+	if req.MaxTokens > 0 {
+		model.SetMaxOutputTokens(int32(req.MaxTokens))
+	}
+
+	var system []string
+	var history []*genai.Content
+	for _, msg := range req.Messages {
+		switch msg.Role {
+		case "system":
+			system = append(system, msg.Content)
+		case "assistant":
+			history = append(history, &genai.Content{Role: "model", Parts: []genai.Part{genai.Text(msg.Content)}})
+		default:
+			history = append(history, &genai.Content{Role: "user", Parts: []genai.Part{genai.Text(msg.Content)}})
+		}
+	}
+	if len(history) == 0 || history[len(history)-1].Role != "user" {
+		return nil, nil, fmt.Errorf("completion request must end with a user message")
+	}
+	if len(system) > 0 {
+		model.SystemInstruction = genai.NewUserContent(genai.Text(strings.Join(system, "\n\n")))
+	}
+
+	chat := model.StartChat()
+	chat.History = history[:len(history)-1]
+	return chat, history[len(history)-1].Parts, nil
+}
+
+// geminiResponseText joins the text parts of the first candidate
+func geminiResponseText(resp *genai.GenerateContentResponse) string {
+	if resp == nil || len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+		return ""
+	}
+	var text strings.Builder
+	for _, part := range resp.Candidates[0].Content.Parts {
+		if t, ok := part.(genai.Text); ok {
+			text.WriteString(string(t))
+		}
+	}
+	return strings.TrimSpace(text.String())
+}
+
+func reportGeminiUsage(ctx context.Context, usage *genai.UsageMetadata) {
+	if usage != nil {
+		reportUsage(ctx, int(usage.PromptTokenCount), int(usage.CandidatesTokenCount))
+	}
+}
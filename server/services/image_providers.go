@@ -0,0 +1,395 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"os"
+	"personal-diary/models"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+const (
+	defaultOpenAIImageModel = "dall-e-3"
+	defaultGeminiImageModel = "gemini-2.5-flash-image"
+	// geminiAPIBaseURL serves Imagen, which the genai client cannot call
+	geminiAPIBaseURL = "https://generativelanguage.googleapis.com/v1beta"
+	// maxImageBytes bounds images downloaded from a provider's URL
+	maxImageBytes = 20 << 20
+)
+
+// imageDownloadClient fetches images providers only link to. It shares the
+// LLM clients' transport, so its connections are kept alive too.
+var imageDownloadClient = &http.Client{Transport: llmTransport, Timeout: 30 * time.Second}
+
+// GeneratedImage is an encoded image and its content type
+type GeneratedImage struct {
+	Data        []byte
+	ContentType string
+}
+
+// ImageRequest describes an image to generate. Image APIs are only sent
+// Prompt; the procedural renderer also uses the rest.
+type ImageRequest struct {
+	Prompt string
+	// Text is scanned for colour keywords, e.g. the entry's title and content
+	Text string
+	Mood *models.MoodAnalysis
+	// LoadMood supplies Mood when it is nil and the renderer needs it, as
+	// finding it can take a lookup other providers should not wait for
+	LoadMood func() *models.MoodAnalysis
+	// Seed makes procedural images repeatable; 0 derives it from the texts
+	Seed int64
+}
+
+// ImageProvider generates images from text prompts
+type ImageProvider interface {
+	Name() string
+	Model() string
+	GenerateImage(ctx context.Context, req ImageRequest) (*GeneratedImage, error)
+}
+
+// NewImageProviderFromEnv builds the image provider selected by
+// IMAGE_PROVIDER ("openai", "gemini" or "procedural"). It defaults to the
+// LLM_PROVIDER's images, and to "procedural" for the fake provider.
+// IMAGE_MODEL overrides the default model and IMAGE_SIZE the size of OpenAI
+// images; Gemini models named imagen-* are called through the Imagen API.
+// client is the Gemini client, nil without an API key.
+func NewImageProviderFromEnv(client *genai.Client) (ImageProvider, error) {
+	model := os.Getenv("IMAGE_MODEL")
+
+	name := strings.ToLower(os.Getenv("IMAGE_PROVIDER"))
+	if name == "" {
+		name = strings.ToLower(os.Getenv("LLM_PROVIDER"))
+	}
+	switch name {
+	case "", "openai":
+		return NewOpenAIImageProvider(os.Getenv("OPENAI_BASE_URL"), os.Getenv("OPENAI_API_KEY"), model, os.Getenv("IMAGE_SIZE")), nil
+	case "gemini":
+		if strings.HasPrefix(model, "imagen") {
+			return NewImagenProvider(os.Getenv("GEMINI_API_KEY"), model), nil
+		}
+		return NewGeminiImageProvider(client, model), nil
+	// "placeholder" is the former name of the procedural renderer
+	case "fake", "procedural", "placeholder":
+		return NewProceduralImageProvider(), nil
+	default:
+		return nil, fmt.Errorf("unknown IMAGE_PROVIDER %q", name)
+	}
+}
+
+// NewImageFallbackFromEnv returns the provider used when primary fails: the
+// procedural renderer, unless IMAGE_FALLBACK is "none". It returns nil when
+// there is no fallback.
+func NewImageFallbackFromEnv(primary ImageProvider) (ImageProvider, error) {
+	switch name := strings.ToLower(os.Getenv("IMAGE_FALLBACK")); name {
+	case "", "procedural", "placeholder":
+		if _, ok := primary.(*ProceduralImageProvider); ok {
+			return nil, nil
+		}
+		return NewProceduralImageProvider(), nil
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown IMAGE_FALLBACK %q", name)
+	}
+}
+
+// OpenAIImageProvider calls an OpenAI-compatible /images/generations endpoint
+type OpenAIImageProvider struct {
+	baseURL string
+	apiKey  string
+	model   string
+	size    string
+	client  *LLMClient
+}
+
+func NewOpenAIImageProvider(baseURL, apiKey, model, size string) *OpenAIImageProvider {
+	if baseURL == "" {
+		baseURL = defaultOpenAIBaseURL
+	}
+	if model == "" {
+		model = defaultOpenAIImageModel
+	}
+	if size == "" {
+		size = openAIImageSize(model)
+	}
+	baseURL = strings.TrimRight(baseURL, "/")
+	return &OpenAIImageProvider{
+		baseURL: baseURL,
+		apiKey:  apiKey,
+		model:   model,
+		size:    size,
+		client:  NewLLMClient(baseURL),
+	}
+}
+
+// openAIImageSize is the widest size model supports, as backgrounds are
+// landscape
+func openAIImageSize(model string) string {
+	switch {
+	case strings.HasPrefix(model, "dall-e-3"):
+		return "1792x1024"
+	case strings.HasPrefix(model, "gpt-image"):
+		return "1536x1024"
+	default:
+		return "1024x1024"
+	}
+}
+
+func (p *OpenAIImageProvider) Name() string  { return "openai" }
+func (p *OpenAIImageProvider) Model() string { return p.model }
+
+func (p *OpenAIImageProvider) GenerateImage(ctx context.Context, req ImageRequest) (*GeneratedImage, error) {
+	if p.apiKey == "" && p.baseURL == defaultOpenAIBaseURL {
+		return nil, fmt.Errorf("OpenAI API key not configured: %w", ErrLLMNotConfigured)
+	}
+
+	request := map[string]any{"model": p.model, "prompt": req.Prompt, "n": 1, "size": p.size}
+	// gpt-image models always answer with base64 and reject response_format
+	if !strings.HasPrefix(p.model, "gpt-image") {
+		request["response_format"] = "b64_json"
+	}
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request error: %v", err)
+	}
+
+	body, err := p.client.Send(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/images/generations", bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if p.apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+p.apiKey)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed struct {
+		Data []struct {
+			B64JSON string `json:"b64_json"`
+			URL     string `json:"url"`
+		} `json:"data"`
+		Usage *struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, fmt.Errorf("unmarshal response error: %v", err)
+	}
+	if parsed.Usage != nil {
+		reportUsage(ctx, parsed.Usage.InputTokens, parsed.Usage.OutputTokens)
+	}
+	if len(parsed.Data) == 0 {
+		return nil, fmt.Errorf("no image in response")
+	}
+
+	var data []byte
+	switch {
+	case parsed.Data[0].B64JSON != "":
+		data, err = base64.StdEncoding.DecodeString(parsed.Data[0].B64JSON)
+		if err != nil {
+			return nil, fmt.Errorf("decode image error: %v", err)
+		}
+	case parsed.Data[0].URL != "":
+		// Some compatible servers only return a link to the image
+		data, err = downloadImage(ctx, parsed.Data[0].URL)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("no image in response")
+	}
+	return &GeneratedImage{Data: data, ContentType: http.DetectContentType(data)}, nil
+}
+
+func downloadImage(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := imageDownloadClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download image: status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image data: %w", err)
+	}
+	if len(data) > maxImageBytes {
+		return nil, fmt.Errorf("image is larger than %d bytes", maxImageBytes)
+	}
+	return data, nil
+}
+
+// GeminiImageProvider generates images with a Gemini model that answers
+// with inline images. Imagen models are served by ImagenProvider.
+type GeminiImageProvider struct {
+	client  *genai.Client
+	model   string
+	breaker *CircuitBreaker
+}
+
+func NewGeminiImageProvider(client *genai.Client, model string) *GeminiImageProvider {
+	if model == "" {
+		model = defaultGeminiImageModel
+	}
+	return &GeminiImageProvider{client: client, model: model, breaker: circuitBreakerFor("gemini")}
+}
+
+func (p *GeminiImageProvider) Name() string  { return "gemini" }
+func (p *GeminiImageProvider) Model() string { return p.model }
+
+func (p *GeminiImageProvider) GenerateImage(ctx context.Context, req ImageRequest) (*GeneratedImage, error) {
+	if p.client == nil {
+		return nil, fmt.Errorf("Gemini API key not configured: %w", ErrLLMNotConfigured)
+	}
+	if err := p.breaker.Allow(); err != nil {
+		return nil, err
+	}
+
+	resp, err := p.client.GenerativeModel(p.model).GenerateContent(ctx, genai.Text(req.Prompt))
+	recordGeminiCall(ctx, p.breaker, err)
+	if err != nil {
+		return nil, fmt.Errorf("Gemini image error: %w", err)
+	}
+	reportGeminiUsage(ctx, resp.UsageMetadata)
+
+	for _, candidate := range resp.Candidates {
+		if candidate.Content == nil {
+			continue
+		}
+		for _, part := range candidate.Content.Parts {
+			if blob, ok := part.(genai.Blob); ok && strings.HasPrefix(blob.MIMEType, "image/") && len(blob.Data) > 0 {
+				return &GeneratedImage{Data: blob.Data, ContentType: blob.MIMEType}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("Gemini model %s returned no image", p.model)
+}
+
+// ImagenProvider calls the Imagen :predict endpoint of the Gemini API with
+// the Gemini API key
+type ImagenProvider struct {
+	apiKey string
+	model  string
+	client *LLMClient
+}
+
+func NewImagenProvider(apiKey, model string) *ImagenProvider {
+	return &ImagenProvider{apiKey: apiKey, model: model, client: NewLLMClient(geminiAPIBaseURL)}
+}
+
+func (p *ImagenProvider) Name() string  { return "gemini" }
+func (p *ImagenProvider) Model() string { return p.model }
+
+func (p *ImagenProvider) GenerateImage(ctx context.Context, req ImageRequest) (*GeneratedImage, error) {
+	if p.apiKey == "" {
+		return nil, fmt.Errorf("Gemini API key not configured: %w", ErrLLMNotConfigured)
+	}
+
+	jsonData, err := json.Marshal(map[string]any{
+		"instances": []map[string]any{{"prompt": req.Prompt}},
+		// Backgrounds are landscape
+		"parameters": map[string]any{"sampleCount": 1, "aspectRatio": "16:9"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal request error: %v", err)
+	}
+
+	url := geminiAPIBaseURL + "/models/" + p.model + ":predict"
+	body, err := p.client.Send(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("x-goog-api-key", p.apiKey)
+		return req, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Imagen error: %w", err)
+	}
+
+	var parsed struct {
+		Predictions []struct {
+			BytesBase64Encoded string `json:"bytesBase64Encoded"`
+			MIMEType           string `json:"mimeType"`
+		} `json:"predictions"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, fmt.Errorf("unmarshal response error: %v", err)
+	}
+	// Prompts the safety filter rejects come back without predictions
+	if len(parsed.Predictions) == 0 || parsed.Predictions[0].BytesBase64Encoded == "" {
+		return nil, fmt.Errorf("Imagen model %s returned no image", p.model)
+	}
+	data, err := base64.StdEncoding.DecodeString(parsed.Predictions[0].BytesBase64Encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decode image error: %v", err)
+	}
+	contentType := parsed.Predictions[0].MIMEType
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	return &GeneratedImage{Data: data, ContentType: contentType}, nil
+}
+
+// ProceduralImageProvider renders backgrounds offline with RenderBackground.
+// It needs no API and is the fallback when the configured provider fails.
+// Its images cost nothing, so they are not metered.
+type ProceduralImageProvider struct{}
+
+func NewProceduralImageProvider() *ProceduralImageProvider {
+	return &ProceduralImageProvider{}
+}
+
+func (p *ProceduralImageProvider) Name() string  { return "local" }
+func (p *ProceduralImageProvider) Model() string { return "procedural" }
+
+func (p *ProceduralImageProvider) GenerateImage(ctx context.Context, req ImageRequest) (*GeneratedImage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if req.Mood == nil && req.LoadMood != nil {
+		req.Mood = req.LoadMood()
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, RenderBackground(req), &jpeg.Options{Quality: 88}); err != nil {
+		return nil, fmt.Errorf("failed to encode background image: %w", err)
+	}
+	return &GeneratedImage{Data: buf.Bytes(), ContentType: "image/jpeg"}, nil
+}
+
+// imageExtension is the file extension for an image content type
+func imageExtension(contentType string) string {
+	switch contentType {
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	default:
+		return ".jpg"
+	}
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"context"
+	"personal-diary/models"
+	"testing"
+)
+
+func TestProceduralImageProviderLoadsMood(t *testing.T) {
+	calm := &models.MoodAnalysis{Score: 0.5, Energy: 0.1}
+
+	tests := []struct {
+		name  string
+		mood  *models.MoodAnalysis
+		loads int
+	}{
+		{"loads a missing mood once", nil, 1},
+		{"keeps a given mood", calm, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loads := 0
+			req := ImageRequest{
+				Text: "A quiet walk by the sea",
+				Mood: tt.mood,
+				LoadMood: func() *models.MoodAnalysis {
+					loads++
+					return calm
+				},
+				Seed: 42,
+			}
+			image, err := NewProceduralImageProvider().GenerateImage(context.Background(), req)
+			if err != nil {
+				t.Fatalf("GenerateImage: %v", err)
+			}
+			if image.ContentType != "image/jpeg" || len(image.Data) == 0 {
+				t.Errorf("got %d bytes of %s", len(image.Data), image.ContentType)
+			}
+			if loads != tt.loads {
+				t.Errorf("LoadMood called %d times, want %d", loads, tt.loads)
+			}
+		})
+	}
+}
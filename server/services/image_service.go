@@ -4,18 +4,16 @@
 package services
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"image"
-	"image/color"
-	"image/jpeg"
 	"image/png"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"personal-diary/models"
 	"strings"
 	"time"
 
@@ -26,6 +24,9 @@ import (
 type ImageGenerationService struct {
 	Client    *genai.Client
 	UploadDir string
+	// Provider generates the images; Fallback, if set, is used when it fails
+	Provider ImageProvider
+	Fallback ImageProvider
 }
 
 // ImageResult contains both file path and URL
@@ -33,6 +34,7 @@ type ImageResult struct {
 	FilePath string // Full file system path
 	URL      string // Web-accessible URL path
 	Size     int64  // File size in bytes
+	Model    string // Model that generated the image
 }
 
 func NewImageGenerationService(apiKey, uploadDir string) (*ImageGenerationService, error) {
@@ -61,72 +63,110 @@ func NewImageGenerationService(apiKey, uploadDir string) (*ImageGenerationServic
 
 	log.Printf("Upload directory created/verified: %s", absUploadDir)
 
+	// Without an API key the Gemini image provider reports it is not configured
+	var imageClient *genai.Client
+	if apiKey != "" {
+		imageClient = client
+	}
+	provider, err := NewImageProviderFromEnv(imageClient)
+	if err != nil {
+		return nil, err
+	}
+	fallback, err := NewImageFallbackFromEnv(provider)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Image provider: %s (model %s)", provider.Name(), provider.Model())
+
 	return &ImageGenerationService{
 		Client:    client,
 		UploadDir: absUploadDir,
+		Provider:  provider,
+		Fallback:  fallback,
 	}, nil
 }
 
-func (s *ImageGenerationService) GenerateImagePrompt(ctx context.Context, content, title string) (string, error) {
-	model := s.Client.GenerativeModel("gemini-1.5-flash")
-
-	prompt := fmt.Sprintf(`Based on the following diary entry content, create a detailed, artistic image prompt that would make a beautiful background image for this diary entry.
-
-Guidelines:
-- Focus on creating atmospheric, aesthetic backgrounds
-- Include artistic styles (watercolor, digital art, photography, etc.)
-- Mention colors, lighting, and mood
-- Keep it suitable as a subtle background (not too busy)
-- Make it emotionally resonant with the diary content
-- Include keywords like "soft", "dreamy", "atmospheric" for background suitability
-
-Diary Title: %s
-Diary Content: %s
-
-Generate only the image prompt (no explanations):`, title, content)
-
-	resp, err := model.GenerateContent(ctx, genai.Text(prompt))
+// GenerateImagePrompt has the chat model describe a background image for
+// the entry. It also returns the label of the prompt version used.
+func (s *ImageGenerationService) GenerateImagePrompt(ctx context.Context, provider LLMProvider, content, title string) (string, string, error) {
+	prompt, version, err := renderPrompt(ctx, PromptImage, ImagePromptData{Title: title, Content: content})
 	if err != nil {
-		return "", fmt.Errorf("failed to generate image prompt: %w", err)
+		return "", "", err
 	}
 
-	if resp == nil || len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
-		return "", fmt.Errorf("no prompt generated")
+	reply, err := provider.Complete(ctx, CompletionRequest{
+		Messages:    []models.Message{{Role: "user", Content: prompt}},
+		Temperature: 0.8,
+		MaxTokens:   300,
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate image prompt: %w", err)
 	}
-
-	generatedPrompt := fmt.Sprintf("%v", resp.Candidates[0].Content.Parts[0])
-	return strings.TrimSpace(generatedPrompt), nil
+	if reply = strings.TrimSpace(reply); reply == "" {
+		return "", "", fmt.Errorf("no prompt generated")
+	}
+	return reply, version, nil
 }
 
 // GenerateImage returns full file system path (backward compatibility)
 func (s *ImageGenerationService) GenerateImage(ctx context.Context, prompt string) (string, error) {
-	result, err := s.GenerateImageWithPaths(ctx, prompt)
+	result, err := s.GenerateImageWithPaths(ctx, ImageRequest{Prompt: prompt})
 	if err != nil {
 		return "", err
 	}
 	return result.FilePath, nil
 }
 
-// GenerateImageWithPaths returns both file path and URL with proper image generation
-func (s *ImageGenerationService) GenerateImageWithPaths(ctx context.Context, prompt string) (*ImageResult, error) {
-	// Generate unique filename with timestamp
-	timestamp := time.Now().Unix()
-	filename := fmt.Sprintf("bg_%d.jpg", timestamp)
-	filePath := filepath.Join(s.UploadDir, filename)
+// GenerateImageWithPaths generates an image with the provider, or the
+// fallback when the provider fails, and saves it to the upload directory
+func (s *ImageGenerationService) GenerateImageWithPaths(ctx context.Context, req ImageRequest) (*ImageResult, error) {
+	return s.generateAndSave(ctx, req, s.Provider, s.Fallback)
+}
 
-	log.Printf("Generating image file: %s", filePath)
-	log.Printf("Using prompt: %s", prompt)
+// CanRenderOffline reports whether the procedural renderer is the provider
+// or the fallback
+func (s *ImageGenerationService) CanRenderOffline() bool {
+	return s.offlineProvider() != nil
+}
 
-	// Try to generate actual image using an API or create a proper placeholder
-	imageData, err := s.generateActualImage(ctx, prompt)
-	if err != nil {
-		log.Printf("Failed to generate actual image, creating placeholder: %v", err)
-		// Create a proper image placeholder instead of text
-		imageData, err = s.createPlaceholderImage(prompt)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create placeholder image: %w", err)
+// GenerateOfflineImage renders an image with the procedural renderer, which
+// needs no prompt, and saves it to the upload directory
+func (s *ImageGenerationService) GenerateOfflineImage(ctx context.Context, req ImageRequest) (*ImageResult, error) {
+	offline := s.offlineProvider()
+	if offline == nil {
+		return nil, fmt.Errorf("no offline image renderer configured")
+	}
+	return s.generateAndSave(ctx, req, offline, nil)
+}
+
+func (s *ImageGenerationService) offlineProvider() ImageProvider {
+	for _, provider := range []ImageProvider{s.Provider, s.Fallback} {
+		if _, ok := provider.(*ProceduralImageProvider); ok {
+			return provider
 		}
 	}
+	return nil
+}
+
+func (s *ImageGenerationService) generateAndSave(ctx context.Context, req ImageRequest, provider, fallback ImageProvider) (*ImageResult, error) {
+	log.Printf("Using prompt: %s", req.Prompt)
+
+	generated, err := provider.GenerateImage(ctx, req)
+	// A cancelled request wants no image
+	if err != nil && fallback != nil && ctx.Err() == nil {
+		log.Printf("Image provider %s failed, using %s: %v", provider.Name(), fallback.Name(), err)
+		provider = fallback
+		generated, err = provider.GenerateImage(ctx, req)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate image: %w", err)
+	}
+	imageData := generated.Data
+
+	// Generate unique filename with timestamp
+	filename := fmt.Sprintf("bg_%d%s", time.Now().UnixNano(), imageExtension(generated.ContentType))
+	filePath := filepath.Join(s.UploadDir, filename)
+	log.Printf("Saving image file: %s", filePath)
 
 	// Write the image data to file
 	if err := os.WriteFile(filePath, imageData, 0644); err != nil {
@@ -155,71 +195,10 @@ func (s *ImageGenerationService) GenerateImageWithPaths(ctx context.Context, pro
 		FilePath: absolutePath,
 		URL:      urlPath,
 		Size:     fileInfo.Size(),
+		Model:    provider.Model(),
 	}, nil
 }
 
-// generateActualImage attempts to generate a real image using an external API
-func (s *ImageGenerationService) generateActualImage(ctx context.Context, prompt string) ([]byte, error) {
-	// Option 1: Use DALL-E API (if you have OpenAI API key)
-	// Replace with your actual image generation API
-	
-	// Option 2: Use Stability AI API
-	// Replace with your actual image generation API
-	
-	// Option 3: Use any other image generation service
-	// For now, return an error to fall back to placeholder
-	
-	return nil, fmt.Errorf("actual image generation not implemented - add your preferred image API here")
-}
-
-// createPlaceholderImage creates a proper image file instead of text
-func (s *ImageGenerationService) createPlaceholderImage(prompt string) ([]byte, error) {
-	// Create a simple colored image as placeholder
-	width, height := 1024, 768
-	img := image.NewRGBA(image.Rect(0, 0, width, height))
-	
-	// Create a gradient background based on prompt keywords
-	bgColor := s.getColorFromPrompt(prompt)
-	
-	// Fill with gradient
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			// Create a subtle gradient
-			alpha := uint8(255 - (y*50/height))
-			img.Set(x, y, color.RGBA{bgColor.R, bgColor.G, bgColor.B, alpha})
-		}
-	}
-	
-	// Encode as JPEG
-	var buf bytes.Buffer
-	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
-		return nil, fmt.Errorf("failed to encode placeholder image: %w", err)
-	}
-	
-	return buf.Bytes(), nil
-}
-
-// getColorFromPrompt returns a color based on prompt keywords
-func (s *ImageGenerationService) getColorFromPrompt(prompt string) color.RGBA {
-	promptLower := strings.ToLower(prompt)
-	
-	// Define color themes based on keywords
-	switch {
-	case strings.Contains(promptLower, "sunset") || strings.Contains(promptLower, "warm"):
-		return color.RGBA{255, 165, 0, 255} // Orange
-	case strings.Contains(promptLower, "ocean") || strings.Contains(promptLower, "blue"):
-		return color.RGBA{70, 130, 180, 255} // Steel Blue
-	case strings.Contains(promptLower, "forest") || strings.Contains(promptLower, "green"):
-		return color.RGBA{34, 139, 34, 255} // Forest Green
-	case strings.Contains(promptLower, "purple") || strings.Contains(promptLower, "lavender"):
-		return color.RGBA{147, 112, 219, 255} // Medium Purple
-	case strings.Contains(promptLower, "pink") || strings.Contains(promptLower, "rose"):
-		return color.RGBA{255, 182, 193, 255} // Light Pink
-	default:
-		return color.RGBA{173, 216, 230, 255} // Light Blue
-	}
-}
-
 // DownloadAndSaveImage downloads an image from URL and saves it locally
 func (s *ImageGenerationService) DownloadAndSaveImage(ctx context.Context, imageURL string) (*ImageResult, error) {
 	// Create HTTP request with context
@@ -229,8 +208,7 @@ func (s *ImageGenerationService) DownloadAndSaveImage(ctx context.Context, image
 	}
 
 	// Download the image
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := imageDownloadClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to download image: %w", err)
 	}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	jobRetryBase = 5 * time.Second
+	jobRetryMax  = 5 * time.Minute
+)
+
+type permanentJobError struct{ err error }
+
+func (e permanentJobError) Error() string { return e.err.Error() }
+func (e permanentJobError) Unwrap() error { return e.err }
+
+// PermanentJobError marks a job failure that retrying cannot fix, such as
+// invalid input, so the job fails at once
+func PermanentJobError(err error) error {
+	return permanentJobError{err: err}
+}
+
+// JobRetryable reports whether a job that failed with err may be tried
+// again. Exceeded quotas and a missing provider do not go away by retrying.
+func JobRetryable(err error) bool {
+	var permanent permanentJobError
+	var quotaErr *QuotaExceededError
+	switch {
+	case errors.As(err, &permanent), errors.As(err, &quotaErr), errors.Is(err, ErrLLMNotConfigured):
+		return false
+	}
+	return true
+}
+
+// JobRetryDelay is how long a job waits after its attempt-th failure. The
+// delay doubles with every attempt up to a limit, with 20% jitter so jobs
+// that failed together do not retry together.
+func JobRetryDelay(attempt int) time.Duration {
+	delay := jobRetryMax
+	if attempt >= 1 && attempt < 16 {
+		delay = min(jobRetryMax, jobRetryBase<<(attempt-1))
+	}
+	jitter := 0.8 + 0.4*rand.Float64()
+	return time.Duration(float64(delay) * jitter)
+}
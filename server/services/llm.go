@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"personal-diary/models"
+	"strings"
+)
+
+// ErrLLMNotConfigured is returned by providers that lack the credentials or
+// endpoint they need. Callers use it to fall back to local behaviour.
+var ErrLLMNotConfigured = errors.New("LLM provider not configured")
+
+// CompletionRequest is a provider-independent chat completion request
+type CompletionRequest struct {
+	Messages    []models.Message
+	Temperature float64
+	MaxTokens   int
+}
+
+// TextRefiner rewrites text for a writing context and tone
+type TextRefiner interface {
+	Refine(ctx context.Context, text, writingContext, tone string) (string, error)
+}
+
+// StreamingRefiner refines text and reports the output as it is generated.
+// onDelta receives each new piece; returning an error from it stops the
+// generation. The full refined text is returned at the end.
+type StreamingRefiner interface {
+	RefineStream(ctx context.Context, text, writingContext, tone string, onDelta func(string) error) (string, error)
+}
+
+// LLMProvider is a chat model backend. Every provider can also refine text.
+type LLMProvider interface {
+	TextRefiner
+	StreamingRefiner
+	Name() string
+	Model() string
+	Complete(ctx context.Context, req CompletionRequest) (string, error)
+	// CompleteStream is Complete with incremental output; cancelling ctx
+	// aborts the upstream generation.
+	CompleteStream(ctx context.Context, req CompletionRequest, onDelta func(string) error) (string, error)
+}
+
+// NewLLMProviderFromEnv builds the provider selected by LLM_PROVIDER
+// ("openai", "gemini" or "fake"; default "openai"). LLM_MODEL overrides the
+// provider's default model.
+func NewLLMProviderFromEnv() (LLMProvider, error) {
+	model := os.Getenv("LLM_MODEL")
+
+	switch name := strings.ToLower(os.Getenv("LLM_PROVIDER")); name {
+	case "", "openai":
+		return NewOpenAIProvider(os.Getenv("OPENAI_BASE_URL"), os.Getenv("OPENAI_API_KEY"), model), nil
+	case "gemini":
+		return NewGeminiProvider(context.Background(), os.Getenv("GEMINI_API_KEY"), model)
+	case "fake":
+		return NewFakeProvider(), nil
+	default:
+		return nil, fmt.Errorf("unknown LLM_PROVIDER %q", name)
+	}
+}
+
+// refineWithProvider implements TextRefiner for providers in terms of Complete
+func refineWithProvider(ctx context.Context, provider LLMProvider, text, writingContext, tone string) (string, error) {
+	req, err := refineCompletionRequest(ctx, provider.Model(), text, writingContext, tone)
+	if err != nil {
+		return "", err
+	}
+	return provider.Complete(ctx, req)
+}
+
+// refineStreamWithProvider implements StreamingRefiner in terms of CompleteStream
+func refineStreamWithProvider(ctx context.Context, provider LLMProvider, text, writingContext, tone string, onDelta func(string) error) (string, error) {
+	req, err := refineCompletionRequest(ctx, provider.Model(), text, writingContext, tone)
+	if err != nil {
+		return "", err
+	}
+	return provider.CompleteStream(ctx, req, onDelta)
+}
+
+func refineCompletionRequest(ctx context.Context, model, text, writingContext, tone string) (CompletionRequest, error) {
+	prompt, _, err := renderPrompt(ctx, PromptRefine, RefinePromptData{
+		Text:    text,
+		Context: strings.ToLower(writingContext),
+		Tone:    strings.ToLower(tone),
+	})
+	if err != nil {
+		return CompletionRequest{}, err
+	}
+	return CompletionRequest{
+		Messages: []models.Message{
+			{Role: "system", Content: "You are a helpful writing assistant..."},
+			{Role: "user", Content: prompt},
+		},
+		Temperature: 0.3,
+		MaxTokens:   refineMaxTokens(model, text),
+	}, nil
+}
+
+// parseJSONReply decodes a model reply that is expected to be a JSON object.
+// Models occasionally wrap the object in markdown fences or add a sentence
+// around it, so only the outermost {...} block is decoded.
+func parseJSONReply(reply string, v any) error {
+	start := strings.Index(reply, "{")
+	end := strings.LastIndex(reply, "}")
+	if start == -1 || end < start {
+		return fmt.Errorf("no JSON object in model reply")
+	}
+	if err := json.Unmarshal([]byte(reply[start:end+1]), v); err != nil {
+		return fmt.Errorf("parse model reply error: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	llmMaxAttempts = 3
+	// llmAttemptTimeout bounds one attempt of a request whose reply is read
+	// whole; streams are bounded by the caller's context only
+	llmAttemptTimeout = 90 * time.Second
+	llmRetryBase      = time.Second
+	llmRetryMax       = 20 * time.Second
+	// llmMaxRetryAfter is the longest Retry-After waited for; a provider
+	// asking for more fails the request instead of holding it open
+	llmMaxRetryAfter = 30 * time.Second
+)
+
+// llmTransport is shared by every LLM client so connections to a provider
+// are kept alive between requests
+var llmTransport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   20,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+// LLMStatusError is a non-200 reply from a provider
+type LLMStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *LLMStatusError) Error() string {
+	return fmt.Sprintf("non-200 response: %d\n%s", e.StatusCode, e.Body)
+}
+
+// retryable reports whether the status is worth another attempt
+func (e *LLMStatusError) retryable() bool {
+	return e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// LLMClient sends requests to an LLM provider's HTTP API. Requests are bound
+// to the caller's context, so they stop when the client goes away. Failed
+// attempts are retried with jittered exponential backoff, or after the
+// provider's Retry-After, and a circuit breaker per provider fails requests
+// fast while it is down.
+type LLMClient struct {
+	http    *http.Client
+	breaker *CircuitBreaker
+}
+
+// NewLLMClient returns a client for the provider at baseURL; clients of the
+// same provider share its circuit breaker
+func NewLLMClient(baseURL string) *LLMClient {
+	return &LLMClient{
+		http:    &http.Client{Transport: llmTransport},
+		breaker: circuitBreakerFor(baseURL),
+	}
+}
+
+// Send sends the request made by newRequest and returns the body of the 200
+// reply. newRequest is called for every attempt, as a body can only be sent
+// once.
+func (c *LLMClient) Send(ctx context.Context, newRequest func(context.Context) (*http.Request, error)) ([]byte, error) {
+	var body []byte
+	err := c.do(ctx, func(ctx context.Context) (http.Header, error) {
+		attemptCtx, cancel := context.WithTimeout(ctx, llmAttemptTimeout)
+		defer cancel()
+
+		resp, err := c.send(attemptCtx, newRequest)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		body, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("read response error: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return resp.Header, &LLMStatusError{StatusCode: resp.StatusCode, Body: string(body)}
+		}
+		return nil, nil
+	})
+	return body, err
+}
+
+// Open is Send for streamed replies: it returns the 200 response once its
+// headers arrive, and the caller reads and closes the body
+func (c *LLMClient) Open(ctx context.Context, newRequest func(context.Context) (*http.Request, error)) (*http.Response, error) {
+	var opened *http.Response
+	err := c.do(ctx, func(ctx context.Context) (http.Header, error) {
+		resp, err := c.send(ctx, newRequest)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			return resp.Header, &LLMStatusError{StatusCode: resp.StatusCode, Body: string(body)}
+		}
+		opened = resp
+		return nil, nil
+	})
+	return opened, err
+}
+
+func (c *LLMClient) send(ctx context.Context, newRequest func(context.Context) (*http.Request, error)) (*http.Response, error) {
+	req, err := newRequest(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("create request error: %w", err)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("send request error: %w", err)
+	}
+	return resp, nil
+}
+
+// do runs attempt until it succeeds, fails for good or the attempts run
+// out. attempt returns the reply headers of a failed request, which may
+// say when to retry.
+func (c *LLMClient) do(ctx context.Context, attempt func(context.Context) (http.Header, error)) error {
+	for n := 1; ; n++ {
+		if err := c.breaker.Allow(); err != nil {
+			return err
+		}
+		header, err := attempt(ctx)
+		if ctx.Err() != nil {
+			// The caller went away; that says nothing about the provider
+			c.breaker.Abandon()
+			return ctx.Err()
+		}
+
+		var statusErr *LLMStatusError
+		isStatus := errors.As(err, &statusErr)
+		switch {
+		case err == nil:
+			c.breaker.Success()
+			return nil
+		case isStatus && statusErr.StatusCode < 500:
+			// The provider is up, it refused this request
+			c.breaker.Success()
+			if !statusErr.retryable() {
+				return err
+			}
+		default:
+			c.breaker.Failure()
+		}
+		if n == llmMaxAttempts {
+			return err
+		}
+
+		delay, ok := retryAfter(header)
+		if !ok {
+			delay = llmBackoff(n)
+		}
+		if delay > llmMaxRetryAfter {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// llmBackoff is the wait after the n-th failed attempt: exponential, with
+// full jitter in its upper half
+func llmBackoff(n int) time.Duration {
+	delay := min(llmRetryMax, llmRetryBase<<(n-1))
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// retryAfter reads how long the provider asked to wait, from Retry-After in
+// seconds or as a date, or OpenAI's retry-after-ms
+func retryAfter(header http.Header) (time.Duration, bool) {
+	if header == nil {
+		return 0, false
+	}
+	if ms, err := strconv.ParseFloat(header.Get("Retry-After-Ms"), 64); err == nil && ms >= 0 {
+		return time.Duration(ms * float64(time.Millisecond)), true
+	}
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(0, time.Until(at)), true
+	}
+	return 0, false
+}
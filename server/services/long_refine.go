@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxRefineChars bounds the text one refine request accepts
+	MaxRefineChars = 50000
+	// refineWorkers bounds concurrent chunk refinements per request
+	refineWorkers = 3
+	// refineChunkTokens caps a chunk so each reply stays short and reliable
+	refineChunkTokens = 1000
+	// minRefinedRatio is how short a refined chunk may get relative to its
+	// original before it is treated as truncated
+	minRefinedRatio = 0.4
+)
+
+// ChunkedRefiner refines texts too long for one reply by splitting them at
+// paragraph boundaries and refining the chunks concurrently. Texts that fit
+// are passed to the wrapped refiner unchanged.
+type ChunkedRefiner struct {
+	Refiner  TextRefiner
+	Streamer StreamingRefiner
+	Model    string
+}
+
+func (r ChunkedRefiner) Refine(ctx context.Context, text, writingContext, tone string) (string, error) {
+	chunks := r.chunks(text)
+	if len(chunks) == 1 {
+		return r.Refiner.Refine(ctx, text, writingContext, tone)
+	}
+	return r.refineChunks(ctx, chunks, writingContext, tone, nil)
+}
+
+// RefineStream streams single-chunk texts token by token; longer texts are
+// streamed a chunk at a time, in order, as soon as each one is refined
+func (r ChunkedRefiner) RefineStream(ctx context.Context, text, writingContext, tone string, onDelta func(string) error) (string, error) {
+	chunks := r.chunks(text)
+	if len(chunks) == 1 {
+		return r.Streamer.RefineStream(ctx, text, writingContext, tone, onDelta)
+	}
+	return r.refineChunks(ctx, chunks, writingContext, tone, onDelta)
+}
+
+func (r ChunkedRefiner) chunks(text string) []string {
+	budget := min(refineChunkTokens, ModelMaxOutputTokens(r.Model)/3)
+	return SplitForRefine(r.Model, text, budget)
+}
+
+type refinedChunk struct {
+	text string
+	err  error
+}
+
+// refineChunks refines the chunks with up to refineWorkers at once and joins
+// them in order, passing each finished piece to onDelta when it is set. The
+// first failure cancels the remaining chunks.
+func (r ChunkedRefiner) refineChunks(ctx context.Context, chunks []string, writingContext, tone string, onDelta func(string) error) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]chan refinedChunk, len(chunks))
+	sem := make(chan struct{}, refineWorkers)
+	for i, chunk := range chunks {
+		results[i] = make(chan refinedChunk, 1)
+		go func() {
+			lead, core, trail := splitSurroundingSpace(chunk)
+			if core == "" {
+				results[i] <- refinedChunk{text: chunk}
+				return
+			}
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				results[i] <- refinedChunk{err: ctx.Err()}
+				return
+			}
+			refined, err := r.Refiner.Refine(ctx, core, writingContext, tone)
+			if err == nil {
+				err = checkRefinedLength(i, core, refined)
+			}
+			results[i] <- refinedChunk{text: lead + strings.TrimSpace(refined) + trail, err: err}
+		}()
+	}
+
+	var sb strings.Builder
+	previous := ""
+	for i := range chunks {
+		result := <-results[i]
+		if result.err != nil {
+			return "", result.err
+		}
+		piece := trimRepeatedSentence(previous, result.text)
+		previous = result.text
+		if onDelta != nil {
+			if err := onDelta(piece); err != nil {
+				return "", err
+			}
+		}
+		sb.WriteString(piece)
+	}
+	return sb.String(), nil
+}
+
+// checkRefinedLength rejects a refined chunk that is much shorter than its
+// original, which means the model stopped early or dropped text
+func checkRefinedLength(index int, original, refined string) error {
+	originalLen, refinedLen := utf8.RuneCountInString(original), utf8.RuneCountInString(strings.TrimSpace(refined))
+	if float64(refinedLen) < minRefinedRatio*float64(originalLen) {
+		return fmt.Errorf("refined chunk %d has %d of %d characters, text may have been lost", index+1, refinedLen, originalLen)
+	}
+	return nil
+}
+
+// trimRepeatedSentence drops the first sentence of next when the model
+// repeated the last sentence of the previous chunk
+func trimRepeatedSentence(previous, next string) string {
+	if previous == "" {
+		return next
+	}
+	last := splitSentences(strings.TrimSpace(previous))
+	lead, core, trail := splitSurroundingSpace(next)
+	first := splitSentences(core)
+	if len(last) == 0 || len(first) < 2 {
+		return next
+	}
+	repeated := normalizeSentence(first[0])
+	if len(strings.Fields(repeated)) < 4 || repeated != normalizeSentence(last[len(last)-1]) {
+		return next
+	}
+	return lead + strings.Join(first[1:], "") + trail
+}
+
+func normalizeSentence(sentence string) string {
+	return strings.ToLower(strings.Join(strings.Fields(sentence), " "))
+}
+
+// SplitForRefine splits text into chunks of about budget tokens or fewer for
+// model. It splits between lines where it can, between sentences inside a
+// long paragraph and between words inside a long sentence. The chunks always
+// join back into text exactly.
+func SplitForRefine(model, text string, budget int) []string {
+	chunks := packPieces(model, strings.SplitAfter(text, "\n"), budget, 0)
+	if len(chunks) == 0 || strings.Join(chunks, "") != text {
+		// Never refine a text that was not split losslessly
+		return []string{text}
+	}
+	return chunks
+}
+
+// refineSplitters break a piece that is over budget into smaller ones
+var refineSplitters = []func(string) []string{
+	splitSentences,
+	func(s string) []string { return strings.SplitAfter(s, " ") },
+}
+
+func packPieces(model string, pieces []string, budget, level int) []string {
+	var chunks []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+	}
+
+	for _, piece := range pieces {
+		if piece == "" {
+			continue
+		}
+		if EstimateModelTokens(model, piece) > budget && level < len(refineSplitters) {
+			flush()
+			chunks = append(chunks, packPieces(model, refineSplitters[level](piece), budget, level+1)...)
+			continue
+		}
+		if current.Len() > 0 && EstimateModelTokens(model, current.String()+piece) > budget {
+			flush()
+		}
+		current.WriteString(piece)
+	}
+	flush()
+	return chunks
+}
+
+// sentenceEnd matches the end of a sentence with its closing quotes and the
+// whitespace after it
+var sentenceEnd = regexp.MustCompile(`[.!?]+["'”’)\]]*\s+`)
+
+// splitSentences splits text after each sentence; the pieces join back into
+// text exactly
+func splitSentences(text string) []string {
+	var sentences []string
+	start := 0
+	for _, match := range sentenceEnd.FindAllStringIndex(text, -1) {
+		sentences = append(sentences, text[start:match[1]])
+		start = match[1]
+	}
+	if start < len(text) {
+		sentences = append(sentences, text[start:])
+	}
+	return sentences
+}
+
+// splitSurroundingSpace separates leading and trailing whitespace, which is
+// kept as is so chunks rejoin with their original line breaks
+func splitSurroundingSpace(s string) (lead, core, trail string) {
+	core = strings.TrimLeftFunc(s, unicode.IsSpace)
+	lead = s[:len(s)-len(core)]
+	trimmed := strings.TrimRightFunc(core, unicode.IsSpace)
+	trail = core[len(trimmed):]
+	return lead, trimmed, trail
+}
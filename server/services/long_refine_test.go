@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// refinerFunc adapts a function to TextRefiner
+type refinerFunc func(text string) string
+
+func (f refinerFunc) Refine(ctx context.Context, text, writingContext, tone string) (string, error) {
+	return f(text), nil
+}
+
+// longEntry is several paragraphs, well over one chunk for gpt-4o-mini.
+// Sentences differ, so none is taken for a repeat at a chunk boundary.
+func longEntry() string {
+	var paragraphs []string
+	for p := 1; p <= 4; p++ {
+		var sb strings.Builder
+		for s := 1; s <= 30; s++ {
+			fmt.Fprintf(&sb, "On day %d we walked along the river for the %dth time. ", p, s)
+		}
+		paragraphs = append(paragraphs, strings.TrimSpace(sb.String()))
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+func TestSplitForRefineKeepsUnsplittablePieces(t *testing.T) {
+	// Neither has a line break, sentence end or space to split at
+	longWord := strings.Repeat("a", 2000)
+	cjk := strings.Repeat("今日は晴れで公園を散歩しました", 100)
+
+	tests := []struct {
+		name  string
+		text  string
+		piece string // must stay within one chunk
+	}{
+		{"word longer than the budget", "Before " + longWord + " after.", longWord},
+		{"text without spaces or periods", cjk, cjk},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := SplitForRefine("gpt-4o-mini", tt.text, 100)
+			if strings.Join(chunks, "") != tt.text {
+				t.Fatal("chunks do not join back into the text")
+			}
+			if !slices.ContainsFunc(chunks, func(chunk string) bool { return strings.Contains(chunk, tt.piece) }) {
+				t.Errorf("the piece was cut across %d chunks", len(chunks))
+			}
+		})
+	}
+}
+
+func TestChunkedRefinerKeepsLayout(t *testing.T) {
+	text := "\n" + longEntry() + "\n"
+	// Models drop the whitespace around a chunk; it must come back
+	refiner := refinerFunc(strings.TrimSpace)
+	r := ChunkedRefiner{Refiner: refiner, Streamer: NewFakeProvider(), Model: "gpt-4o-mini"}
+
+	if chunks := r.chunks(text); len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want the entry split", len(chunks))
+	}
+	refined, err := r.Refine(context.Background(), text, "", "")
+	if err != nil {
+		t.Fatalf("Refine: %v", err)
+	}
+	if refined != text {
+		t.Errorf("refined text lost its line breaks")
+	}
+}
+
+func TestChunkedRefinerRejectsTruncatedChunk(t *testing.T) {
+	text := longEntry()
+	refiner := refinerFunc(func(chunk string) string {
+		if strings.Contains(chunk, "On day 3 ") {
+			return chunk[:len(chunk)/4] // the model stopped early
+		}
+		return chunk
+	})
+	r := ChunkedRefiner{Refiner: refiner, Streamer: NewFakeProvider(), Model: "gpt-4o-mini"}
+
+	if _, err := r.Refine(context.Background(), text, "", ""); err == nil {
+		t.Error("Refine accepted a chunk cut to a quarter of its length")
+	}
+}
+
+func TestTrimRepeatedSentence(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous string
+		next     string
+		want     string
+	}{
+		{
+			name:     "repeat differing in case and spacing",
+			previous: "We walked along the river. The sun was setting over the hills.\n",
+			next:     "\nthe SUN was  setting over the hills. Then we went home.",
+			want:     "\nThen we went home.",
+		},
+		{
+			name:     "short sentences may repeat",
+			previous: "I was tired. Good night. ",
+			next:     "Good night. The next day was bright.",
+			want:     "Good night. The next day was bright.",
+		},
+		{
+			name:     "a chunk that is only the repeat is kept",
+			previous: "The sun was setting over the hills.",
+			next:     "The sun was setting over the hills.",
+			want:     "The sun was setting over the hills.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := trimRepeatedSentence(tt.previous, tt.next); got != tt.want {
+				t.Errorf("trimRepeatedSentence = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
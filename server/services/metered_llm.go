@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"personal-diary/models"
+	"strings"
+)
+
+// MeteredProvider wraps an LLMProvider so every call is checked against the
+// caller's quota and recorded in the usage ledger
+type MeteredProvider struct {
+	inner LLMProvider
+	meter *UsageMeter
+}
+
+func NewMeteredProvider(inner LLMProvider, meter *UsageMeter) *MeteredProvider {
+	return &MeteredProvider{inner: inner, meter: meter}
+}
+
+func (p *MeteredProvider) Name() string  { return p.inner.Name() }
+func (p *MeteredProvider) Model() string { return p.inner.Model() }
+
+func (p *MeteredProvider) Complete(ctx context.Context, req CompletionRequest) (string, error) {
+	var reply string
+	err := p.meter.Track(ctx, p.chatCall(req.Messages, func() string { return reply }), func(ctx context.Context) error {
+		var err error
+		reply, err = p.inner.Complete(ctx, req)
+		return err
+	})
+	return reply, err
+}
+
+func (p *MeteredProvider) CompleteStream(ctx context.Context, req CompletionRequest, onDelta func(string) error) (string, error) {
+	var reply string
+	var streamed strings.Builder
+	err := p.meter.Track(ctx, p.chatCall(req.Messages, streamed.String), func(ctx context.Context) error {
+		var err error
+		reply, err = p.inner.CompleteStream(ctx, req, teeDelta(&streamed, onDelta))
+		return err
+	})
+	return reply, err
+}
+
+func (p *MeteredProvider) Refine(ctx context.Context, text, writingContext, tone string) (string, error) {
+	// Only used to estimate tokens when the provider reports none
+	req, _ := refineCompletionRequest(ctx, p.inner.Model(), text, writingContext, tone)
+	var reply string
+	err := p.meter.Track(ctx, p.chatCall(req.Messages, func() string { return reply }), func(ctx context.Context) error {
+		var err error
+		reply, err = p.inner.Refine(ctx, text, writingContext, tone)
+		return err
+	})
+	return reply, err
+}
+
+func (p *MeteredProvider) RefineStream(ctx context.Context, text, writingContext, tone string, onDelta func(string) error) (string, error) {
+	// Only used to estimate tokens when the provider reports none
+	req, _ := refineCompletionRequest(ctx, p.inner.Model(), text, writingContext, tone)
+	var reply string
+	var streamed strings.Builder
+	err := p.meter.Track(ctx, p.chatCall(req.Messages, streamed.String), func(ctx context.Context) error {
+		var err error
+		reply, err = p.inner.RefineStream(ctx, text, writingContext, tone, teeDelta(&streamed, onDelta))
+		return err
+	})
+	return reply, err
+}
+
+// chatCall describes a chat call; output returns the generated text for
+// token estimation
+func (p *MeteredProvider) chatCall(messages []models.Message, output func() string) UsageCall {
+	return UsageCall{
+		Kind:     models.AIUsageChat,
+		Provider: p.inner.Name(),
+		Model:    p.inner.Model(),
+		Estimate: func() (int, int) {
+			return estimateMessageTokens(messages), estimateTokens(output())
+		},
+	}
+}
+
+// teeDelta keeps a copy of streamed output, including output of streams
+// that are cancelled before they finish
+func teeDelta(streamed *strings.Builder, onDelta func(string) error) func(string) error {
+	return func(delta string) error {
+		streamed.WriteString(delta)
+		return onDelta(delta)
+	}
+}
+
+// MeteredEmbedder wraps an Embedder so embedding calls are metered too
+type MeteredEmbedder struct {
+	inner Embedder
+	meter *UsageMeter
+}
+
+func NewMeteredEmbedder(inner Embedder, meter *UsageMeter) *MeteredEmbedder {
+	return &MeteredEmbedder{inner: inner, meter: meter}
+}
+
+func (e *MeteredEmbedder) Name() string  { return e.inner.Name() }
+func (e *MeteredEmbedder) Model() string { return e.inner.Model() }
+
+func (e *MeteredEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	call := UsageCall{
+		Kind:     models.AIUsageEmbedding,
+		Provider: e.inner.Name(),
+		Model:    e.inner.Model(),
+		Estimate: func() (int, int) { return estimateTokens(texts...), 0 },
+	}
+	var vectors [][]float32
+	err := e.meter.Track(ctx, call, func(ctx context.Context) error {
+		var err error
+		vectors, err = e.inner.Embed(ctx, texts)
+		return err
+	})
+	return vectors, err
+}
+
+// MeteredTranscriber wraps a Transcriber so transcriptions are metered too.
+// Without reported usage, the transcript's tokens are counted as output.
+type MeteredTranscriber struct {
+	inner Transcriber
+	meter *UsageMeter
+}
+
+func NewMeteredTranscriber(inner Transcriber, meter *UsageMeter) *MeteredTranscriber {
+	return &MeteredTranscriber{inner: inner, meter: meter}
+}
+
+func (t *MeteredTranscriber) Name() string  { return t.inner.Name() }
+func (t *MeteredTranscriber) Model() string { return t.inner.Model() }
+
+func (t *MeteredTranscriber) Transcribe(ctx context.Context, audio []byte, filename, language string) (*Transcript, error) {
+	var transcript *Transcript
+	call := UsageCall{
+		Kind:     models.AIUsageTranscription,
+		Provider: t.inner.Name(),
+		Model:    t.inner.Model(),
+		Estimate: func() (int, int) {
+			if transcript == nil {
+				return 0, 0
+			}
+			return 0, estimateTokens(transcript.Text)
+		},
+	}
+	err := t.meter.Track(ctx, call, func(ctx context.Context) error {
+		var err error
+		transcript, err = t.inner.Transcribe(ctx, audio, filename, language)
+		return err
+	})
+	return transcript, err
+}
+
+// MeteredImageProvider wraps an ImageProvider so each generated image is
+// metered too
+type MeteredImageProvider struct {
+	inner ImageProvider
+	meter *UsageMeter
+}
+
+func NewMeteredImageProvider(inner ImageProvider, meter *UsageMeter) *MeteredImageProvider {
+	return &MeteredImageProvider{inner: inner, meter: meter}
+}
+
+func (p *MeteredImageProvider) Name() string  { return p.inner.Name() }
+func (p *MeteredImageProvider) Model() string { return p.inner.Model() }
+
+func (p *MeteredImageProvider) GenerateImage(ctx context.Context, req ImageRequest) (*GeneratedImage, error) {
+	call := UsageCall{
+		Kind:     models.AIUsageImage,
+		Provider: p.inner.Name(),
+		Model:    p.inner.Model(),
+		Images:   1,
+	}
+	var image *GeneratedImage
+	err := p.meter.Track(ctx, call, func(ctx context.Context) error {
+		var err error
+		image, err = p.inner.GenerateImage(ctx, req)
+		return err
+	})
+	return image, err
+}
@@ -0,0 +1,293 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"personal-diary/models"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const maxMoodTextChars = 6000
+
+// AnalyzeMood scores the sentiment, dominant emotions and energy of a text
+// with the chat model. When no provider is configured, or the model fails,
+// the local lexicon analysis is used instead.
+func AnalyzeMood(ctx context.Context, provider LLMProvider, text string) *models.MoodAnalysis {
+	analysis, err := analyzeMoodWithLLM(ctx, provider, text)
+	if err != nil {
+		if !errors.Is(err, ErrLLMNotConfigured) && !errors.Is(err, ErrQuotaExceeded) {
+			log.Printf("LLM mood analysis failed, using lexicon: %v", err)
+		}
+		analysis = AnalyzeMoodLexicon(text)
+	}
+	analysis.ContentHash = ContentHash(text)
+	analysis.AnalyzedAt = time.Now()
+	return analysis
+}
+
+func analyzeMoodWithLLM(ctx context.Context, provider LLMProvider, text string) (*models.MoodAnalysis, error) {
+	prompt, version, err := renderPrompt(ctx, PromptMood, MoodPromptData{})
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := provider.Complete(ctx, CompletionRequest{
+		Messages: []models.Message{
+			{Role: "system", Content: prompt},
+			{Role: "user", Content: truncateText(text, maxMoodTextChars)},
+		},
+		Temperature: 0,
+		MaxTokens:   100,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed struct {
+		Score    float64  `json:"score"`
+		Emotions []string `json:"emotions"`
+		Energy   float64  `json:"energy"`
+	}
+	if err := parseJSONReply(reply, &parsed); err != nil {
+		return nil, err
+	}
+
+	emotions := make([]string, 0, len(parsed.Emotions))
+	for _, emotion := range parsed.Emotions {
+		if emotion = strings.ToLower(strings.TrimSpace(emotion)); emotion != "" {
+			emotions = append(emotions, emotion)
+		}
+	}
+	return &models.MoodAnalysis{
+		Score:         clamp(parsed.Score, -1, 1),
+		Emotions:      emotions,
+		Energy:        clamp(parsed.Energy, 0, 1),
+		Source:        models.MoodSourceLLM,
+		Model:         provider.Model(),
+		PromptVersion: version,
+	}, nil
+}
+
+// lexiconWord is the valence (-1..1), arousal (0..1) and emotion of a word
+type lexiconWord struct {
+	valence float64
+	arousal float64
+	emotion string
+}
+
+var moodLexicon = map[string]lexiconWord{
+	// joy
+	"happy": {0.8, 0.6, "joy"}, "glad": {0.6, 0.5, "joy"}, "joy": {0.9, 0.7, "joy"}, "joyful": {0.9, 0.7, "joy"},
+	"great": {0.6, 0.6, "joy"}, "wonderful": {0.8, 0.6, "joy"}, "amazing": {0.8, 0.8, "joy"}, "awesome": {0.8, 0.8, "joy"},
+	"fun": {0.6, 0.7, "joy"}, "laughed": {0.7, 0.7, "joy"}, "smile": {0.6, 0.5, "joy"}, "smiled": {0.6, 0.5, "joy"},
+	"good": {0.4, 0.4, "joy"}, "nice": {0.4, 0.3, "joy"}, "lovely": {0.7, 0.4, "joy"}, "delighted": {0.8, 0.7, "joy"},
+	"cheerful": {0.7, 0.6, "joy"}, "enjoyed": {0.6, 0.5, "joy"},
+	// excitement
+	"excited": {0.7, 0.9, "excitement"}, "thrilled": {0.8, 0.9, "excitement"}, "eager": {0.5, 0.8, "excitement"},
+	"energized": {0.6, 0.9, "excitement"}, "pumped": {0.6, 0.9, "excitement"},
+	// love and gratitude
+	"love": {0.8, 0.6, "love"}, "loved": {0.8, 0.6, "love"}, "adore": {0.8, 0.6, "love"}, "cherish": {0.7, 0.4, "love"},
+	"grateful": {0.8, 0.4, "gratitude"}, "thankful": {0.8, 0.4, "gratitude"}, "blessed": {0.7, 0.4, "gratitude"},
+	"appreciate": {0.6, 0.4, "gratitude"},
+	// pride and hope
+	"proud": {0.7, 0.6, "pride"}, "accomplished": {0.7, 0.6, "pride"}, "achieved": {0.6, 0.6, "pride"},
+	"confident": {0.6, 0.6, "pride"}, "hope": {0.5, 0.5, "hope"}, "hopeful": {0.6, 0.5, "hope"},
+	"optimistic": {0.6, 0.5, "hope"}, "looking forward": {0.6, 0.6, "hope"},
+	// calm
+	"calm": {0.4, 0.1, "calm"}, "peaceful": {0.6, 0.1, "calm"}, "relaxed": {0.5, 0.1, "calm"},
+	"rested": {0.4, 0.2, "calm"}, "serene": {0.6, 0.1, "calm"}, "relieved": {0.5, 0.3, "calm"},
+	// sadness
+	"sad": {-0.7, 0.3, "sadness"}, "unhappy": {-0.7, 0.3, "sadness"}, "cried": {-0.7, 0.5, "sadness"}, "crying": {-0.7, 0.5, "sadness"},
+	"depressed": {-0.9, 0.2, "sadness"}, "miserable": {-0.9, 0.3, "sadness"}, "heartbroken": {-0.9, 0.5, "sadness"},
+	"feeling down": {-0.5, 0.2, "sadness"}, "gloomy": {-0.6, 0.2, "sadness"}, "disappointed": {-0.6, 0.4, "sadness"},
+	"grief": {-0.9, 0.4, "sadness"}, "hurt": {-0.7, 0.5, "sadness"}, "upset": {-0.6, 0.6, "sadness"},
+	// loneliness
+	"lonely": {-0.7, 0.2, "loneliness"}, "alone": {-0.4, 0.2, "loneliness"}, "isolated": {-0.7, 0.2, "loneliness"},
+	"left out": {-0.6, 0.3, "loneliness"},
+	// anger and frustration
+	"angry": {-0.7, 0.9, "anger"}, "furious": {-0.9, 1.0, "anger"}, "mad": {-0.6, 0.8, "anger"}, "hate": {-0.8, 0.8, "anger"},
+	"annoyed": {-0.5, 0.7, "frustration"}, "frustrated": {-0.6, 0.7, "frustration"}, "irritated": {-0.5, 0.7, "frustration"},
+	"stuck": {-0.4, 0.4, "frustration"}, "fed up": {-0.6, 0.6, "frustration"},
+	// fear and anxiety
+	"afraid": {-0.7, 0.8, "fear"}, "scared": {-0.7, 0.8, "fear"}, "terrified": {-0.9, 1.0, "fear"}, "frightened": {-0.7, 0.8, "fear"},
+	"anxious": {-0.6, 0.8, "anxiety"}, "anxiety": {-0.6, 0.8, "anxiety"}, "worried": {-0.5, 0.7, "anxiety"},
+	"nervous": {-0.4, 0.8, "anxiety"}, "stressed": {-0.6, 0.8, "anxiety"}, "stress": {-0.5, 0.7, "anxiety"},
+	"overwhelmed": {-0.7, 0.8, "anxiety"}, "panic": {-0.8, 1.0, "anxiety"}, "tense": {-0.4, 0.7, "anxiety"},
+	// tiredness
+	"tired": {-0.4, 0.1, "tiredness"}, "exhausted": {-0.6, 0.1, "tiredness"}, "drained": {-0.6, 0.1, "tiredness"},
+	"sleepy": {-0.2, 0.1, "tiredness"}, "burned out": {-0.8, 0.2, "tiredness"}, "burnt out": {-0.8, 0.2, "tiredness"},
+	// other negatives
+	"bad": {-0.5, 0.4, "sadness"}, "awful": {-0.8, 0.6, "sadness"}, "terrible": {-0.8, 0.6, "sadness"},
+	"guilty": {-0.6, 0.5, "guilt"}, "ashamed": {-0.7, 0.5, "guilt"}, "regret": {-0.6, 0.4, "guilt"},
+	"bored": {-0.3, 0.1, "boredom"}, "boring": {-0.3, 0.1, "boredom"}, "confused": {-0.3, 0.5, "confusion"},
+}
+
+var moodClauseBreaks = regexp.MustCompile(`[.,;:!?\n]|\bbut\b`)
+
+var negations = map[string]bool{
+	"not": true, "no": true, "never": true, "don't": true, "didn't": true, "isn't": true, "wasn't": true,
+	"aren't": true, "can't": true, "couldn't": true, "won't": true, "hardly": true, "nothing": true,
+}
+
+var intensifiers = map[string]float64{
+	"very": 1.5, "really": 1.4, "so": 1.3, "extremely": 1.8, "incredibly": 1.7, "super": 1.5,
+	"quite": 1.2, "totally": 1.5, "slightly": 0.6, "somewhat": 0.7, "a bit": 0.6, "little": 0.7,
+}
+
+// AnalyzeMoodLexicon is an offline sentiment analysis based on a small word
+// list with handling for negation ("not happy") and intensifiers ("very sad").
+func AnalyzeMoodLexicon(text string) *models.MoodAnalysis {
+	var valenceSum, arousalSum float64
+	var matches int
+	emotionWeight := make(map[string]float64)
+
+	// Negation never crosses a clause boundary ("not happy, stressed")
+	for _, clause := range moodClauseBreaks.Split(strings.ToLower(text), -1) {
+		words := strings.FieldsFunc(clause, func(r rune) bool {
+			return !unicode.IsLetter(r) && r != '\''
+		})
+		negateFor := 0
+		boost := 1.0
+
+		for i := 0; i < len(words); i++ {
+			word := words[i]
+			// Two-word phrases take precedence over single words
+			if i+1 < len(words) {
+				phrase := word + " " + words[i+1]
+				if _, ok := moodLexicon[phrase]; ok {
+					word = phrase
+					i++
+				} else if factor, ok := intensifiers[phrase]; ok {
+					boost, i = factor, i+1
+					continue
+				}
+			}
+
+			if negations[word] {
+				negateFor = 3
+				continue
+			}
+			if factor, ok := intensifiers[word]; ok {
+				boost = factor
+				continue
+			}
+
+			if entry, ok := moodLexicon[word]; ok {
+				valence := entry.valence * boost
+				if negateFor > 0 {
+					// "not happy" is negative, "not sad" only mildly positive
+					valence = -valence * 0.5
+					negateFor = 0
+				} else {
+					emotionWeight[entry.emotion] += math.Abs(valence)
+				}
+				valenceSum += valence
+				arousalSum += entry.arousal
+				matches++
+			}
+			boost = 1.0
+			if negateFor > 0 {
+				negateFor--
+			}
+		}
+	}
+
+	analysis := &models.MoodAnalysis{Energy: 0.5, Emotions: []string{}, Source: models.MoodSourceLexicon}
+	if matches == 0 {
+		return analysis
+	}
+
+	// Normalise like VADER so a few strong words do not saturate the score
+	analysis.Score = clamp(valenceSum/math.Sqrt(valenceSum*valenceSum+4), -1, 1)
+	analysis.Energy = clamp(arousalSum/float64(matches), 0, 1)
+	analysis.Emotions = topKeys(emotionWeight, 3)
+	return analysis
+}
+
+// BuildMoodTrends aggregates analysed entries into daily and ISO-weekly
+// points in the given location. Entries without analysis are skipped.
+func BuildMoodTrends(entries []models.DiaryEntry, loc *time.Location) ([]models.MoodTrendPoint, []models.MoodTrendPoint) {
+	type bucket struct {
+		start    time.Time
+		count    int
+		score    float64
+		energy   float64
+		emotions map[string]float64
+	}
+	daily := make(map[string]*bucket)
+	weekly := make(map[string]*bucket)
+
+	add := func(buckets map[string]*bucket, key string, start time.Time, mood *models.MoodAnalysis) {
+		b, ok := buckets[key]
+		if !ok {
+			b = &bucket{start: start, emotions: make(map[string]float64)}
+			buckets[key] = b
+		}
+		b.count++
+		b.score += mood.Score
+		b.energy += mood.Energy
+		for rank, emotion := range mood.Emotions {
+			b.emotions[emotion] += 1 / float64(rank+1)
+		}
+	}
+
+	for _, entry := range entries {
+		if entry.Mood == nil {
+			continue
+		}
+		local := entry.CreatedAt.In(loc)
+		day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+		add(daily, day.Format("2006-01-02"), day, entry.Mood)
+
+		weekday := (int(day.Weekday()) + 6) % 7 // Monday = 0
+		weekStart := day.AddDate(0, 0, -weekday)
+		year, week := day.ISOWeek()
+		add(weekly, fmt.Sprintf("%d-W%02d", year, week), weekStart, entry.Mood)
+	}
+
+	toPoints := func(buckets map[string]*bucket) []models.MoodTrendPoint {
+		points := make([]models.MoodTrendPoint, 0, len(buckets))
+		for key, b := range buckets {
+			points = append(points, models.MoodTrendPoint{
+				Period:        key,
+				Start:         b.start,
+				EntryCount:    b.count,
+				AverageScore:  math.Round(b.score/float64(b.count)*1000) / 1000,
+				AverageEnergy: math.Round(b.energy/float64(b.count)*1000) / 1000,
+				TopEmotions:   topKeys(b.emotions, 3),
+			})
+		}
+		sort.Slice(points, func(i, j int) bool { return points[i].Start.Before(points[j].Start) })
+		return points
+	}
+	return toPoints(daily), toPoints(weekly)
+}
+
+// topKeys returns up to n keys with the highest weights, ties by name
+func topKeys(weights map[string]float64, n int) []string {
+	keys := make([]string, 0, len(weights))
+	for key := range weights {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if weights[keys[i]] != weights[keys[j]] {
+			return weights[keys[i]] > weights[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+func clamp(value, low, high float64) float64 {
+	return math.Max(low, math.Min(high, value))
+}
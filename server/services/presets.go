@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"personal-diary/models"
+	"strconv"
+	"strings"
+)
+
+// Limits of user-defined refinement presets
+const (
+	DefaultPresetTemperature = 0.3
+	MaxPresetNameLength      = 60
+	MaxPresetInstructions    = 1000
+	MaxPresetLength          = 5000
+)
+
+// SystemRefinePresets returns the built-in writing contexts as read-only
+// presets. Refining with one is the same as refining with its context.
+func SystemRefinePresets() []models.RefinePreset {
+	return []models.RefinePreset{
+		{ID: "system-diary", Name: "Diary entry", Context: "diary",
+			Instructions: "Keep the personal and reflective nature of a diary entry."},
+		{ID: "system-email", Name: "Email", Context: "email",
+			Instructions: "Make it clear and professional, as an email."},
+		{ID: "system-academic", Name: "Academic", Context: "academic",
+			Instructions: "Use formal language and ensure precision, as academic writing."},
+		{ID: "system-creative", Name: "Creative", Context: "creative",
+			Instructions: "Enhance the literary quality and flow, as creative writing."},
+	}
+}
+
+// ValidatePreset returns an error message when a preset request is unusable
+func ValidatePreset(req models.RefinePresetRequest) string {
+	name := strings.TrimSpace(req.Name)
+	switch {
+	case name == "":
+		return "Name is required"
+	case len([]rune(name)) > MaxPresetNameLength:
+		return fmt.Sprintf("Name is too long. Maximum %d characters allowed.", MaxPresetNameLength)
+	case strings.TrimSpace(req.Instructions) == "":
+		return "Instructions are required"
+	case len([]rune(req.Instructions)) > MaxPresetInstructions:
+		return fmt.Sprintf("Instructions are too long. Maximum %d characters allowed.", MaxPresetInstructions)
+	case req.Temperature != nil && (*req.Temperature < 0 || *req.Temperature > 2):
+		return "Temperature must be between 0 and 2"
+	case req.MaxLength < 0 || req.MaxLength > MaxPresetLength:
+		return fmt.Sprintf("Max length must be between 0 and %d", MaxPresetLength)
+	}
+	return ""
+}
+
+// PresetRefiner refines text with a preset. System presets use the built-in
+// prompt of their context; a tone given per request overrides the preset's.
+type PresetRefiner struct {
+	Provider LLMProvider
+	Preset   models.RefinePreset
+}
+
+func (r PresetRefiner) Refine(ctx context.Context, text, _, tone string) (string, error) {
+	tone = r.tone(tone)
+	if r.Preset.Context != "" {
+		return r.Provider.Refine(ctx, text, r.Preset.Context, tone)
+	}
+	req, err := presetCompletionRequest(ctx, r.Provider.Model(), r.Preset, text, tone)
+	if err != nil {
+		return "", err
+	}
+	return r.Provider.Complete(ctx, req)
+}
+
+func (r PresetRefiner) RefineStream(ctx context.Context, text, _, tone string, onDelta func(string) error) (string, error) {
+	tone = r.tone(tone)
+	if r.Preset.Context != "" {
+		return r.Provider.RefineStream(ctx, text, r.Preset.Context, tone, onDelta)
+	}
+	req, err := presetCompletionRequest(ctx, r.Provider.Model(), r.Preset, text, tone)
+	if err != nil {
+		return "", err
+	}
+	return r.Provider.CompleteStream(ctx, req, onDelta)
+}
+
+func (r PresetRefiner) tone(tone string) string {
+	if tone == "" {
+		return r.Preset.Tone
+	}
+	return tone
+}
+
+// PromptName is the registry prompt the preset refines with
+func (r PresetRefiner) PromptName() string {
+	if r.Preset.Context != "" {
+		return PromptRefine
+	}
+	return PromptRefinePreset
+}
+
+// CacheContext stands in for the writing context in RefineCacheKey, so
+// editing a preset does not serve results made with its old settings
+func (r PresetRefiner) CacheContext() string {
+	if r.Preset.Context != "" {
+		return r.Preset.Context
+	}
+	h := sha256.New()
+	for _, field := range []string{r.Preset.Instructions, r.Preset.Tone,
+		strconv.FormatFloat(r.Preset.Temperature, 'f', -1, 64), strconv.Itoa(r.Preset.MaxLength)} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return "preset:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// presetCompletionRequest puts the preset's instructions in the system
+// message and sends the text alone as the user message
+func presetCompletionRequest(ctx context.Context, model string, preset models.RefinePreset, text, tone string) (CompletionRequest, error) {
+	prompt, _, err := renderPrompt(ctx, PromptRefinePreset, PresetPromptData{
+		Instructions: strings.TrimSpace(preset.Instructions),
+		Tone:         strings.ToLower(tone),
+		MaxLength:    preset.MaxLength,
+	})
+	if err != nil {
+		return CompletionRequest{}, err
+	}
+
+	// Room for the full text by default, less when the preset caps its length
+	maxTokens := refineMaxTokens(model, text)
+	if preset.MaxLength > 0 {
+		maxTokens = min(maxTokens, max(64, preset.MaxLength/2))
+	}
+	return CompletionRequest{
+		Messages: []models.Message{
+			{Role: "system", Content: prompt},
+			{Role: "user", Content: text},
+		},
+		Temperature: preset.Temperature,
+		MaxTokens:   maxTokens,
+	}, nil
+}
@@ -0,0 +1,53 @@
+package services
+
+import "strings"
+
+// modelPrice is the list price in USD per million tokens, or per image
+type modelPrice struct {
+	prompt     float64
+	completion float64
+	image      float64
+}
+
+// modelPrices are approximate list prices used to estimate the cost of each
+// call. Models are matched by the longest prefix, so dated versions such as
+// "gpt-4o-mini-2024-07-18" use their family's price; unknown and local
+// models cost nothing.
+var modelPrices = map[string]modelPrice{
+	"gpt-4o-mini":            {prompt: 0.15, completion: 0.60},
+	"gpt-4o":                 {prompt: 2.50, completion: 10.00},
+	"gpt-4.1-mini":           {prompt: 0.40, completion: 1.60},
+	"gpt-4.1":                {prompt: 2.00, completion: 8.00},
+	"gpt-3.5-turbo":          {prompt: 0.50, completion: 1.50},
+	"gemini-1.5-flash":       {prompt: 0.075, completion: 0.30},
+	"gemini-1.5-pro":         {prompt: 1.25, completion: 5.00},
+	"gemini-2.0-flash":       {prompt: 0.10, completion: 0.40},
+	"text-embedding-3-small": {prompt: 0.02},
+	"text-embedding-3-large": {prompt: 0.13},
+	"dall-e-3":               {image: 0.04},
+	"gpt-image-1":            {prompt: 5.00, completion: 40.00},
+	"gemini-2.5-flash-image": {prompt: 0.30, image: 0.039},
+	"imagen-3":               {image: 0.03},
+}
+
+// EstimateCost returns the estimated USD cost of a call
+func EstimateCost(model string, promptTokens, completionTokens, images int) float64 {
+	price, ok := lookupPrice(model)
+	if !ok {
+		return 0
+	}
+	return (float64(promptTokens)*price.prompt+float64(completionTokens)*price.completion)/1_000_000 +
+		float64(images)*price.image
+}
+
+func lookupPrice(model string) (modelPrice, bool) {
+	model = strings.ToLower(model)
+	best := ""
+	for name := range modelPrices {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	price, ok := modelPrices[best]
+	return price, ok
+}
@@ -0,0 +1,334 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path"
+	"personal-diary/models"
+	"personal-diary/utils"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// Prompt names
+const (
+	PromptRefine       = "refine"
+	PromptRefinePreset = "refine-preset"
+	PromptImage        = "image-prompt"
+	PromptTranslate    = "translate"
+	PromptCheck        = "check"
+	PromptCompanion    = "companion"
+	PromptMood         = "mood"
+	PromptTags         = "tags"
+	PromptDigest       = "digest"
+	PromptAsk          = "ask"
+	PromptSummary      = "summary"
+)
+
+// RefinePromptData fills the refine prompt
+type RefinePromptData struct {
+	Text    string
+	Context string // lowercased writing context
+	Tone    string // lowercased tone
+}
+
+// PresetPromptData fills the system prompt of a user-defined refine preset
+type PresetPromptData struct {
+	Instructions string
+	Tone         string
+	MaxLength    int
+}
+
+// ImagePromptData fills the prompt that describes a background image
+type ImagePromptData struct {
+	Title   string
+	Content string
+}
+
+// TranslatePromptData fills the translation instructions; languages are
+// English names and SourceLanguage is empty when it should be detected
+type TranslatePromptData struct {
+	SourceLanguage string
+	TargetLanguage string
+}
+
+// CheckPromptData fills the proofreading instructions, which need no data
+type CheckPromptData struct{}
+
+// CompanionPromptData fills the journaling companion's system prompt with the
+// entry being discussed; Date is formatted for reading
+type CompanionPromptData struct {
+	Title   string
+	Date    string
+	Content string
+}
+
+// MoodPromptData fills the mood analysis instructions, which need no data
+type MoodPromptData struct{}
+
+// TagsPromptData fills the tag suggestion instructions. Preferred and Avoid
+// are comma-separated tags and may be empty.
+type TagsPromptData struct {
+	Limit     int
+	Preferred string
+	Avoid     string
+}
+
+// DigestPromptData fills the weekly digest instructions, which need no data
+type DigestPromptData struct{}
+
+// AskPromptData fills the instructions for answering questions about the
+// diary, which need no data
+type AskPromptData struct{}
+
+// Summary scopes
+const (
+	SummaryScopeEntry  = "entry"  // one entry
+	SummaryScopePart   = "part"   // a chunk of entries in a map step
+	SummaryScopePeriod = "period" // entries or partial summaries of a period
+)
+
+// SummaryPromptData fills the summary instructions for one of the scopes
+type SummaryPromptData struct {
+	Scope string
+}
+
+// promptSamples lists the known prompts with data every version of them must
+// render, so a template using a field that does not exist fails validation
+var promptSamples = map[string]any{
+	PromptRefine:       RefinePromptData{Text: "sample text", Context: "diary", Tone: "casual"},
+	PromptRefinePreset: PresetPromptData{Instructions: "sample instructions", Tone: "casual", MaxLength: 100},
+	PromptImage:        ImagePromptData{Title: "sample title", Content: "sample content"},
+	PromptTranslate:    TranslatePromptData{SourceLanguage: "French", TargetLanguage: "English"},
+	PromptCheck:        CheckPromptData{},
+	PromptCompanion:    CompanionPromptData{Title: "sample title", Date: "Monday, January 2, 2006", Content: "sample content"},
+	PromptMood:         MoodPromptData{},
+	PromptTags:         TagsPromptData{Limit: 5, Preferred: "work, family", Avoid: "misc"},
+	PromptDigest:       DigestPromptData{},
+	PromptAsk:          AskPromptData{},
+	PromptSummary:      SummaryPromptData{Scope: SummaryScopeEntry},
+}
+
+//go:embed prompts/*.tmpl
+var embeddedPrompts embed.FS
+
+// promptFileName matches "<name>.v<version>.tmpl"
+var promptFileName = regexp.MustCompile(`^([a-z0-9-]+)\.v([0-9]+)\.tmpl$`)
+
+// Prompt is one version of a named prompt template
+type Prompt struct {
+	Name    string
+	Version int
+	tmpl    *template.Template
+}
+
+// Label identifies the prompt version in AI results, e.g. "refine@2"
+func (p Prompt) Label() string {
+	return p.Name + "@" + strconv.Itoa(p.Version)
+}
+
+func (p Prompt) Render(data any) (string, error) {
+	var buf bytes.Buffer
+	if err := p.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("render prompt %s: %w", p.Label(), err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// PromptRegistry holds the versions of every prompt: those shipped with the
+// server (embedded, plus PROMPT_DIR) and those stored in MongoDB, which can
+// be replaced at runtime to try a new version without redeploying.
+type PromptRegistry struct {
+	mu      sync.RWMutex
+	shipped map[string]map[int]Prompt
+	stored  map[string]map[int]Prompt
+	weights map[string]map[int]int
+}
+
+// Prompts is the registry the providers render their prompts from
+var Prompts = mustLoadEmbeddedPrompts()
+
+func mustLoadEmbeddedPrompts() *PromptRegistry {
+	registry := &PromptRegistry{shipped: make(map[string]map[int]Prompt)}
+	if err := registry.loadFiles(embeddedPrompts, "prompts"); err != nil {
+		panic(err)
+	}
+	return registry
+}
+
+// LoadDir adds the templates in dir to the shipped versions. A file may not
+// replace an embedded version, since results already record it.
+func (r *PromptRegistry) LoadDir(dir string) error {
+	return r.loadFiles(os.DirFS(dir), ".")
+}
+
+func (r *PromptRegistry) loadFiles(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, entry := range entries {
+		match := promptFileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(match[2])
+		text, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		prompt, err := parsePrompt(match[1], version, string(text))
+		if err != nil {
+			return err
+		}
+		if _, exists := r.shipped[prompt.Name][version]; exists {
+			return fmt.Errorf("prompt %s is defined twice", prompt.Label())
+		}
+		if r.shipped[prompt.Name] == nil {
+			r.shipped[prompt.Name] = make(map[int]Prompt)
+		}
+		r.shipped[prompt.Name][version] = prompt
+	}
+	return nil
+}
+
+// parsePrompt parses a template and checks it renders for its prompt
+func parsePrompt(name string, version int, text string) (Prompt, error) {
+	sample, known := promptSamples[name]
+	if !known {
+		return Prompt{}, fmt.Errorf("unknown prompt %q", name)
+	}
+	if version < 1 {
+		return Prompt{}, fmt.Errorf("prompt %s has invalid version %d", name, version)
+	}
+	prompt := Prompt{Name: name, Version: version}
+	tmpl, err := template.New(prompt.Label()).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return Prompt{}, fmt.Errorf("parse prompt %s: %w", prompt.Label(), err)
+	}
+	prompt.tmpl = tmpl
+	if _, err := prompt.Render(sample); err != nil {
+		return Prompt{}, err
+	}
+	return prompt, nil
+}
+
+// SetStored replaces the stored versions and weights. Invalid templates are
+// skipped and reported together in the returned error; the rest apply.
+func (r *PromptRegistry) SetStored(templates []models.PromptTemplate) error {
+	stored := make(map[string]map[int]Prompt)
+	weights := make(map[string]map[int]int)
+	var errs []error
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range templates {
+		if t.Template != "" {
+			if _, shipped := r.shipped[t.Name][t.Version]; shipped {
+				errs = append(errs, fmt.Errorf("prompt %s@%d is shipped and cannot be redefined", t.Name, t.Version))
+				continue
+			}
+			prompt, err := parsePrompt(t.Name, t.Version, t.Template)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if stored[t.Name] == nil {
+				stored[t.Name] = make(map[int]Prompt)
+			}
+			stored[t.Name][t.Version] = prompt
+		}
+		if t.Weight > 0 {
+			if weights[t.Name] == nil {
+				weights[t.Name] = make(map[int]int)
+			}
+			weights[t.Name][t.Version] = t.Weight
+		}
+	}
+	r.stored, r.weights = stored, weights
+	return errors.Join(errs...)
+}
+
+// Select picks the version of a prompt to use for subject, usually the
+// user's email. Weighted versions are assigned by a hash of the subject, so
+// each user keeps seeing the same version while an experiment runs.
+func (r *PromptRegistry) Select(name, subject string) (Prompt, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	lookup := func(version int) (Prompt, bool) {
+		if prompt, ok := r.shipped[name][version]; ok {
+			return prompt, true
+		}
+		prompt, ok := r.stored[name][version]
+		return prompt, ok
+	}
+
+	var candidates []int
+	total := 0
+	for version, weight := range r.weights[name] {
+		if _, ok := lookup(version); ok {
+			candidates = append(candidates, version)
+			total += weight
+		}
+	}
+	if total > 0 {
+		sort.Ints(candidates)
+		h := fnv.New32a()
+		h.Write([]byte(name + "\x00" + subject))
+		point := int(h.Sum32() % uint32(total))
+		for _, version := range candidates {
+			point -= r.weights[name][version]
+			if point < 0 {
+				prompt, _ := lookup(version)
+				return prompt, nil
+			}
+		}
+	}
+
+	latest := 0
+	for version := range r.shipped[name] {
+		latest = max(latest, version)
+	}
+	if latest == 0 {
+		return Prompt{}, fmt.Errorf("prompt %q not found", name)
+	}
+	return r.shipped[name][latest], nil
+}
+
+// PromptVersion returns the label of the prompt version the user in ctx gets
+func PromptVersion(ctx context.Context, name string) string {
+	prompt, err := Prompts.Select(name, utils.UserEmailFromContext(ctx))
+	if err != nil {
+		return ""
+	}
+	return prompt.Label()
+}
+
+// renderPrompt renders the prompt version selected for the user in ctx and
+// reports it to the UsageMeter tracking the call
+func renderPrompt(ctx context.Context, name string, data any) (string, string, error) {
+	prompt, err := Prompts.Select(name, utils.UserEmailFromContext(ctx))
+	if err != nil {
+		return "", "", err
+	}
+	text, err := prompt.Render(data)
+	if err != nil {
+		return "", "", err
+	}
+	reportPromptVersion(ctx, prompt.Label())
+	return text, prompt.Label(), nil
+}
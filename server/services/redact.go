@@ -0,0 +1,296 @@
+package services
+
+import (
+	"fmt"
+	"hash/fnv"
+	"personal-diary/models"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Personal information detectors. Addresses need a house number and a
+// capitalised street name ending in a street type.
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	cardPattern  = regexp.MustCompile(`\b\d(?:[ \-]?\d){12,18}\b`)
+	phonePattern = regexp.MustCompile(`\+?\(?\d[\d .\-()]{5,}\d\b`)
+	// Dates and times of day are not phone numbers
+	notPhonePattern = regexp.MustCompile(`^(?:\d{4}[-/.]\d{1,2}[-/.]\d{1,2}|\d{1,2}[-/.]\d{1,2}[-/.]\d{2,4}|\d{1,2}\.\d{2}\s*-\s*\d{1,2}\.\d{2})$`)
+	addressPattern  = regexp.MustCompile(`\b\d{1,5}[A-Za-z]?\s+(?:[A-Z][a-z]+\.?\s+){1,4}` +
+		`(?:Street|St|Avenue|Ave|Road|Rd|Lane|Ln|Boulevard|Blvd|Drive|Dr|Court|Ct|Way|Place|Pl|Terrace|Close|Square|Sq|Crescent|Strasse|Straße)\b`)
+	placeholderPattern = regexp.MustCompile(`\[(EMAIL|PHONE|ADDRESS|CARD|NAME)_(\d+)\]`)
+)
+
+// maxPlaceholderLen is the longest placeholder, e.g. "[ADDRESS_999]"
+const maxPlaceholderLen = 13
+
+// Redactor replaces personal information in the text of one AI call with
+// placeholders such as "[NAME_1]" and puts the values back into the reply.
+// The same value always gets the same placeholder, also across the messages
+// of a call. A nil Redactor leaves text unchanged.
+type Redactor struct {
+	names    *regexp.Regexp
+	byValue  map[string]string // kind and value to placeholder
+	byHolder map[string]string // placeholder to value
+	reserved map[string]bool   // placeholder-like text the user wrote
+	counts   map[string]int
+	items    []models.RedactedItem
+	stable   bool
+}
+
+// NewRedactor returns a redactor that also hides the given names
+func NewRedactor(names []string) *Redactor {
+	return &Redactor{
+		names:    namesPattern(names),
+		byValue:  make(map[string]string),
+		byHolder: make(map[string]string),
+		reserved: make(map[string]bool),
+		counts:   make(map[string]int),
+	}
+}
+
+// NewStableRedactor returns a redactor whose placeholders depend only on the
+// value, so separate calls agree on them, as embeddings that are compared
+// with each other need. Two values may share a placeholder, so its output
+// must not be restored.
+func NewStableRedactor(names []string) *Redactor {
+	r := NewRedactor(names)
+	r.stable = true
+	return r
+}
+
+// namesPattern matches any of the names as whole words, ignoring case, or
+// is nil for no names. Longer names are tried first so "Anna Lee" wins
+// over "Anna".
+func namesPattern(names []string) *regexp.Regexp {
+	var quoted []string
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			quoted = append(quoted, regexp.QuoteMeta(name))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	sort.Slice(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+	return regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+}
+
+// Redact replaces the personal information in text
+func (r *Redactor) Redact(text string) string {
+	if r == nil {
+		return text
+	}
+	for _, holder := range placeholderPattern.FindAllString(text, -1) {
+		if _, ours := r.byHolder[holder]; !ours {
+			r.reserved[holder] = true
+		}
+	}
+	text = r.replace(text, emailPattern, models.RedactionEmail, nil)
+	text = r.replace(text, cardPattern, models.RedactionCard, isCardNumber)
+	text = r.replace(text, addressPattern, models.RedactionAddress, nil)
+	text = r.replace(text, phonePattern, models.RedactionPhone, isPhoneNumber)
+	if r.names != nil {
+		text = r.replace(text, r.names, models.RedactionName, nil)
+	}
+	return text
+}
+
+// replace substitutes matches of pattern accepted by valid, leaving earlier
+// placeholders alone
+func (r *Redactor) replace(text string, pattern *regexp.Regexp, kind string, valid func(string) bool) string {
+	var sb strings.Builder
+	last := 0
+	for _, loc := range placeholderPattern.FindAllStringIndex(text, -1) {
+		sb.WriteString(r.replaceSegment(text[last:loc[0]], pattern, kind, valid))
+		sb.WriteString(text[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	sb.WriteString(r.replaceSegment(text[last:], pattern, kind, valid))
+	return sb.String()
+}
+
+func (r *Redactor) replaceSegment(segment string, pattern *regexp.Regexp, kind string, valid func(string) bool) string {
+	var sb strings.Builder
+	last := 0
+	for _, loc := range pattern.FindAllStringIndex(segment, -1) {
+		value := segment[loc[0]:loc[1]]
+		if valid != nil && !valid(value) {
+			continue
+		}
+		sb.WriteString(segment[last:loc[0]])
+		sb.WriteString(r.placeholder(kind, value))
+		last = loc[1]
+	}
+	sb.WriteString(segment[last:])
+	return sb.String()
+}
+
+// placeholder returns the placeholder of value, adding one if it is new
+func (r *Redactor) placeholder(kind, value string) string {
+	key := kind + ":" + value
+	if holder, ok := r.byValue[key]; ok {
+		return holder
+	}
+	var holder string
+	for attempt := 0; holder == "" || r.reserved[holder]; attempt++ {
+		var n int
+		if r.stable {
+			n = stablePlaceholderNumber(value, attempt)
+		} else {
+			r.counts[kind]++
+			n = r.counts[kind]
+		}
+		holder = fmt.Sprintf("[%s_%d]", strings.ToUpper(kind), n)
+	}
+	r.byValue[key] = holder
+	r.byHolder[holder] = value
+	r.items = append(r.items, models.RedactedItem{Placeholder: holder, Kind: kind, Value: value})
+	return holder
+}
+
+// stablePlaceholderNumber derives a placeholder number from value, ignoring
+// case as names are matched that way. attempt picks another number when the
+// first is taken by text the user wrote.
+func stablePlaceholderNumber(value string, attempt int) int {
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(value)))
+	h.Write([]byte{byte(attempt)})
+	return int(h.Sum32()%999) + 1
+}
+
+// Restore puts the redacted values back in place of their placeholders;
+// placeholders the redactor did not make, including any the user wrote, are
+// left as they are
+func (r *Redactor) Restore(text string) string {
+	if r == nil || len(r.byHolder) == 0 {
+		return text
+	}
+	return placeholderPattern.ReplaceAllStringFunc(text, func(holder string) string {
+		if value, ok := r.byHolder[holder]; ok {
+			return value
+		}
+		return holder
+	})
+}
+
+// Items lists what was redacted so far, in order of first appearance
+func (r *Redactor) Items() []models.RedactedItem {
+	if r == nil {
+		return nil
+	}
+	return r.items
+}
+
+// RedactionHint masks a redacted value so a log of redactions can tell the
+// user what was hidden without keeping it: cards and phone numbers keep
+// their last digits, emails the first letter and the domain, anything else
+// only its first letter.
+func RedactionHint(kind, value string) string {
+	switch kind {
+	case models.RedactionCard, models.RedactionPhone:
+		digits := strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, value)
+		keep := 4
+		if kind == models.RedactionPhone {
+			keep = 2
+		}
+		if len(digits) <= keep*2 {
+			return "••••"
+		}
+		return "•••• " + digits[len(digits)-keep:]
+	case models.RedactionEmail:
+		if at := strings.LastIndex(value, "@"); at > 0 {
+			return firstRune(value) + "•••" + value[at:]
+		}
+	}
+	return firstRune(value) + "•••"
+}
+
+func firstRune(s string) string {
+	for _, r := range s {
+		return string(r)
+	}
+	return ""
+}
+
+// RestoreStream wraps onDelta so streamed output has its placeholders
+// restored. A placeholder split between deltas is held back until it is
+// complete; flush sends whatever is still held when the stream ends.
+func (r *Redactor) RestoreStream(onDelta func(string) error) (wrapped func(string) error, flush func() error) {
+	if r == nil || len(r.byHolder) == 0 {
+		return onDelta, func() error { return nil }
+	}
+	var pending string
+	wrapped = func(delta string) error {
+		text := pending + delta
+		pending = ""
+		if open := strings.LastIndexByte(text, '['); open >= 0 && !strings.ContainsRune(text[open:], ']') &&
+			len(text)-open < maxPlaceholderLen && isPlaceholderPrefix(text[open+1:]) {
+			text, pending = text[:open], text[open:]
+		}
+		if text == "" {
+			return nil
+		}
+		return onDelta(r.Restore(text))
+	}
+	flush = func() error {
+		if pending == "" {
+			return nil
+		}
+		text := pending
+		pending = ""
+		return onDelta(r.Restore(text))
+	}
+	return wrapped, flush
+}
+
+// isPlaceholderPrefix reports whether s could continue into a placeholder
+func isPlaceholderPrefix(s string) bool {
+	for _, c := range s {
+		if !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+func digitsOf(s string) string {
+	return strings.Map(func(c rune) rune {
+		if c >= '0' && c <= '9' {
+			return c
+		}
+		return -1
+	}, s)
+}
+
+// isCardNumber accepts 13 to 19 digits that pass the Luhn check
+func isCardNumber(value string) bool {
+	digits := digitsOf(value)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if (len(digits)-i)%2 == 0 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// isPhoneNumber accepts 7 to 15 digits that do not form a date or time
+func isPhoneNumber(value string) bool {
+	digits := digitsOf(value)
+	return len(digits) >= 7 && len(digits) <= 15 && !notPhonePattern.MatchString(value)
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"personal-diary/models"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRedactorKeepsPlaceholdersAcrossMessages(t *testing.T) {
+	// The messages of one call share a redactor, so a value gets the same
+	// placeholder in each and a reply may refer to either
+	r := NewRedactor([]string{"Tom"})
+	first := r.Redact("Tom wrote from tom@example.com.")
+	second := r.Redact("Reply to TOM at tom@example.com, not ann@example.com.")
+
+	if want := "[NAME_1] wrote from [EMAIL_1]."; first != want {
+		t.Errorf("first message = %q, want %q", first, want)
+	}
+	if want := "Reply to [NAME_2] at [EMAIL_1], not [EMAIL_2]."; second != want {
+		t.Errorf("second message = %q, want %q", second, want)
+	}
+	// Names differing in case restore as written
+	if got := r.Restore("[NAME_1] and [NAME_2]"); got != "Tom and TOM" {
+		t.Errorf("Restore = %q", got)
+	}
+}
+
+func TestRedactorSkipsLookalikes(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"dates and times are not phone numbers", "On 2024-03-05 from 10.30 - 11.45, then 05/03/2024.", "On 2024-03-05 from 10.30 - 11.45, then 05/03/2024."},
+		{"placeholders the user wrote are kept", "[NAME_1] is how I wrote Anna before.", "[NAME_1] is how I wrote [NAME_2] before."},
+		{"the longest name wins", "Anna Lee came by, then Anna alone.", "[NAME_1] came by, then [NAME_2] alone."},
+		{"names match whole words only", "Annabel met Anna.", "Annabel met [NAME_1]."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRedactor([]string{"Anna", "Anna Lee"})
+			redacted := r.Redact(tt.text)
+			if redacted != tt.want {
+				t.Errorf("Redact = %q, want %q", redacted, tt.want)
+			}
+			if restored := r.Restore(redacted); restored != tt.text {
+				t.Errorf("Restore = %q, want %q", restored, tt.text)
+			}
+		})
+	}
+}
+
+func TestRedactorRestoreStream(t *testing.T) {
+	r := NewRedactor([]string{"Anna"})
+	r.Redact("Anna and bob@example.com")
+
+	tests := []struct {
+		name   string
+		deltas []string
+	}{
+		{"whole placeholders", []string{"Hi [NAME_1], ", "mail [EMAIL_1]."}},
+		{"split placeholder", []string{"Hi [NA", "ME_", "1], mail [EMAIL_1", "]."}},
+		{"split before the bracket", []string{"Hi ", "[", "NAME_1], mail [EMAIL_1]."}},
+		{"bracket that is not a placeholder", []string{"Hi [NAME_1], mail [EMAIL_1]. [", "sic]"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			wrapped, flush := r.RestoreStream(func(delta string) error {
+				out.WriteString(delta)
+				return nil
+			})
+			for _, delta := range tt.deltas {
+				if err := wrapped(delta); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := flush(); err != nil {
+				t.Fatal(err)
+			}
+			want := r.Restore(strings.Join(tt.deltas, ""))
+			if out.String() != want {
+				t.Errorf("streamed %q, want %q", out.String(), want)
+			}
+		})
+	}
+}
+
+func TestStableRedactor(t *testing.T) {
+	names := []string{"Anna", "Tom"}
+	first := NewStableRedactor(names).Redact("Anna met Tom.")
+	second := NewStableRedactor(names).Redact("Tom called. Later TOM met anna.")
+
+	holders := placeholderPattern.FindAllString(first, -1)
+	if len(holders) != 2 || holders[0] == holders[1] {
+		t.Fatalf("Redact = %q, want two different placeholders", first)
+	}
+	anna, tom := holders[0], holders[1]
+	if want := tom + " called. Later " + tom + " met " + anna + "."; second != want {
+		t.Errorf("second call = %q, want %q", second, want)
+	}
+
+	// A placeholder the user wrote is never reused
+	r := NewStableRedactor(names)
+	if got := r.Redact(anna + " is not Anna."); strings.Count(got, anna) != 1 {
+		t.Errorf("Redact = %q, reuses the user's %s", got, anna)
+	}
+}
+
+func TestRedactionHint(t *testing.T) {
+	tests := []struct {
+		kind  string
+		value string
+		want  string
+	}{
+		{models.RedactionCard, "4111 1111 1111 4242", "•••• 4242"},
+		{models.RedactionPhone, "+44 20 7946 0958", "•••• 58"},
+		{models.RedactionPhone, "555-0123", "•••• 23"},
+		{models.RedactionEmail, "jane.doe@example.com", "j•••@example.com"},
+		{models.RedactionAddress, "221B Baker Street", "2•••"},
+		{models.RedactionName, "Émile", "É•••"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kind+" "+tt.value, func(t *testing.T) {
+			if got := RedactionHint(tt.kind, tt.value); got != tt.want {
+				t.Errorf("RedactionHint = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// recordingStore keeps the records it is given
+type recordingStore struct {
+	records []models.RedactionRecord
+}
+
+func (s *recordingStore) Settings(ctx context.Context, email string) (models.RedactionSettings, error) {
+	return models.RedactionSettings{Enabled: true}, nil
+}
+
+func (s *recordingStore) Record(ctx context.Context, record models.RedactionRecord) error {
+	s.records = append(s.records, record)
+	return nil
+}
+
+func TestRecordRedactionsStoresNoValues(t *testing.T) {
+	text := "Anna paid with 4111 1111 1111 4242, mail anna@example.com or call +44 20 7946 0958."
+	r := NewRedactor([]string{"Anna"})
+	r.Redact(text)
+
+	store := &recordingStore{}
+	RecordRedactions(context.Background(), store, r, "fake", "fake")
+	if len(store.records) != 1 {
+		t.Fatalf("got %d records, want 1", len(store.records))
+	}
+	encoded, err := bson.Marshal(store.records[0])
+	if err != nil {
+		t.Fatalf("marshal record: %v", err)
+	}
+	for _, item := range r.Items() {
+		if bytes.Contains(encoded, []byte(item.Value)) {
+			t.Errorf("stored record contains %s %q", item.Kind, item.Value)
+		}
+	}
+	if got := len(store.records[0].Items); got != len(r.Items()) {
+		t.Errorf("got %d logged items, want %d", got, len(r.Items()))
+	}
+}
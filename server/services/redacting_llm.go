@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"log"
+	"personal-diary/models"
+	"personal-diary/utils"
+	"time"
+)
+
+// RedactionStore holds users' redaction settings and what was redacted
+type RedactionStore interface {
+	// Settings returns the user's settings, enabled when they have none
+	Settings(ctx context.Context, email string) (models.RedactionSettings, error)
+	Record(ctx context.Context, record models.RedactionRecord) error
+}
+
+type redactionSettingsKey struct{}
+
+// redactionSettings reads the user's settings once per request or job, so
+// that calls made chunk by chunk do not each query the store
+func redactionSettings(ctx context.Context, store RedactionStore) (models.RedactionSettings, error) {
+	value, err := utils.UserValue(ctx, redactionSettingsKey{}, func() (any, error) {
+		return store.Settings(ctx, utils.UserEmailFromContext(ctx))
+	})
+	if err != nil {
+		return models.RedactionSettings{}, err
+	}
+	return value.(models.RedactionSettings), nil
+}
+
+// RedactorFor returns a redactor for the user in ctx, or nil when they
+// turned redaction off. Redaction stays on when the settings cannot be read.
+func RedactorFor(ctx context.Context, store RedactionStore) *Redactor {
+	settings, err := redactionSettings(ctx, store)
+	if err != nil {
+		log.Printf("Failed to read redaction settings, redacting with defaults: %v", err)
+		return NewRedactor(nil)
+	}
+	if !settings.Enabled {
+		return nil
+	}
+	return NewRedactor(settings.Names)
+}
+
+// stableRedactorFor is RedactorFor with stable placeholders
+func stableRedactorFor(ctx context.Context, store RedactionStore) *Redactor {
+	settings, err := redactionSettings(ctx, store)
+	if err != nil {
+		log.Printf("Failed to read redaction settings, redacting with defaults: %v", err)
+		return NewStableRedactor(nil)
+	}
+	if !settings.Enabled {
+		return nil
+	}
+	return NewStableRedactor(settings.Names)
+}
+
+// RecordRedactions stores what redactor hid from a call to provider, so the
+// user can see it. Values are stored only as their RedactionHint.
+func RecordRedactions(ctx context.Context, store RedactionStore, redactor *Redactor, provider, model string) {
+	items := redactor.Items()
+	if len(items) == 0 {
+		return
+	}
+	record := models.RedactionRecord{
+		Email:     utils.UserEmailFromContext(ctx),
+		Provider:  provider,
+		Model:     model,
+		Items:     make([]models.RedactionLogItem, len(items)),
+		CreatedAt: time.Now(),
+	}
+	for i, item := range items {
+		record.Items[i] = models.RedactionLogItem{Placeholder: item.Placeholder, Kind: item.Kind, Hint: RedactionHint(item.Kind, item.Value)}
+	}
+	if err := store.Record(context.WithoutCancel(ctx), record); err != nil {
+		log.Printf("Failed to record redactions: %v", err)
+	}
+}
+
+// RedactingProvider wraps an LLMProvider so personal information is
+// replaced with placeholders before it is sent and restored in the reply
+type RedactingProvider struct {
+	inner LLMProvider
+	store RedactionStore
+}
+
+func NewRedactingProvider(inner LLMProvider, store RedactionStore) *RedactingProvider {
+	return &RedactingProvider{inner: inner, store: store}
+}
+
+func (p *RedactingProvider) Name() string  { return p.inner.Name() }
+func (p *RedactingProvider) Model() string { return p.inner.Model() }
+
+func (p *RedactingProvider) Complete(ctx context.Context, req CompletionRequest) (string, error) {
+	redactor := RedactorFor(ctx, p.store)
+	reply, err := p.inner.Complete(ctx, redactRequest(redactor, req))
+	RecordRedactions(ctx, p.store, redactor, p.inner.Name(), p.inner.Model())
+	return redactor.Restore(reply), err
+}
+
+func (p *RedactingProvider) CompleteStream(ctx context.Context, req CompletionRequest, onDelta func(string) error) (string, error) {
+	redactor := RedactorFor(ctx, p.store)
+	restoring, flush := redactor.RestoreStream(onDelta)
+	reply, err := p.inner.CompleteStream(ctx, redactRequest(redactor, req), restoring)
+	if err == nil {
+		err = flush()
+	}
+	RecordRedactions(ctx, p.store, redactor, p.inner.Name(), p.inner.Model())
+	return redactor.Restore(reply), err
+}
+
+func (p *RedactingProvider) Refine(ctx context.Context, text, writingContext, tone string) (string, error) {
+	redactor := RedactorFor(ctx, p.store)
+	reply, err := p.inner.Refine(ctx, redactor.Redact(text), redactor.Redact(writingContext), tone)
+	RecordRedactions(ctx, p.store, redactor, p.inner.Name(), p.inner.Model())
+	return redactor.Restore(reply), err
+}
+
+func (p *RedactingProvider) RefineStream(ctx context.Context, text, writingContext, tone string, onDelta func(string) error) (string, error) {
+	redactor := RedactorFor(ctx, p.store)
+	restoring, flush := redactor.RestoreStream(onDelta)
+	reply, err := p.inner.RefineStream(ctx, redactor.Redact(text), redactor.Redact(writingContext), tone, restoring)
+	if err == nil {
+		err = flush()
+	}
+	RecordRedactions(ctx, p.store, redactor, p.inner.Name(), p.inner.Model())
+	return redactor.Restore(reply), err
+}
+
+// redactRequest redacts every message of req with one redactor, so a value
+// has the same placeholder throughout
+func redactRequest(redactor *Redactor, req CompletionRequest) CompletionRequest {
+	if redactor == nil {
+		return req
+	}
+	messages := make([]models.Message, len(req.Messages))
+	for i, msg := range req.Messages {
+		messages[i] = models.Message{Role: msg.Role, Content: redactor.Redact(msg.Content)}
+	}
+	req.Messages = messages
+	return req
+}
+
+// RedactingEmbedder wraps an Embedder so texts are redacted before they are
+// embedded; nothing needs restoring. Placeholders are stable, so a name gets
+// the same one in an entry's chunks and in a question about it.
+type RedactingEmbedder struct {
+	inner Embedder
+	store RedactionStore
+}
+
+func NewRedactingEmbedder(inner Embedder, store RedactionStore) *RedactingEmbedder {
+	return &RedactingEmbedder{inner: inner, store: store}
+}
+
+func (e *RedactingEmbedder) Name() string  { return e.inner.Name() }
+func (e *RedactingEmbedder) Model() string { return e.inner.Model() }
+
+func (e *RedactingEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	redactor := stableRedactorFor(ctx, e.store)
+	if redactor == nil {
+		return e.inner.Embed(ctx, texts)
+	}
+	redacted := make([]string, len(texts))
+	for i, text := range texts {
+		redacted[i] = redactor.Redact(text)
+	}
+	vectors, err := e.inner.Embed(ctx, redacted)
+	RecordRedactions(ctx, e.store, redactor, e.inner.Name(), e.inner.Model())
+	return vectors, err
+}
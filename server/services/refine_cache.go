@@ -0,0 +1,130 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RefineCache stores refined texts by RefineCacheKey so repeated requests
+// for the same paragraph do not call the model again
+type RefineCache interface {
+	// Get returns the cached text; ok is false on a miss or expired entry
+	Get(ctx context.Context, key string) (text string, ok bool, err error)
+	Set(ctx context.Context, key, text string) error
+}
+
+// RefineCacheKey addresses a refinement by the user it was made for and
+// everything that determines its output. Results are never shared between
+// users: a refinement can hold names restored from the user's redaction
+// list. Fields are length-prefixed so different splits cannot collide.
+func RefineCacheKey(email, text, writingContext, tone, model, promptVersion string) string {
+	h := sha256.New()
+	for _, field := range []string{email, text, strings.ToLower(writingContext), strings.ToLower(tone), model, promptVersion} {
+		var size [8]byte
+		binary.BigEndian.PutUint64(size[:], uint64(len(field)))
+		h.Write(size[:])
+		h.Write([]byte(field))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// RefineWithCache returns the user's cached refinement for the request, or
+// refines the text and caches the result. promptVersion is the label of the
+// prompt the refiner will use. cached reports a cache hit. Cache errors are
+// logged and the model is used as if the cache were empty.
+func RefineWithCache(ctx context.Context, refiner TextRefiner, cache RefineCache, email, model, promptVersion, text, writingContext, tone string) (refined string, cached bool, err error) {
+	key := RefineCacheKey(email, text, writingContext, tone, model, promptVersion)
+	if hit, ok, err := cache.Get(ctx, key); err != nil {
+		log.Printf("Refine cache lookup failed: %v", err)
+	} else if ok {
+		return hit, true, nil
+	}
+
+	refined, err = refiner.Refine(ctx, text, writingContext, tone)
+	if err != nil {
+		return "", false, err
+	}
+	if err := cache.Set(ctx, key, refined); err != nil {
+		log.Printf("Refine cache store failed: %v", err)
+	}
+	return refined, false, nil
+}
+
+// MemoryRefineCache is an in-process LRU cache with a TTL. It suits a single
+// server instance; entries are lost on restart.
+type MemoryRefineCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	order      *list.List // front is most recently used
+	entries    map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key       string
+	text      string
+	expiresAt time.Time
+}
+
+func NewMemoryRefineCache(maxEntries int, ttl time.Duration) *MemoryRefineCache {
+	return &MemoryRefineCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func (c *MemoryRefineCache) Get(ctx context.Context, key string) (string, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return "", false, nil
+	}
+	entry := element.Value.(*memoryCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return "", false, nil
+	}
+	c.order.MoveToFront(element)
+	return entry.text, true, nil
+}
+
+func (c *MemoryRefineCache) Set(ctx context.Context, key, text string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := time.Now().Add(c.ttl)
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*memoryCacheEntry)
+		entry.text, entry.expiresAt = text, expiresAt
+		c.order.MoveToFront(element)
+		return nil
+	}
+
+	c.entries[key] = c.order.PushFront(&memoryCacheEntry{key: key, text: text, expiresAt: expiresAt})
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*memoryCacheEntry).key)
+	}
+	return nil
+}
+
+// NoRefineCache disables caching
+type NoRefineCache struct{}
+
+func (NoRefineCache) Get(ctx context.Context, key string) (string, bool, error) {
+	return "", false, nil
+}
+func (NoRefineCache) Set(ctx context.Context, key, text string) error { return nil }
@@ -0,0 +1,43 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// countingRefiner refines by echoing the text and counts its calls
+type countingRefiner struct {
+	calls int
+}
+
+func (r *countingRefiner) Refine(ctx context.Context, text, writingContext, tone string) (string, error) {
+	r.calls++
+	return text, nil
+}
+
+func TestRefineWithCacheIsPerUser(t *testing.T) {
+	cache := NewMemoryRefineCache(10, time.Hour)
+	refiner := &countingRefiner{}
+	refine := func(email string) bool {
+		t.Helper()
+		_, cached, err := RefineWithCache(context.Background(), refiner, cache, email, "fake", "refine@v1", "Met Anna.", "", "")
+		if err != nil {
+			t.Fatalf("RefineWithCache: %v", err)
+		}
+		return cached
+	}
+
+	if refine("anna@example.com") {
+		t.Error("first request was served from the cache")
+	}
+	if !refine("anna@example.com") {
+		t.Error("repeated request of the same user was not served from the cache")
+	}
+	if refine("tom@example.com") {
+		t.Error("another user was served the first user's refinement")
+	}
+	if refiner.calls != 2 {
+		t.Errorf("refiner called %d times, want 2", refiner.calls)
+	}
+}
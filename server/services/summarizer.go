@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"personal-diary/models"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// summaryChunkChars keeps each map step well inside small context windows
+	// (roughly 3k tokens of diary text)
+	summaryChunkChars = 12000
+	// summaryWorkers bounds concurrent map calls to the provider
+	summaryWorkers = 3
+)
+
+// summaryReply is the JSON object the model is asked to return
+type summaryReply struct {
+	Summary   string   `json:"summary"`
+	KeyPoints []string `json:"keyPoints"`
+}
+
+// ContentHash fingerprints entry content for summary cache invalidation
+func ContentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// SummarizeEntry produces a short summary and key points for one entry
+func SummarizeEntry(ctx context.Context, provider LLMProvider, entry models.DiaryEntry) (*models.EntrySummary, error) {
+	text := "Title: " + entry.Title + "\n" + truncateText(entry.Content, summaryChunkChars)
+	reply, version, err := completeSummary(ctx, provider, SummaryScopeEntry, text)
+	if err != nil {
+		return nil, err
+	}
+	return &models.EntrySummary{
+		Summary:       reply.Summary,
+		KeyPoints:     reply.KeyPoints,
+		ContentHash:   ContentHash(entry.Content),
+		Model:         provider.Model(),
+		PromptVersion: version,
+		GeneratedAt:   time.Now(),
+	}, nil
+}
+
+// SummarizePeriod summarises many entries with map-reduce: entries are
+// grouped into chunks that fit the context window, each chunk is summarised
+// (map), and the partial summaries are combined until one remains (reduce).
+func SummarizePeriod(ctx context.Context, provider LLMProvider, entries []models.DiaryEntry) (string, []string, error) {
+	if len(entries) == 0 {
+		return "", nil, fmt.Errorf("no entries to summarise")
+	}
+
+	var sections []string
+	for _, entry := range entries {
+		sections = append(sections, fmt.Sprintf("### %s - %s\n%s",
+			entry.CreatedAt.Format("Mon Jan 2, 2006"), entry.Title, truncateText(entry.Content, summaryChunkChars)))
+	}
+
+	// Map chunks to partial summaries until everything fits into one call
+	chunks := chunkSections(sections, summaryChunkChars)
+	for len(chunks) > 1 {
+		partials, err := mapSummaries(ctx, provider, chunks)
+		if err != nil {
+			return "", nil, err
+		}
+		var notes []string
+		for _, partial := range partials {
+			notes = append(notes, formatPartialSummary(partial))
+		}
+		next := chunkSections(notes, summaryChunkChars)
+		if len(next) >= len(chunks) {
+			return "", nil, fmt.Errorf("partial summaries are too long to combine")
+		}
+		chunks = next
+	}
+
+	// Reduce
+	final, _, err := completeSummary(ctx, provider, SummaryScopePeriod, chunks[0])
+	if err != nil {
+		return "", nil, err
+	}
+	return final.Summary, final.KeyPoints, nil
+}
+
+// mapSummaries summarises each chunk, running up to summaryWorkers at once,
+// and returns the partial summaries in chunk order.
+func mapSummaries(ctx context.Context, provider LLMProvider, chunks []string) ([]summaryReply, error) {
+	partials := make([]summaryReply, len(chunks))
+	errs := make([]error, len(chunks))
+	sem := make(chan struct{}, summaryWorkers)
+	var wg sync.WaitGroup
+
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(i int, chunk string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			reply, _, err := completeSummary(ctx, provider, SummaryScopePart, chunk)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			partials[i] = *reply
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return partials, nil
+}
+
+// completeSummary summarises text for the scope and returns the label of
+// the prompt version used
+func completeSummary(ctx context.Context, provider LLMProvider, scope, text string) (*summaryReply, string, error) {
+	prompt, version, err := renderPrompt(ctx, PromptSummary, SummaryPromptData{Scope: scope})
+	if err != nil {
+		return nil, "", err
+	}
+
+	reply, err := provider.Complete(ctx, CompletionRequest{
+		Messages: []models.Message{
+			{Role: "system", Content: prompt},
+			{Role: "user", Content: text},
+		},
+		Temperature: 0.3,
+		MaxTokens:   600,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	var parsed summaryReply
+	if err := parseJSONReply(reply, &parsed); err != nil {
+		return nil, "", err
+	}
+	parsed.Summary = strings.TrimSpace(parsed.Summary)
+	if parsed.Summary == "" {
+		return nil, "", fmt.Errorf("model returned an empty summary")
+	}
+	return &parsed, version, nil
+}
+
+func formatPartialSummary(partial summaryReply) string {
+	var note strings.Builder
+	note.WriteString(partial.Summary)
+	for _, point := range partial.KeyPoints {
+		note.WriteString("\n- " + point)
+	}
+	return note.String()
+}
+
+// chunkSections packs consecutive sections into chunks of at most maxChars.
+// A single oversized section becomes its own chunk.
+func chunkSections(sections []string, maxChars int) []string {
+	var chunks []string
+	var current strings.Builder
+	for _, section := range sections {
+		if current.Len() > 0 && current.Len()+len(section)+2 > maxChars {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+		if current.Len() > 0 {
+			current.WriteString("\n\n")
+		}
+		current.WriteString(section)
+	}
+	if current.Len() > 0 {
+		chunks = append(chunks, current.String())
+	}
+	return chunks
+}
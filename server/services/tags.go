@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"personal-diary/models"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	maxTagRunes     = 32
+	maxTagTextChars = 4000
+	maxPromptTags   = 50
+)
+
+// TagProfile is what the server has learned about a user's tagging habits
+type TagProfile struct {
+	Vocabulary []string       // tags the user applied, most used first
+	Accepted   map[string]int // suggestion accept counts per tag
+	Rejected   map[string]int // suggestion reject counts per tag
+}
+
+// blocked reports whether the user keeps rejecting the tag
+func (p TagProfile) blocked(tag string) bool {
+	rejected := p.Rejected[tag]
+	return rejected >= 2 && rejected > p.Accepted[tag]
+}
+
+// NormalizeTag lower-cases a tag and reduces it to letters, digits and
+// single dashes ("#Work Trip" becomes "work-trip"). It returns "" for tags
+// with nothing usable in them.
+func NormalizeTag(tag string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(tag)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if dash && b.Len() > 0 {
+				b.WriteRune('-')
+			}
+			dash = false
+			b.WriteRune(r)
+		case r == '-' || r == '_' || unicode.IsSpace(r):
+			dash = true
+		}
+	}
+	normalized := []rune(b.String())
+	if len(normalized) > maxTagRunes {
+		normalized = normalized[:maxTagRunes]
+	}
+	return strings.Trim(string(normalized), "-")
+}
+
+// NormalizeTags normalises every tag and drops empty and duplicate ones
+func NormalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	normalized := []string{}
+	for _, tag := range tags {
+		if tag = NormalizeTag(tag); tag != "" && !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+// SuggestTags proposes up to limit tags for an entry with the chat model,
+// preferring the user's existing vocabulary and avoiding tags they keep
+// rejecting. When no provider is configured, or the model fails, tags are
+// extracted locally instead. Tags already applied to the entry are skipped.
+// It also returns the label of the prompt version used, "" for local tags.
+func SuggestTags(ctx context.Context, provider LLMProvider, entry models.DiaryEntry, profile TagProfile, limit int) ([]string, string) {
+	suggested, version, err := suggestTagsWithLLM(ctx, provider, entry, profile, limit)
+	if err != nil {
+		if !errors.Is(err, ErrLLMNotConfigured) && !errors.Is(err, ErrQuotaExceeded) {
+			log.Printf("LLM tag suggestion failed, using keywords: %v", err)
+		}
+		return SuggestTagsKeywords(entry, profile, limit), ""
+	}
+	return suggested, version
+}
+
+func suggestTagsWithLLM(ctx context.Context, provider LLMProvider, entry models.DiaryEntry, profile TagProfile, limit int) ([]string, string, error) {
+	var preferred, avoid []string
+	for _, tag := range profile.Vocabulary {
+		if !profile.blocked(tag) && len(preferred) < maxPromptTags {
+			preferred = append(preferred, tag)
+		}
+	}
+	for tag := range profile.Rejected {
+		if profile.blocked(tag) && len(avoid) < maxPromptTags {
+			avoid = append(avoid, tag)
+		}
+	}
+	sort.Strings(avoid)
+	prompt, version, err := renderPrompt(ctx, PromptTags, TagsPromptData{
+		Limit:     limit,
+		Preferred: strings.Join(preferred, ", "),
+		Avoid:     strings.Join(avoid, ", "),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	reply, err := provider.Complete(ctx, CompletionRequest{
+		Messages: []models.Message{
+			{Role: "system", Content: prompt},
+			{Role: "user", Content: "Title: " + entry.Title + "\n" + truncateText(entry.Content, maxTagTextChars)},
+		},
+		Temperature: 0.2,
+		MaxTokens:   100,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	var parsed struct {
+		Tags []string `json:"tags"`
+	}
+	if err := parseJSONReply(reply, &parsed); err != nil {
+		return nil, "", err
+	}
+	return filterSuggestions(parsed.Tags, entry, profile, limit), version, nil
+}
+
+// SuggestTagsKeywords suggests tags without a model: first the user's own
+// tags that occur in the entry, then its most frequent keywords.
+func SuggestTagsKeywords(entry models.DiaryEntry, profile TagProfile, limit int) []string {
+	text := " " + strings.Join(tagWords(entry.Title+" "+entry.Content), " ") + " "
+
+	var candidates []string
+	for _, tag := range profile.Vocabulary {
+		if strings.Contains(text, " "+strings.ReplaceAll(tag, "-", " ")+" ") {
+			candidates = append(candidates, tag)
+		}
+	}
+
+	// Title words count double, they usually name the topic
+	counts := make(map[string]int)
+	for _, word := range tagWords(entry.Title) {
+		counts[word] += 2
+	}
+	for _, word := range tagWords(entry.Content) {
+		counts[word]++
+	}
+	keywords := make([]string, 0, len(counts))
+	for word := range counts {
+		if len([]rune(word)) >= 4 && !tagStopwords[word] {
+			keywords = append(keywords, word)
+		}
+	}
+	sort.Slice(keywords, func(i, j int) bool {
+		if counts[keywords[i]] != counts[keywords[j]] {
+			return counts[keywords[i]] > counts[keywords[j]]
+		}
+		return keywords[i] < keywords[j]
+	})
+
+	return filterSuggestions(append(candidates, keywords...), entry, profile, limit)
+}
+
+// filterSuggestions normalises suggestions and drops duplicates, tags already
+// on the entry and tags the user keeps rejecting
+func filterSuggestions(tags []string, entry models.DiaryEntry, profile TagProfile, limit int) []string {
+	applied := make(map[string]bool)
+	for _, tag := range entry.Tags {
+		applied[tag] = true
+	}
+
+	suggested := []string{}
+	for _, tag := range NormalizeTags(tags) {
+		if len(suggested) == limit {
+			break
+		}
+		if !applied[tag] && !profile.blocked(tag) {
+			suggested = append(suggested, tag)
+		}
+	}
+	return suggested
+}
+
+func tagWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+var tagStopwords = map[string]bool{
+	"about": true, "after": true, "again": true, "also": true, "always": true, "another": true, "back": true,
+	"because": true, "been": true, "before": true, "being": true, "bit": true, "came": true, "come": true,
+	"could": true, "day": true, "didn": true, "does": true, "doing": true, "done": true, "down": true,
+	"each": true, "even": true, "ever": true, "every": true, "feel": true, "feeling": true, "felt": true,
+	"from": true, "going": true, "gone": true, "good": true, "have": true, "having": true, "here": true,
+	"into": true, "just": true, "know": true, "last": true, "like": true, "little": true, "long": true,
+	"looked": true, "made": true, "make": true, "many": true, "maybe": true, "more": true, "most": true,
+	"much": true, "myself": true, "need": true, "never": true, "next": true, "night": true, "only": true,
+	"other": true, "over": true, "quite": true, "really": true, "said": true, "same": true, "should": true,
+	"since": true, "some": true, "something": true, "still": true, "such": true, "sure": true, "take": true,
+	"than": true, "that": true, "their": true, "them": true, "then": true, "there": true, "these": true,
+	"they": true, "thing": true, "things": true, "think": true, "this": true, "those": true, "though": true,
+	"thought": true, "through": true, "time": true, "today": true, "tomorrow": true, "tonight": true,
+	"very": true, "want": true, "wanted": true, "week": true, "well": true, "went": true, "were": true,
+	"what": true, "when": true, "where": true, "which": true, "while": true, "will": true, "with": true,
+	"would": true, "yesterday": true, "your": true,
+}
@@ -0,0 +1,335 @@
+package services
+
+import (
+	"errors"
+	"personal-diary/models"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ErrEditMismatch means an edit does not fit the text it is applied to
+var ErrEditMismatch = errors.New("edit does not match the text")
+
+// editToken is a word, a run of whitespace or a single punctuation mark, with
+// its character offsets in the text
+type editToken struct {
+	text       string
+	start, end int
+}
+
+func tokenizeForEdits(text string) []editToken {
+	runes := []rune(text)
+	var tokens []editToken
+	for i := 0; i < len(runes); {
+		j := i + 1
+		switch {
+		case isEditWordRune(runes[i]):
+			for j < len(runes) && (isEditWordRune(runes[j]) ||
+				// Keep contractions such as "don't" together
+				(runes[j] == '\'' || runes[j] == '’') && j+1 < len(runes) && isEditWordRune(runes[j+1])) {
+				j++
+			}
+		case unicode.IsSpace(runes[i]):
+			for j < len(runes) && unicode.IsSpace(runes[j]) {
+				j++
+			}
+		}
+		tokens = append(tokens, editToken{text: string(runes[i:j]), start: i, end: j})
+		i = j
+	}
+	return tokens
+}
+
+func isEditWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// maxEditDiffCells bounds the word diff table; longer texts are diffed line
+// by line
+const maxEditDiffCells = 4_000_000
+
+// ComputeEdits diffs original against refined word by word and returns the
+// changes in order. Changes separated only by whitespace become one edit, so
+// a rewritten phrase is reviewed as a whole.
+func ComputeEdits(original, refined string) []models.TextEdit {
+	edits := diffEdits(original, refined, true)
+	for k := range edits {
+		edits[k].ID = k + 1
+		edits[k].Type = editType(edits[k])
+		edits[k].Reason = editReason(edits[k])
+	}
+	return edits
+}
+
+// diffLines diffs texts with the same number of lines one line at a time
+func diffLines(original, refined string) ([]models.TextEdit, bool) {
+	originalLines, refinedLines := strings.SplitAfter(original, "\n"), strings.SplitAfter(refined, "\n")
+	if len(originalLines) < 2 || len(originalLines) != len(refinedLines) {
+		return nil, false
+	}
+	var edits []models.TextEdit
+	offset := 0
+	for i := range originalLines {
+		for _, edit := range diffEdits(originalLines[i], refinedLines[i], false) {
+			edit.Start += offset
+			edit.End += offset
+			edits = append(edits, edit)
+		}
+		offset += len([]rune(originalLines[i]))
+	}
+	return edits, true
+}
+
+func diffEdits(original, refined string, byLine bool) []models.TextEdit {
+	a, b := tokenizeForEdits(original), tokenizeForEdits(refined)
+
+	// Only the middle that differs needs the quadratic diff
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix].text == b[prefix].text {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix].text == b[len(b)-1-suffix].text {
+		suffix++
+	}
+	if (len(a)-prefix-suffix)*(len(b)-prefix-suffix) > maxEditDiffCells {
+		if byLine {
+			if edits, ok := diffLines(original, refined); ok {
+				return edits
+			}
+		}
+		// Too long to diff word by word: one edit covers the changed span
+		originalRunes := []rune(original)
+		start, end := len(originalRunes), len(originalRunes)
+		if prefix < len(a) {
+			start, end = a[prefix].start, a[len(a)-suffix-1].end
+		}
+		return []models.TextEdit{{
+			Start:       start,
+			End:         end,
+			Original:    string(originalRunes[start:end]),
+			Replacement: joinTokens(b[prefix : len(b)-suffix]),
+		}}
+	}
+	matches := matchTokens(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])
+
+	var edits []models.TextEdit
+	var pending *models.TextEdit
+	var gap []editToken // whitespace kept between two changes
+	flush := func() {
+		if pending != nil {
+			edits = append(edits, *pending)
+			pending = nil
+		}
+		gap = nil
+	}
+
+	i, j := prefix, prefix
+	originalRunes := []rune(original)
+	step := func(deleted, inserted []editToken, at int) {
+		if len(deleted) == 0 && len(inserted) == 0 {
+			return
+		}
+		start, end := at, at
+		if len(deleted) > 0 {
+			start, end = deleted[0].start, deleted[len(deleted)-1].end
+		}
+		replacement := joinTokens(inserted)
+		if pending != nil {
+			// Absorb the whitespace between the previous change and this one
+			replacement = pending.Replacement + joinTokens(gap) + replacement
+			start = pending.Start
+		}
+		pending = &models.TextEdit{Start: start, End: end, Replacement: replacement}
+		pending.Original = string(originalRunes[start:end])
+		gap = nil
+	}
+
+	for _, match := range append(matches, [2]int{len(a) - suffix - prefix, len(b) - suffix - prefix}) {
+		mi, mj := match[0]+prefix, match[1]+prefix
+		at := len(originalRunes)
+		if i < len(a) {
+			at = a[i].start
+		}
+		step(a[i:mi], b[j:mj], at)
+		i, j = mi, mj
+		if mi >= len(a)-suffix {
+			break
+		}
+		// The matched token: whitespace may join two changes, anything else ends one
+		if pending != nil && strings.TrimSpace(a[mi].text) == "" && len(gap) == 0 {
+			gap = append(gap, b[mj])
+		} else {
+			flush()
+		}
+		i, j = mi+1, mj+1
+	}
+	flush()
+	return edits
+}
+
+// matchTokens returns the index pairs of a longest common subsequence of a
+// and b, in order
+func matchTokens(a, b []editToken) [][2]int {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return nil
+	}
+	// lengths[i*(m+1)+j] is the LCS length of a[i:] and b[j:]
+	lengths := make([]int32, (n+1)*(m+1))
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i].text == b[j].text {
+				lengths[i*(m+1)+j] = lengths[(i+1)*(m+1)+j+1] + 1
+			} else {
+				lengths[i*(m+1)+j] = max(lengths[(i+1)*(m+1)+j], lengths[i*(m+1)+j+1])
+			}
+		}
+	}
+
+	var matches [][2]int
+	for i, j := 0, 0; i < n && j < m; {
+		switch {
+		case a[i].text == b[j].text:
+			matches = append(matches, [2]int{i, j})
+			i++
+			j++
+		case lengths[(i+1)*(m+1)+j] >= lengths[i*(m+1)+j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return matches
+}
+
+func joinTokens(tokens []editToken) string {
+	var sb strings.Builder
+	for _, token := range tokens {
+		sb.WriteString(token.text)
+	}
+	return sb.String()
+}
+
+func editType(edit models.TextEdit) string {
+	switch {
+	case edit.Original == "":
+		return models.EditInsert
+	case edit.Replacement == "":
+		return models.EditDelete
+	default:
+		return models.EditReplace
+	}
+}
+
+// editReason describes an edit from the kind of change it makes
+func editReason(edit models.TextEdit) string {
+	original, replacement := edit.Original, edit.Replacement
+	switch {
+	case strings.TrimSpace(original) == "" && strings.TrimSpace(replacement) == "":
+		return "Spacing"
+	case strings.EqualFold(original, replacement):
+		return "Capitalization"
+	case stripPunctuation(original) == stripPunctuation(replacement):
+		return "Punctuation"
+	}
+
+	originalWords, replacementWords := countEditWords(original), countEditWords(replacement)
+	switch {
+	case originalWords == 1 && replacementWords == 1:
+		a, b := stripPunctuation(original), stripPunctuation(replacement)
+		if len(a) > 2 && editDistance(a, b) <= 2 {
+			return "Spelling"
+		}
+		return "Word choice"
+	case originalWords == 0:
+		return "Added for clarity"
+	case replacementWords == 0:
+		return "Removed unnecessary words"
+	default:
+		return "Rephrased for clarity"
+	}
+}
+
+func countEditWords(s string) int {
+	n := 0
+	for _, token := range tokenizeForEdits(s) {
+		if isEditWordRune([]rune(token.text)[0]) {
+			n++
+		}
+	}
+	return n
+}
+
+// stripPunctuation keeps only letters and digits, lowercased
+func stripPunctuation(s string) string {
+	return strings.Map(func(r rune) rune {
+		if isEditWordRune(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+// ApplyEdits applies the accepted edits to text and returns the result with
+// the number of edits applied. Every accepted edit must still match the text
+// at its offsets and none may overlap, otherwise ErrEditMismatch is returned.
+func ApplyEdits(text string, edits []models.TextEdit, accepted []int) (string, int, error) {
+	byID := make(map[int]models.TextEdit, len(edits))
+	for _, edit := range edits {
+		byID[edit.ID] = edit
+	}
+
+	runes := []rune(text)
+	var chosen []models.TextEdit
+	seen := make(map[int]bool)
+	for _, id := range accepted {
+		edit, ok := byID[id]
+		if !ok || seen[id] {
+			return "", 0, ErrEditMismatch
+		}
+		seen[id] = true
+		if edit.Start < 0 || edit.Start > edit.End || edit.End > len(runes) ||
+			string(runes[edit.Start:edit.End]) != edit.Original {
+			return "", 0, ErrEditMismatch
+		}
+		chosen = append(chosen, edit)
+	}
+	sort.Slice(chosen, func(i, j int) bool { return chosen[i].Start < chosen[j].Start })
+
+	var sb strings.Builder
+	position := 0
+	for _, edit := range chosen {
+		if edit.Start < position {
+			return "", 0, ErrEditMismatch
+		}
+		sb.WriteString(string(runes[position:edit.Start]))
+		sb.WriteString(edit.Replacement)
+		position = edit.End
+	}
+	sb.WriteString(string(runes[position:]))
+	return sb.String(), len(chosen), nil
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"errors"
+	"personal-diary/models"
+	"strings"
+	"testing"
+)
+
+func TestComputeEditsUsesRuneOffsets(t *testing.T) {
+	tests := []struct {
+		name     string
+		original string
+		refined  string
+		typo     string
+	}{
+		{"accents before the change", "Le café était fermé, on est rentré tôt aprés.", "Le café était fermé, on est rentré tôt après.", "aprés"},
+		{"emoji before the change", "🌧️🌧️ Rainy day, stayed in and red.", "🌧️🌧️ Rainy day, stayed in and read.", "red"},
+		{"multibyte lines before the change", "日記\nÇa va.\nWe walkd home.", "日記\nÇa va.\nWe walked home.", "walkd"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edits := ComputeEdits(tt.original, tt.refined)
+			if len(edits) != 1 {
+				t.Fatalf("got %d edits, want 1: %+v", len(edits), edits)
+			}
+			edit := edits[0]
+			prefix := tt.original[:strings.Index(tt.original, tt.typo)]
+			if want := len([]rune(prefix)); edit.Start != want {
+				t.Errorf("Start = %d, want rune offset %d (byte offset is %d)", edit.Start, want, len(prefix))
+			}
+			if got := string([]rune(tt.original)[edit.Start:edit.End]); got != tt.typo {
+				t.Errorf("offsets %d-%d cover %q, want %q", edit.Start, edit.End, got, tt.typo)
+			}
+		})
+	}
+}
+
+func TestApplyEditsKeepsOffsetsOfRejectedEdits(t *testing.T) {
+	// Rejecting the first edit, which changes the length of the text, must
+	// not move the second
+	original := "Thé was über nice, we stayd late."
+	edits := ComputeEdits(original, "The tea was very nice, we stayed late.")
+	last := edits[len(edits)-1]
+	if last.Original != "stayd" {
+		t.Fatalf("last edit is %+v, want the fix of %q", last, "stayd")
+	}
+
+	got, applied, err := ApplyEdits(original, edits, []int{last.ID})
+	if err != nil {
+		t.Fatalf("ApplyEdits: %v", err)
+	}
+	if want := "Thé was über nice, we stayed late."; got != want || applied != 1 {
+		t.Errorf("ApplyEdits = %q, %d; want %q, 1", got, applied, want)
+	}
+}
+
+func TestApplyEditsRejectsByteOffsets(t *testing.T) {
+	// Offsets counted in bytes, as a client might, land elsewhere in a text
+	// with accents and must not be applied there
+	text := "Très très fatigué."
+	start := strings.Index(text, "fatigué")
+	edit := models.TextEdit{ID: 1, Start: start, End: start + len("fatigué"), Original: "fatigué", Replacement: "épuisé"}
+	if _, _, err := ApplyEdits(text, []models.TextEdit{edit}, []int{1}); !errors.Is(err, ErrEditMismatch) {
+		t.Errorf("ApplyEdits with byte offsets: error = %v, want ErrEditMismatch", err)
+	}
+
+	edit.Start, edit.End = len([]rune(text[:start])), len([]rune(text[:start]))+len([]rune("fatigué"))
+	got, _, err := ApplyEdits(text, []models.TextEdit{edit}, []int{1})
+	if err != nil || got != "Très très épuisé." {
+		t.Errorf("ApplyEdits with rune offsets = %q, %v", got, err)
+	}
+}
+
+func TestApplyEditsRejectsOverlap(t *testing.T) {
+	text := "one two three"
+	edits := []models.TextEdit{
+		{ID: 1, Start: 0, End: 7, Original: "one two", Replacement: "1 2"},
+		{ID: 2, Start: 4, End: 7, Original: "two", Replacement: "2"},
+	}
+	if _, _, err := ApplyEdits(text, edits, []int{2, 1}); !errors.Is(err, ErrEditMismatch) {
+		t.Errorf("ApplyEdits error = %v, want ErrEditMismatch", err)
+	}
+}
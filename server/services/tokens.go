@@ -0,0 +1,80 @@
+package services
+
+import (
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// modelTokens describes how a model family tokenizes text and how much it
+// can write in one reply
+type modelTokens struct {
+	charsPerToken   float64 // for ASCII text
+	maxOutputTokens int
+	contextTokens   int // prompt and reply together
+}
+
+// modelTokenProfiles are matched by the longest prefix like modelPrices;
+// unknown models use defaultModelTokens
+var modelTokenProfiles = map[string]modelTokens{
+	"gpt-4o":        {charsPerToken: 4.0, maxOutputTokens: 16384, contextTokens: 128000},
+	"gpt-4.1":       {charsPerToken: 4.0, maxOutputTokens: 32768, contextTokens: 1047576},
+	"gpt-4":         {charsPerToken: 3.7, maxOutputTokens: 8192, contextTokens: 8192},
+	"gpt-3.5-turbo": {charsPerToken: 3.7, maxOutputTokens: 4096, contextTokens: 16385},
+	"gemini-1.5":    {charsPerToken: 4.0, maxOutputTokens: 8192, contextTokens: 1048576},
+	"gemini-2.0":    {charsPerToken: 4.0, maxOutputTokens: 8192, contextTokens: 1048576},
+	"fake":          {charsPerToken: 4.0, maxOutputTokens: 1 << 20, contextTokens: 1 << 20},
+}
+
+var defaultModelTokens = modelTokens{charsPerToken: 3.5, maxOutputTokens: 4096, contextTokens: 8192}
+
+func lookupModelTokens(model string) modelTokens {
+	model = strings.ToLower(model)
+	best := ""
+	for name := range modelTokenProfiles {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if profile, ok := modelTokenProfiles[best]; ok {
+		return profile
+	}
+	return defaultModelTokens
+}
+
+// EstimateModelTokens approximates how many tokens model uses for text.
+// ASCII text follows the model's characters per token; other scripts take
+// far more tokens per character, CJK about one per character.
+func EstimateModelTokens(model, text string) int {
+	profile := lookupModelTokens(model)
+	ascii, wide, other := 0, 0, 0
+	for _, r := range text {
+		switch {
+		case r < utf8.RuneSelf:
+			ascii++
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			wide++
+		default:
+			other++
+		}
+	}
+	return int(math.Ceil(float64(ascii)/profile.charsPerToken + float64(wide) + float64(other)/2))
+}
+
+// ModelMaxOutputTokens is the longest reply model can write
+func ModelMaxOutputTokens(model string) int {
+	return lookupModelTokens(model).maxOutputTokens
+}
+
+// ModelContextTokens is how many tokens model can take in for a prompt and
+// its reply together
+func ModelContextTokens(model string) int {
+	return lookupModelTokens(model).contextTokens
+}
+
+// refineMaxTokens leaves room for a refinement of text to run somewhat
+// longer than the text itself, within what the model can write
+func refineMaxTokens(model, text string) int {
+	return min(ModelMaxOutputTokens(model), max(500, EstimateModelTokens(model, text)*3/2+100))
+}
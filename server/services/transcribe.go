@@ -0,0 +1,210 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"personal-diary/models"
+	"strings"
+	"unicode/utf8"
+)
+
+const defaultOpenAITranscriptionModel = "whisper-1"
+
+// Transcript is what was said in a recording
+type Transcript struct {
+	Text string
+	// Language is as reported by the provider and may be empty
+	Language string
+	// Duration of the recording in seconds, or 0 when unknown
+	Duration float64
+	Segments []models.TranscriptSegment
+}
+
+// Transcriber turns recorded speech into text with timestamps
+type Transcriber interface {
+	Name() string
+	Model() string
+	// Transcribe transcribes audio, the contents of a file called filename.
+	// language is an optional ISO-639-1 hint such as "en".
+	Transcribe(ctx context.Context, audio []byte, filename, language string) (*Transcript, error)
+}
+
+// NewTranscriberFromEnv builds the transcriber selected by
+// TRANSCRIPTION_PROVIDER ("openai" or "fake"). It defaults to "fake" for the
+// fake LLM provider and to "openai" otherwise. TRANSCRIPTION_MODEL overrides
+// the default model.
+func NewTranscriberFromEnv() (Transcriber, error) {
+	name := strings.ToLower(os.Getenv("TRANSCRIPTION_PROVIDER"))
+	if name == "" && strings.ToLower(os.Getenv("LLM_PROVIDER")) == "fake" {
+		name = "fake"
+	}
+	switch name {
+	case "", "openai":
+		return NewOpenAITranscriber(os.Getenv("OPENAI_BASE_URL"), os.Getenv("OPENAI_API_KEY"), os.Getenv("TRANSCRIPTION_MODEL")), nil
+	case "fake":
+		return NewFakeTranscriber(), nil
+	default:
+		return nil, fmt.Errorf("unknown TRANSCRIPTION_PROVIDER %q", name)
+	}
+}
+
+// OpenAITranscriber calls an OpenAI-compatible /audio/transcriptions endpoint
+type OpenAITranscriber struct {
+	baseURL string
+	apiKey  string
+	model   string
+	client  *LLMClient
+}
+
+func NewOpenAITranscriber(baseURL, apiKey, model string) *OpenAITranscriber {
+	if baseURL == "" {
+		baseURL = defaultOpenAIBaseURL
+	}
+	if model == "" {
+		model = defaultOpenAITranscriptionModel
+	}
+	baseURL = strings.TrimRight(baseURL, "/")
+	return &OpenAITranscriber{
+		baseURL: baseURL,
+		apiKey:  apiKey,
+		model:   model,
+		client:  NewLLMClient(baseURL),
+	}
+}
+
+func (t *OpenAITranscriber) Name() string  { return "openai" }
+func (t *OpenAITranscriber) Model() string { return t.model }
+
+func (t *OpenAITranscriber) Transcribe(ctx context.Context, audio []byte, filename, language string) (*Transcript, error) {
+	if t.apiKey == "" && t.baseURL == defaultOpenAIBaseURL {
+		return nil, fmt.Errorf("OpenAI API key not configured: %w", ErrLLMNotConfigured)
+	}
+
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, fmt.Errorf("build request error: %v", err)
+	}
+	part.Write(audio)
+	fields := map[string]string{
+		"model": t.model,
+		// Segment timestamps only come with the verbose format
+		"response_format":           "verbose_json",
+		"timestamp_granularities[]": "segment",
+	}
+	if language != "" {
+		fields["language"] = language
+	}
+	for name, value := range fields {
+		writer.WriteField(name, value)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("build request error: %v", err)
+	}
+
+	body, err := t.client.Send(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", t.baseURL+"/audio/transcriptions", bytes.NewReader(form.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		if t.apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+t.apiKey)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed struct {
+		Text     string  `json:"text"`
+		Language string  `json:"language"`
+		Duration float64 `json:"duration"`
+		Segments []struct {
+			Start float64 `json:"start"`
+			End   float64 `json:"end"`
+			Text  string  `json:"text"`
+		} `json:"segments"`
+		Usage *struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, fmt.Errorf("unmarshal response error: %v", err)
+	}
+	if parsed.Usage != nil {
+		reportUsage(ctx, parsed.Usage.InputTokens, parsed.Usage.OutputTokens)
+	}
+
+	transcript := &Transcript{
+		Text:     strings.TrimSpace(parsed.Text),
+		Language: parsed.Language,
+		Duration: parsed.Duration,
+		Segments: []models.TranscriptSegment{},
+	}
+	for _, segment := range parsed.Segments {
+		if text := strings.TrimSpace(segment.Text); text != "" {
+			transcript.Segments = append(transcript.Segments, models.TranscriptSegment{Start: segment.Start, End: segment.End, Text: text})
+		}
+	}
+	// Models without segment timestamps still align as a single segment
+	if len(transcript.Segments) == 0 && transcript.Text != "" {
+		transcript.Segments = append(transcript.Segments, models.TranscriptSegment{End: transcript.Duration, Text: transcript.Text})
+	}
+	return transcript, nil
+}
+
+// fakeSecondsPerWord paces the fake transcriber's timestamps
+const fakeSecondsPerWord = 0.4
+
+// FakeTranscriber is a deterministic, offline transcriber for tests and
+// local development. Audio that is valid UTF-8 text is "heard" as that
+// text, one segment per line, so tests can upload a text file as a
+// recording; anything else yields Reply (or a fixed sentence when Reply is
+// empty).
+type FakeTranscriber struct {
+	Reply string
+}
+
+func NewFakeTranscriber() *FakeTranscriber {
+	return &FakeTranscriber{}
+}
+
+func (t *FakeTranscriber) Name() string  { return "fake" }
+func (t *FakeTranscriber) Model() string { return "fake" }
+
+func (t *FakeTranscriber) Transcribe(ctx context.Context, audio []byte, filename, language string) (*Transcript, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	text := t.Reply
+	if utf8.Valid(audio) && !bytes.ContainsRune(audio, 0) {
+		text = string(audio)
+	} else if text == "" {
+		text = fmt.Sprintf("This is a voice note of %d bytes.", len(audio))
+	}
+
+	transcript := &Transcript{Language: language, Segments: []models.TranscriptSegment{}}
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		words := strings.Fields(line)
+		if len(words) == 0 {
+			continue
+		}
+		line = strings.Join(words, " ")
+		lines = append(lines, line)
+		start := transcript.Duration
+		transcript.Duration += float64(len(words)) * fakeSecondsPerWord
+		transcript.Segments = append(transcript.Segments, models.TranscriptSegment{Start: start, End: transcript.Duration, Text: line})
+	}
+	transcript.Text = strings.Join(lines, "\n")
+	return transcript, nil
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"personal-diary/models"
+	"sort"
+	"strings"
+)
+
+// MaxTranslationChars bounds entries that are translated in one call, so the
+// translation fits the model's output limit
+const MaxTranslationChars = 12000
+
+// translationLanguages are the languages entries can be translated into,
+// by ISO 639-1 code
+var translationLanguages = map[string]string{
+	"ar": "Arabic", "cs": "Czech", "da": "Danish", "de": "German",
+	"el": "Greek", "en": "English", "es": "Spanish", "fi": "Finnish",
+	"fr": "French", "he": "Hebrew", "hi": "Hindi", "id": "Indonesian",
+	"it": "Italian", "ja": "Japanese", "ko": "Korean", "nl": "Dutch",
+	"no": "Norwegian", "pl": "Polish", "pt": "Portuguese", "ro": "Romanian",
+	"ru": "Russian", "sv": "Swedish", "th": "Thai", "tr": "Turkish",
+	"uk": "Ukrainian", "vi": "Vietnamese", "zh": "Chinese",
+}
+
+// LookupLanguage resolves an ISO 639-1 code or English language name to a
+// supported language code
+func LookupLanguage(language string) (string, bool) {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if _, ok := translationLanguages[language]; ok {
+		return language, true
+	}
+	for code, name := range translationLanguages {
+		if strings.ToLower(name) == language {
+			return code, true
+		}
+	}
+	return "", false
+}
+
+// TranslationLanguageCodes lists the supported language codes in order
+func TranslationLanguageCodes() []string {
+	codes := make([]string, 0, len(translationLanguages))
+	for code := range translationLanguages {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Translation is a translated entry
+type Translation struct {
+	Title   string
+	Content string
+	// SourceLanguage is the request's source language, or what the model
+	// detected, lowercased; it may be a language that is not supported
+	SourceLanguage string
+	PromptVersion  string
+}
+
+// translationInput is sent to the model; the paragraphs come back in a list
+// of the same length so the entry keeps its structure
+type translationInput struct {
+	Title      string   `json:"title"`
+	Paragraphs []string `json:"paragraphs"`
+}
+
+type translationReply struct {
+	SourceLanguage string   `json:"sourceLanguage"`
+	Title          string   `json:"title"`
+	Paragraphs     []string `json:"paragraphs"`
+}
+
+// TranslateEntry translates an entry's title and content into target, a
+// supported language code. source is a supported code or empty to detect it.
+// Line breaks and blank lines are kept exactly: only non-blank lines are
+// translated and put back in their places.
+func TranslateEntry(ctx context.Context, provider LLMProvider, entry models.DiaryEntry, source, target string) (*Translation, error) {
+	lines := strings.Split(entry.Content, "\n")
+	var paragraphs []string
+	for _, line := range lines {
+		if _, core, _ := splitSurroundingSpace(line); core != "" {
+			paragraphs = append(paragraphs, core)
+		}
+	}
+
+	prompt, version, err := renderPrompt(ctx, PromptTranslate, TranslatePromptData{
+		SourceLanguage: translationLanguages[source],
+		TargetLanguage: translationLanguages[target],
+	})
+	if err != nil {
+		return nil, err
+	}
+	input, err := json.Marshal(translationInput{Title: entry.Title, Paragraphs: paragraphs})
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := provider.Complete(ctx, CompletionRequest{
+		Messages: []models.Message{
+			{Role: "system", Content: prompt},
+			{Role: "user", Content: string(input)},
+		},
+		Temperature: 0.2,
+		// Translations can run longer than the original
+		MaxTokens: min(8000, 2*estimateTokens(entry.Title, entry.Content)+200),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed translationReply
+	if err := parseJSONReply(reply, &parsed); err != nil {
+		return nil, err
+	}
+	if len(parsed.Paragraphs) != len(paragraphs) {
+		return nil, fmt.Errorf("translation has %d paragraphs, expected %d", len(parsed.Paragraphs), len(paragraphs))
+	}
+
+	// Lines keep their indentation, which the model is not shown
+	next := 0
+	for i, line := range lines {
+		if lead, core, trail := splitSurroundingSpace(line); core != "" {
+			lines[i] = lead + strings.TrimSpace(parsed.Paragraphs[next]) + trail
+			next++
+		}
+	}
+
+	translation := &Translation{
+		Title:          strings.TrimSpace(parsed.Title),
+		Content:        strings.Join(lines, "\n"),
+		SourceLanguage: source,
+		PromptVersion:  version,
+	}
+	if source == "" {
+		translation.SourceLanguage = strings.ToLower(strings.TrimSpace(parsed.SourceLanguage))
+		if code, ok := LookupLanguage(translation.SourceLanguage); ok {
+			translation.SourceLanguage = code
+		}
+	}
+	return translation, nil
+}
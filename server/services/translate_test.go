@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"personal-diary/models"
+	"strings"
+	"testing"
+)
+
+// upperCaseTranslator "translates" by upper-casing each paragraph, padded
+// with the stray whitespace models tend to add
+func upperCaseTranslator() *FakeProvider {
+	return &FakeProvider{Reply: func(req CompletionRequest) string {
+		var input translationInput
+		json.Unmarshal([]byte(req.Messages[len(req.Messages)-1].Content), &input)
+		reply := translationReply{SourceLanguage: "fr", Title: strings.ToUpper(input.Title)}
+		for _, paragraph := range input.Paragraphs {
+			reply.Paragraphs = append(reply.Paragraphs, " "+strings.ToUpper(paragraph)+"\n")
+		}
+		encoded, _ := json.Marshal(reply)
+		return string(encoded)
+	}}
+}
+
+func TestTranslateEntryKeepsLayout(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"plain", "Bonjour.\nAu revoir.", "BONJOUR.\nAU REVOIR."},
+		{"blank lines", "Bonjour.\n\n\nAu revoir.\n", "BONJOUR.\n\n\nAU REVOIR.\n"},
+		{"indented list", "Courses:\n  - pain\n  - lait\n\tfin", "COURSES:\n  - PAIN\n  - LAIT\n\tFIN"},
+		{"windows line endings", "Bonjour.\r\nAu revoir.\r\n", "BONJOUR.\r\nAU REVOIR.\r\n"},
+		{"whitespace-only lines", "Bonjour.\n   \nAu revoir.", "BONJOUR.\n   \nAU REVOIR."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := models.DiaryEntry{Title: "Mon jour", Content: tt.content}
+			translation, err := TranslateEntry(context.Background(), upperCaseTranslator(), entry, "", "en")
+			if err != nil {
+				t.Fatalf("TranslateEntry: %v", err)
+			}
+			if translation.Content != tt.want {
+				t.Errorf("Content = %q, want %q", translation.Content, tt.want)
+			}
+			if translation.Title != "MON JOUR" || translation.SourceLanguage != "fr" {
+				t.Errorf("Title, SourceLanguage = %q, %q", translation.Title, translation.SourceLanguage)
+			}
+		})
+	}
+}
+
+func TestTranslateEntryRejectsMissingParagraphs(t *testing.T) {
+	provider := &FakeProvider{Reply: func(CompletionRequest) string {
+		return `{"sourceLanguage": "fr", "title": "DAY", "paragraphs": ["ONLY ONE"]}`
+	}}
+	entry := models.DiaryEntry{Title: "Jour", Content: "Un.\nDeux."}
+	if _, err := TranslateEntry(context.Background(), provider, entry, "", "en"); err == nil {
+		t.Error("TranslateEntry accepted a reply with a paragraph missing")
+	}
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"personal-diary/models"
+	"personal-diary/utils"
+	"strconv"
+	"time"
+)
+
+// ErrQuotaExceeded is matched by QuotaExceededError with errors.Is
+var ErrQuotaExceeded = errors.New("AI quota exceeded")
+
+// QuotaExceededError reports which limit a user hit and when it resets
+type QuotaExceededError struct {
+	Period   string // "daily" or "monthly"
+	Limit    int64
+	Used     int64
+	ResetsAt time.Time
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s AI quota exceeded: %d of %d tokens used, resets at %s",
+		e.Period, e.Used, e.Limit, e.ResetsAt.UTC().Format(time.RFC3339))
+}
+
+func (e *QuotaExceededError) Is(target error) bool { return target == ErrQuotaExceeded }
+
+// UsageStore persists the usage ledger and per-user quota overrides
+type UsageStore interface {
+	Record(ctx context.Context, record models.AIUsageRecord) error
+	Totals(ctx context.Context, email string, since time.Time) (models.AIUsageTotals, error)
+	// Quota returns the user's override, or nil when the defaults apply
+	Quota(ctx context.Context, email string) (*models.AIQuota, error)
+}
+
+// UsageCall describes a metered call
+type UsageCall struct {
+	Kind     string
+	Provider string
+	Model    string
+	Images   int
+	// Estimate returns token counts for when the provider reports none
+	Estimate func() (promptTokens, completionTokens int)
+}
+
+// UsageMeter enforces per-user token quotas and records every AI call
+type UsageMeter struct {
+	store    UsageStore
+	defaults models.AIQuota
+}
+
+// NewUsageMeter uses AI_DAILY_TOKEN_LIMIT and AI_MONTHLY_TOKEN_LIMIT as the
+// default quotas (0 for unlimited; default 100k and 1M tokens)
+func NewUsageMeter(store UsageStore) *UsageMeter {
+	return &UsageMeter{
+		store: store,
+		defaults: models.AIQuota{
+			DailyTokens:   envTokenLimit("AI_DAILY_TOKEN_LIMIT", 100_000),
+			MonthlyTokens: envTokenLimit("AI_MONTHLY_TOKEN_LIMIT", 1_000_000),
+		},
+	}
+}
+
+func envTokenLimit(name string, fallback int64) int64 {
+	if limit, err := strconv.ParseInt(os.Getenv(name), 10, 64); err == nil && limit >= 0 {
+		return limit
+	}
+	return fallback
+}
+
+// Limits returns the quota that applies to the user
+func (m *UsageMeter) Limits(ctx context.Context, email string) (models.AIQuota, error) {
+	quota, err := m.store.Quota(ctx, email)
+	if err != nil || quota == nil {
+		return m.defaults, err
+	}
+	return *quota, nil
+}
+
+// Totals sums the user's usage since the given time
+func (m *UsageMeter) Totals(ctx context.Context, email string, since time.Time) (models.AIUsageTotals, error) {
+	return m.store.Totals(ctx, email, since)
+}
+
+// CheckQuota returns a QuotaExceededError when the user has used up their
+// daily or monthly tokens
+func (m *UsageMeter) CheckQuota(ctx context.Context, email string) error {
+	quota, err := m.Limits(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	dayStart, monthStart := QuotaPeriodStarts(time.Now())
+	periods := []struct {
+		name   string
+		limit  int64
+		since  time.Time
+		resets time.Time
+	}{
+		{"daily", quota.DailyTokens, dayStart, dayStart.AddDate(0, 0, 1)},
+		{"monthly", quota.MonthlyTokens, monthStart, monthStart.AddDate(0, 1, 0)},
+	}
+	for _, period := range periods {
+		if period.limit <= 0 {
+			continue
+		}
+		used, err := m.store.Totals(ctx, email, period.since)
+		if err != nil {
+			return err
+		}
+		if used.TotalTokens >= period.limit {
+			return &QuotaExceededError{Period: period.name, Limit: period.limit, Used: used.TotalTokens, ResetsAt: period.resets}
+		}
+	}
+	return nil
+}
+
+// QuotaPeriodStarts returns the start of the current UTC day and month
+func QuotaPeriodStarts(now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC),
+		time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Track runs an AI call for the user in ctx: it checks the quota first and
+// records the call's tokens and estimated cost afterwards. Background work
+// done for a user, such as jobs and mood analysis, carries their email and
+// is limited too; only calls without a user are recorded unlimited. Failed
+// calls are only recorded when the provider reported usage for them or when
+// they were cancelled midway, since generation was already paid for.
+func (m *UsageMeter) Track(ctx context.Context, call UsageCall, run func(ctx context.Context) error) error {
+	email := utils.UserEmailFromContext(ctx)
+	if email != "" {
+		if err := m.CheckQuota(ctx, email); err != nil {
+			return err
+		}
+	}
+
+	report := &usageReport{}
+	err := run(context.WithValue(ctx, usageReportKey{}, report))
+	if err != nil && !report.reported && !errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	record := models.AIUsageRecord{
+		Email:            email,
+		Kind:             call.Kind,
+		Provider:         call.Provider,
+		Model:            call.Model,
+		PromptTokens:     report.promptTokens,
+		CompletionTokens: report.completionTokens,
+		Images:           call.Images,
+		PromptVersion:    report.promptVersion,
+		CreatedAt:        time.Now(),
+	}
+	if !report.reported && call.Estimate != nil {
+		record.PromptTokens, record.CompletionTokens = call.Estimate()
+		record.Estimated = true
+	}
+	record.TotalTokens = record.PromptTokens + record.CompletionTokens
+	record.CostUSD = EstimateCost(call.Model, record.PromptTokens, record.CompletionTokens, call.Images)
+
+	// Record even when the request was cancelled mid-stream
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if saveErr := m.store.Record(saveCtx, record); saveErr != nil {
+		log.Printf("Failed to record AI usage: %v", saveErr)
+	}
+	return err
+}
+
+type usageReportKey struct{}
+
+type usageReport struct {
+	promptTokens     int
+	completionTokens int
+	reported         bool
+	promptVersion    string
+}
+
+// reportUsage lets a provider pass the token counts of its responses to the
+// UsageMeter tracking the call, if any. Calls made of several requests
+// report each of them.
+func reportUsage(ctx context.Context, promptTokens, completionTokens int) {
+	if report, ok := ctx.Value(usageReportKey{}).(*usageReport); ok {
+		report.promptTokens += promptTokens
+		report.completionTokens += completionTokens
+		report.reported = true
+	}
+}
+
+// reportPromptVersion records which registry prompt the tracked call used
+func reportPromptVersion(ctx context.Context, label string) {
+	if report, ok := ctx.Value(usageReportKey{}).(*usageReport); ok {
+		report.promptVersion = label
+	}
+}
+
+// estimateTokens approximates a token count as one token per four bytes
+func estimateTokens(texts ...string) int {
+	n := 0
+	for _, text := range texts {
+		n += len(text)
+	}
+	return (n + 3) / 4
+}
+
+func estimateMessageTokens(messages []models.Message) int {
+	n := 0
+	for _, message := range messages {
+		n += estimateTokens(message.Content) + 4 // role and separators
+	}
+	return n
+}
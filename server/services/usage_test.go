@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"personal-diary/models"
+	"testing"
+	"time"
+)
+
+func TestQuotaPeriodStarts(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	utc := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name      string
+		now       time.Time
+		wantDay   time.Time
+		wantMonth time.Time
+	}{
+		{"midnight starts the day", utc(2024, 3, 5, 0, 0), utc(2024, 3, 5, 0, 0), utc(2024, 3, 1, 0, 0)},
+		{"last minute of the day", utc(2024, 3, 5, 23, 59), utc(2024, 3, 5, 0, 0), utc(2024, 3, 1, 0, 0)},
+		{"leap day", utc(2024, 2, 29, 12, 0), utc(2024, 2, 29, 0, 0), utc(2024, 2, 1, 0, 0)},
+		{"new year", utc(2025, 1, 1, 0, 30), utc(2025, 1, 1, 0, 0), utc(2025, 1, 1, 0, 0)},
+		// Periods follow UTC, not the server's zone: 20:30 in New York on
+		// 31 March is already 1 April in UTC
+		{"local evening is the next UTC month", time.Date(2024, 3, 31, 20, 30, 0, 0, newYork), utc(2024, 4, 1, 0, 0), utc(2024, 4, 1, 0, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			day, month := QuotaPeriodStarts(tt.now)
+			if !day.Equal(tt.wantDay) || !month.Equal(tt.wantMonth) {
+				t.Errorf("QuotaPeriodStarts = %s, %s; want %s, %s", day, month, tt.wantDay, tt.wantMonth)
+			}
+		})
+	}
+}
+
+// memoryUsageStore keeps usage records in memory
+type memoryUsageStore struct {
+	records []models.AIUsageRecord
+	quota   models.AIQuota
+}
+
+func (s *memoryUsageStore) Record(ctx context.Context, record models.AIUsageRecord) error {
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *memoryUsageStore) Totals(ctx context.Context, email string, since time.Time) (models.AIUsageTotals, error) {
+	var totals models.AIUsageTotals
+	for _, record := range s.records {
+		if record.Email == email && !record.CreatedAt.Before(since) {
+			totals.TotalTokens += int64(record.TotalTokens)
+		}
+	}
+	return totals, nil
+}
+
+func (s *memoryUsageStore) Quota(ctx context.Context, email string) (*models.AIQuota, error) {
+	return &s.quota, nil
+}
+
+func TestCheckQuotaPeriodBoundaries(t *testing.T) {
+	const email = "anna@example.com"
+	dayStart, monthStart := QuotaPeriodStarts(time.Now())
+	used := func(tokens int, at time.Time) models.AIUsageRecord {
+		return models.AIUsageRecord{Email: email, TotalTokens: tokens, CreatedAt: at}
+	}
+
+	tests := []struct {
+		name    string
+		quota   models.AIQuota
+		records []models.AIUsageRecord
+		period  string // "" when the call is allowed
+		resets  time.Time
+	}{
+		{
+			name:    "usage before midnight UTC is yesterday's",
+			quota:   models.AIQuota{DailyTokens: 100},
+			records: []models.AIUsageRecord{used(500, dayStart.Add(-time.Second)), used(99, dayStart)},
+		},
+		{
+			name:    "reaching the limit exactly blocks",
+			quota:   models.AIQuota{DailyTokens: 100},
+			records: []models.AIUsageRecord{used(60, dayStart), used(40, dayStart.Add(time.Hour))},
+			period:  "daily",
+			resets:  dayStart.AddDate(0, 0, 1),
+		},
+		{
+			name:    "usage before the first of the month is last month's",
+			quota:   models.AIQuota{MonthlyTokens: 100},
+			records: []models.AIUsageRecord{used(500, monthStart.Add(-time.Second)), used(99, monthStart)},
+		},
+		{
+			name:    "monthly limit resets on the first of next month",
+			quota:   models.AIQuota{DailyTokens: 1000, MonthlyTokens: 100},
+			records: []models.AIUsageRecord{used(100, monthStart)},
+			period:  "monthly",
+			resets:  monthStart.AddDate(0, 1, 0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meter := NewUsageMeter(&memoryUsageStore{records: tt.records, quota: tt.quota})
+			err := meter.CheckQuota(context.Background(), email)
+			if tt.period == "" {
+				if err != nil {
+					t.Errorf("CheckQuota = %v, want the call allowed", err)
+				}
+				return
+			}
+			var quotaErr *QuotaExceededError
+			if !errors.As(err, &quotaErr) {
+				t.Fatalf("CheckQuota = %v, want a %s QuotaExceededError", err, tt.period)
+			}
+			if quotaErr.Period != tt.period || !quotaErr.ResetsAt.Equal(tt.resets) {
+				t.Errorf("got %s quota resetting at %s, want %s at %s", quotaErr.Period, quotaErr.ResetsAt, tt.period, tt.resets)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// indexedVector is one entry in the VectorIndex
+type indexedVector struct {
+	vector    []float32
+	createdAt time.Time
+}
+
+// VectorMatch is an entry found by VectorIndex.Nearest
+type VectorMatch struct {
+	EntryID string
+	Score   float64
+}
+
+// VectorIndex keeps one embedding per diary entry in memory so similar
+// entries can be found without reading every vector from the database.
+// Users are loaded on first use and kept up to date with Upsert and Remove.
+type VectorIndex struct {
+	mu      sync.RWMutex
+	entries map[string]map[string]indexedVector // email -> entry ID -> vector
+}
+
+func NewVectorIndex() *VectorIndex {
+	return &VectorIndex{entries: make(map[string]map[string]indexedVector)}
+}
+
+// Loaded reports whether the user's vectors are in the index
+func (x *VectorIndex) Loaded(email string) bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	_, ok := x.entries[email]
+	return ok
+}
+
+// Load adds the user's stored vectors. Vectors upserted since the caller
+// read them from the database are newer and are kept.
+func (x *VectorIndex) Load(email string, vectors map[string][]float32, createdAt map[string]time.Time) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	user := x.entries[email]
+	if user == nil {
+		user = make(map[string]indexedVector, len(vectors))
+		x.entries[email] = user
+	}
+	for id, vector := range vectors {
+		if _, ok := user[id]; !ok {
+			user[id] = indexedVector{vector: vector, createdAt: createdAt[id]}
+		}
+	}
+}
+
+// Upsert sets an entry's vector. Users that are not loaded yet are skipped;
+// their vectors are read from the database when first needed.
+func (x *VectorIndex) Upsert(email, entryID string, vector []float32, createdAt time.Time) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if user, ok := x.entries[email]; ok {
+		user[entryID] = indexedVector{vector: vector, createdAt: createdAt}
+	}
+}
+
+// Remove drops an entry from the index
+func (x *VectorIndex) Remove(email, entryID string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	delete(x.entries[email], entryID)
+}
+
+// Nearest returns up to k of the user's entries written before the given
+// entry, most similar first. ok is false when the entry is not indexed.
+func (x *VectorIndex) Nearest(email, entryID string, k int) ([]VectorMatch, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	user := x.entries[email]
+	target, ok := user[entryID]
+	if !ok {
+		return nil, false
+	}
+
+	matches := []VectorMatch{}
+	for id, candidate := range user {
+		if id == entryID || !candidate.createdAt.Before(target.createdAt) {
+			continue
+		}
+		matches = append(matches, VectorMatch{EntryID: id, Score: CosineSimilarity(target.vector, candidate.vector)})
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	if len(matches) > k {
+		matches = matches[:k]
+	}
+	return matches, true
+}
+
+// EntryVector combines an entry's chunk vectors into one by averaging them
+// and normalising the result
+func EntryVector(chunkVectors [][]float32) []float32 {
+	if len(chunkVectors) == 0 {
+		return nil
+	}
+	mean := make([]float32, len(chunkVectors[0]))
+	for _, vector := range chunkVectors {
+		if len(vector) != len(mean) {
+			continue
+		}
+		for i, v := range vector {
+			mean[i] += v
+		}
+	}
+	normalize(mean)
+	return mean
+}
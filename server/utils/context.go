@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"context"
+	"sync"
+)
+
+type userEmailKey struct{}
+
+// userScope is what WithUserEmail puts in a context: the user's email and
+// the values cached for them while the request or job lasts
+type userScope struct {
+	email  string
+	mu     sync.Mutex
+	values map[any]any
+}
+
+// WithUserEmail returns a context carrying the authenticated user's email.
+// It starts a new scope for values cached with UserValue.
+func WithUserEmail(ctx context.Context, email string) context.Context {
+	return context.WithValue(ctx, userEmailKey{}, &userScope{email: email})
+}
+
+// UserEmailFromContext returns the email set by WithUserEmail, or ""
+func UserEmailFromContext(ctx context.Context) string {
+	if scope, ok := ctx.Value(userEmailKey{}).(*userScope); ok {
+		return scope.email
+	}
+	return ""
+}
+
+// UserValue returns the value cached under key in the scope started by
+// WithUserEmail, calling load the first time. Errors are not cached, and
+// without a scope load is called every time.
+func UserValue(ctx context.Context, key any, load func() (any, error)) (any, error) {
+	scope, ok := ctx.Value(userEmailKey{}).(*userScope)
+	if !ok {
+		return load()
+	}
+	scope.mu.Lock()
+	defer scope.mu.Unlock()
+
+	if value, ok := scope.values[key]; ok {
+		return value, nil
+	}
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+	if scope.values == nil {
+		scope.values = make(map[any]any)
+	}
+	scope.values[key] = value
+	return value, nil
+}